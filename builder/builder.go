@@ -0,0 +1,141 @@
+/*
+Copyright 2016 Xuan Tang. All rights reserved.
+Use of this source code is governed by a license
+that can be found in the LICENSE file.
+*/
+
+// Package builder builds the images of functions and pushes them to the
+// registry, either with a docker daemon or inside the cluster with a
+// kubernetes job running a daemonless builder. The backend is chosen in
+// config.
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/xuant/go-kexec/docker"
+	"github.com/xuant/go-kexec/kexec"
+	"github.com/xuant/go-kexec/runtimes"
+)
+
+// Builder backends
+const (
+	BackendDocker     = "docker"
+	BackendKubernetes = "kubernetes"
+)
+
+var (
+	// Used when the config leaves them unset
+	DefaultDockerHost = "unix:///var/run/docker.sock"
+	DefaultBackend    = BackendDocker
+)
+
+// Builder builds the image of a function and pushes it to the registry.
+type Builder interface {
+	// BuildFunction builds and pushes the image of a function. It
+	// returns the digest of the pushed image.
+	BuildFunction(build *Build) (string, error)
+}
+
+// Build is a build of the image of a function.
+type Build struct {
+	// Reference the image is tagged and pushed with
+	Image        string
+	FunctionName string
+	Runtime      *runtimes.Runtime
+
+	// Account owning the function
+	UserName string
+
+	// Build context, holding the execution file of the runtime and the
+	// files of the function package. The runtime adds its own files.
+	ContextDir string
+
+	// Where the output of the build goes
+	Log io.Writer
+
+	// Called once the image is built, before it is pushed. Builders
+	// pushing as part of the build do not call it. Optional.
+	Pushing func()
+}
+
+type Config struct {
+	// Backend building images, BackendDocker or BackendKubernetes
+	Backend string
+
+	// Docker daemon of the docker backend
+	DockerHost string
+
+	Kubernetes KubernetesConfig
+}
+
+// New creates the builder of the backend chosen in config. The docker
+// backend pushes with `auth`, which can be nil; the kubernetes backend
+// creates its jobs with `k`.
+func New(config Config, k *kexec.Kexec, auth *docker.RegistryAuth) (Builder, error) {
+	backend := config.Backend
+	if backend == "" {
+		backend = DefaultBackend
+	}
+
+	switch backend {
+	case BackendDocker:
+		host := config.DockerHost
+		if host == "" {
+			host = DefaultDockerHost
+		}
+		d := docker.NewDocker(
+			// http headers
+			map[string]string{"User-Agent": "engin-api-cli-1.0"},
+			// docker host
+			host,
+			// docker api version
+			"v1.22",
+			// http client
+			nil,
+		)
+		return &Docker{d, auth}, nil
+	case BackendKubernetes:
+		return NewKubernetes(config.Kubernetes, k)
+	}
+	return nil, fmt.Errorf("Unknown builder backend %q", backend)
+}
+
+// PrepareContext prepares the build context of a function for any
+// backend: the execution file must be there, and the runtime writes the
+// Dockerfile and the files it needs.
+func PrepareContext(build *Build) error {
+	if _, err := os.Stat(filepath.Join(build.ContextDir, build.Runtime.FileName)); err != nil {
+		return errors.New("Execution file not found.")
+	}
+
+	if err := build.Runtime.WriteContext(build.ContextDir, build.FunctionName); err != nil {
+		return fmt.Errorf("Failed to set up runtime template: %v", err)
+	}
+	return nil
+}
+
+// Docker builds images with a docker daemon and pushes them from it.
+type Docker struct {
+	d    *docker.Docker
+	auth *docker.RegistryAuth
+}
+
+func (b *Docker) BuildFunction(build *Build) (string, error) {
+	if err := PrepareContext(build); err != nil {
+		return "", err
+	}
+
+	if err := b.d.BuildImage(build.Image, build.ContextDir, build.Log); err != nil {
+		return "", err
+	}
+
+	if build.Pushing != nil {
+		build.Pushing()
+	}
+	return b.d.PushFunction(build.Image, b.auth)
+}
@@ -0,0 +1,90 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xuant/go-kexec/docker"
+	"github.com/xuant/go-kexec/runtimes"
+)
+
+func TestPrepareContext(t *testing.T) {
+	registry, err := runtimes.Load("../runtimes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt, _ := registry.Get("python27")
+
+	ctxDir, err := ioutil.TempDir("", "builder-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(ctxDir)
+
+	build := &Build{
+		Image:        "localhost:5000/alice/hello:0123456789abcdef",
+		FunctionName: "hello",
+		Runtime:      rt,
+		ContextDir:   ctxDir,
+	}
+	if err := PrepareContext(build); err == nil {
+		t.Errorf("Prepared a context without execution file")
+	}
+
+	code, err := rt.WrapCode("def handler(event, context):\n    return event", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(ctxDir, rt.FileName), []byte(code), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := PrepareContext(build); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(ctxDir, docker.RelDockerfile)); err != nil {
+		t.Errorf("No Dockerfile in prepared context: %v", err)
+	}
+}
+
+func TestContextPath(t *testing.T) {
+	root := "/tmp/faas-imagebuild-context/"
+	if path, err := contextPath(root, root+"alice-0123"); err != nil || path != "alice-0123" {
+		t.Errorf("contextPath = %q, %v", path, err)
+	}
+	for _, ctxDir := range []string{root, "/tmp/elsewhere", root + "../elsewhere"} {
+		if path, err := contextPath(root, ctxDir); err == nil {
+			t.Errorf("contextPath(%q) = %q, want an error", ctxDir, path)
+		}
+	}
+}
+
+func TestBuilderArgs(t *testing.T) {
+	args := strings.Join(builderArgs("localhost:5000/alice/hello:0123", []string{"--insecure"}), " ")
+	for _, want := range []string{
+		"--context=dir:///workspace",
+		"--destination=localhost:5000/alice/hello:0123",
+		"--digest-file=/dev/termination-log",
+		"--insecure",
+	} {
+		if !strings.Contains(args, want) {
+			t.Errorf("Builder args %q lack %q", args, want)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(Config{Backend: "podman"}, nil, nil); err == nil {
+		t.Errorf("Created a builder with an unknown backend")
+	}
+	if _, err := New(Config{Backend: BackendKubernetes}, nil, nil); err == nil {
+		t.Errorf("Created a kubernetes builder without namespace")
+	}
+	if b, err := New(Config{}, nil, nil); err != nil {
+		t.Errorf("Default builder: %v", err)
+	} else if _, ok := b.(*Docker); !ok {
+		t.Errorf("Default builder is a %T", b)
+	}
+}
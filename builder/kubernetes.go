@@ -0,0 +1,222 @@
+/*
+Copyright 2016 Xuan Tang. All rights reserved.
+Use of this source code is governed by a license
+that can be found in the LICENSE file.
+*/
+
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wayn3h0/go-uuid"
+	"github.com/xuant/go-kexec/docker"
+	"github.com/xuant/go-kexec/kexec"
+	"github.com/xuant/go-kexec/naming"
+)
+
+var (
+	// Used when the config leaves them unset
+	DefaultBuilderImage = "gcr.io/kaniko-project/executor:latest"
+	DefaultBuildTimeout = 30 * time.Minute
+)
+
+type KubernetesConfig struct {
+	// Namespace build jobs run in. It must exist, along with the
+	// claim and the secret below.
+	Namespace string
+
+	// Image of the daemonless builder, kaniko or compatible, and
+	// extra arguments for it (eg --insecure)
+	Image string
+	Args  []string
+
+	// Persistent volume claim of the volume holding the build
+	// contexts, which the server must have mounted at docker.IBContext
+	ContextClaim string
+
+	// Secret of type kubernetes.io/dockerconfigjson holding the
+	// credentials of the registry
+	RegistrySecret string
+
+	// Seconds a build may take
+	Timeout int
+
+	// Compute resources of build jobs
+	Limits kexec.ResourceLimits
+}
+
+// Kubernetes builds images in the cluster: every build runs as a job,
+// which builds the image from the build context on a shared volume and
+// pushes it.
+type Kubernetes struct {
+	k       *kexec.Kexec
+	config  KubernetesConfig
+	timeout time.Duration
+}
+
+// NewKubernetes creates a kubernetes builder.
+func NewKubernetes(config KubernetesConfig, k *kexec.Kexec) (*Kubernetes, error) {
+	if config.Namespace == "" || config.ContextClaim == "" {
+		return nil, errors.New("Kubernetes builder needs a namespace and a context claim")
+	}
+	if k == nil {
+		return nil, errors.New("Kubernetes builder needs a cluster")
+	}
+	if config.Image == "" {
+		config.Image = DefaultBuilderImage
+	}
+
+	timeout := time.Duration(config.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = DefaultBuildTimeout
+	}
+
+	return &Kubernetes{k, config, timeout}, nil
+}
+
+func (b *Kubernetes) BuildFunction(build *Build) (string, error) {
+	if err := PrepareContext(build); err != nil {
+		return "", err
+	}
+
+	contextPath, err := contextPath(docker.IBContext, build.ContextDir)
+	if err != nil {
+		return "", err
+	}
+
+	id, err := uuid.NewTimeBased()
+	if err != nil {
+		return "", err
+	}
+	jobName := naming.JobName("build-"+build.FunctionName, id.String())
+	namespace := b.config.Namespace
+
+	limits := b.config.Limits
+	if limits.MaxRuntime <= 0 {
+		limits.MaxRuntime = int64(b.timeout / time.Second)
+	}
+
+	err = b.k.CreateBuildJob(jobName, namespace, &kexec.BuildJob{
+		Image: b.config.Image,
+		Args:  builderArgs(build.Image, b.config.Args),
+		Labels: map[string]string{
+			kexec.LabelBuild:     naming.Label(build.FunctionName),
+			kexec.LabelBuildUser: naming.Label(build.UserName),
+		},
+		ContextClaim:   b.config.ContextClaim,
+		ContextPath:    contextPath,
+		RegistrySecret: b.config.RegistrySecret,
+		Limits:         &limits,
+	})
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Building %s with job %s/%s", build.Image, namespace, jobName)
+
+	// The log is streamed while the job runs. The build ends with its
+	// first pod, so the stream does too, rather than waiting for the
+	// pods the job would run after a failure. A pod that never starts
+	// has no log, so the stream is not waited for then.
+	buildLog := &guardedWriter{w: build.Log}
+	streamed := make(chan struct{})
+	go func() {
+		defer close(streamed)
+		r, err := b.k.StreamFirstPodLog(jobName, namespace, b.timeout)
+		if err != nil {
+			return
+		}
+		defer r.Close()
+		io.Copy(buildLog, r)
+	}()
+
+	result, err := b.k.WaitForPodComplete(jobName, namespace, b.timeout)
+	if err == nil || isRunFailure(err) {
+		<-streamed
+	}
+	buildLog.close()
+
+	// The log is in the build record, and a failed build job must not
+	// be retried. Jobs that timed out are deleted already.
+	if e, ok := err.(*kexec.PodError); !ok || e.Reason != kexec.ReasonTimeout {
+		if err := b.k.DeleteJob(jobName, namespace); err != nil {
+			log.Printf("Failed to delete build job %s/%s: %v", namespace, jobName, err)
+		}
+	}
+
+	if e, ok := err.(*kexec.PodError); ok && e.Reason == kexec.ReasonFailed {
+		return "", fmt.Errorf("Build failed with exit code %d", e.ExitCode)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Build failed: %v", err)
+	}
+
+	digest := strings.TrimSpace(result.Message)
+	if !strings.HasPrefix(digest, "sha256:") {
+		return "", fmt.Errorf("Build job %s did not report a digest", jobName)
+	}
+	return digest, nil
+}
+
+// builderArgs returns the arguments of a kaniko build of `image`. The
+// digest of the pushed image is written to the termination message.
+func builderArgs(image string, extra []string) []string {
+	args := []string{
+		"--dockerfile=" + kexec.BuildContextMountPath + "/" + docker.RelDockerfile,
+		"--context=dir://" + kexec.BuildContextMountPath,
+		"--destination=" + image,
+		"--digest-file=" + kexec.TerminationMessagePath,
+	}
+	return append(args, extra...)
+}
+
+// contextPath returns the path of a build context in the volume holding
+// the build contexts.
+func contextPath(root, ctxDir string) (string, error) {
+	rel, err := filepath.Rel(root, ctxDir)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("Build context %s is not under %s", ctxDir, root)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// isRunFailure tells whether a build job failed after its pod ran, in
+// which case its pod has a log.
+func isRunFailure(err error) bool {
+	e, ok := err.(*kexec.PodError)
+	if !ok {
+		return false
+	}
+	return e.Reason == kexec.ReasonFailed || e.Reason == kexec.ReasonDeadlineExceeded ||
+		e.Reason == kexec.ReasonOOMKilled
+}
+
+// guardedWriter stops writing to the build log once the build returns,
+// in case the log stream outlives it.
+type guardedWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closed bool
+}
+
+func (g *guardedWriter) Write(p []byte) (int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return 0, io.ErrClosedPipe
+	}
+	return g.w.Write(p)
+}
+
+func (g *guardedWriter) close() {
+	g.mu.Lock()
+	g.closed = true
+	g.mu.Unlock()
+}
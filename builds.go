@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/xuant/go-kexec/builder"
+	"github.com/xuant/go-kexec/dal"
+	"github.com/xuant/go-kexec/docker"
+	"github.com/xuant/go-kexec/kexec"
+)
+
+const (
+	// Used when the builds config leaves them unset
+	DefaultBuildWorkers   = 2
+	DefaultBuildQueueSize = 100
+)
+
+var errBuildQueueFull = errors.New("Build queue is full")
+
+// buildSpec is what a queued build needs to run: the build context
+// prepared by the create handler, and what to record once the image of
+// the function is pushed.
+type buildSpec struct {
+	Runtime    string
+	ContextDir string
+	Image      string
+	CodeHash   string
+	Content    string
+	Limits     kexec.ResourceLimits
+
+	// Whether the build updates an existing function
+	Update bool
+}
+
+// buildQueue runs the builds submitted to it with a fixed number of
+// workers, so concurrent builds do not all contend for the docker
+// daemon. The queue is bounded; submitting to a full queue fails.
+type buildQueue struct {
+	a      *appContext
+	builds chan *dal.Build
+}
+
+// newBuildQueue creates a build queue and starts its workers.
+func newBuildQueue(a *appContext, conf buildsConfig) *buildQueue {
+	workers, size := conf.Workers, conf.QueueSize
+	if workers <= 0 {
+		workers = DefaultBuildWorkers
+	}
+	if size <= 0 {
+		size = DefaultBuildQueueSize
+	}
+
+	q := &buildQueue{
+		a:      a,
+		builds: make(chan *dal.Build, size),
+	}
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+func (q *buildQueue) work() {
+	for build := range q.builds {
+		runBuild(q.a, build)
+	}
+}
+
+// submit records a build of a function as queued and queues it.
+func (q *buildQueue) submit(userName, functionName string, spec *buildSpec) (*dal.Build, error) {
+	encoded, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	build := &dal.Build{
+		FunctionName: functionName,
+		Status:       dal.BuildQueued,
+		Spec:         string(encoded),
+	}
+	if _, _, err := q.a.dal.PutBuild(userName, build); err != nil {
+		log.Printf("Failed to put build of function %s into DB: %v", functionName, err)
+		return nil, err
+	}
+
+	select {
+	case q.builds <- build:
+		log.Printf("Queued build %d of function %s", build.ID, functionName)
+		return build, nil
+	default:
+		finishBuild(q.a, build, errBuildQueueFull)
+		return build, errBuildQueueFull
+	}
+}
+
+// recover picks up the builds a previous run of the server did not
+// finish. Builds whose context is still there are queued again, from
+// the start; the others are marked failed.
+func (q *buildQueue) recover() error {
+	builds, err := q.a.dal.ListUnfinishedBuilds()
+	if err != nil {
+		return err
+	}
+
+	pending := make([]*dal.Build, 0, len(builds))
+	for _, build := range builds {
+		var spec buildSpec
+		if err := json.Unmarshal([]byte(build.Spec), &spec); err != nil {
+			finishBuild(q.a, build, fmt.Errorf("Build interrupted by a restart: %v", err))
+			continue
+		}
+		if _, err := os.Stat(spec.ContextDir); err != nil {
+			finishBuild(q.a, build, fmt.Errorf("Build interrupted by a restart: %v", err))
+			continue
+		}
+
+		build.Status = dal.BuildQueued
+		build.Log = ""
+		if err := q.a.dal.UpdateBuild(build); err != nil {
+			log.Printf("Failed to update build %d in DB: %v", build.ID, err)
+		}
+		pending = append(pending, build)
+	}
+	log.Printf("Queued %d builds again, %d failed", len(pending), len(builds)-len(pending))
+
+	// There may be more of them than the queue holds
+	go func() {
+		for _, build := range pending {
+			q.builds <- build
+		}
+	}()
+	return nil
+}
+
+// runBuild builds the image of a function from its build context and
+// pushes it to the registry, with the configured builder. Once the
+// image is pushed, it records a new version of the function and makes
+// it its default version. The progress of the build and its log are
+// recorded through the DAL, whether it succeeds or not.
+func runBuild(a *appContext, build *dal.Build) {
+	var spec buildSpec
+	if err := json.Unmarshal([]byte(build.Spec), &spec); err != nil {
+		finishBuild(a, build, fmt.Errorf("Invalid build spec: %v", err))
+		return
+	}
+	functionName := build.FunctionName
+
+	rt, ok := a.runtimes.Get(spec.Runtime)
+	if !ok {
+		finishBuild(a, build, fmt.Errorf("Runtime %s invalid or not supported yet.", spec.Runtime))
+		return
+	}
+
+	build.Status = dal.BuildBuilding
+	if err := a.dal.UpdateBuild(build); err != nil {
+		log.Printf("Failed to update build %d in DB: %v", build.ID, err)
+	}
+
+	var buildLog bytes.Buffer
+	digest, err := a.builder.BuildFunction(&builder.Build{
+		Image:        spec.Image,
+		FunctionName: functionName,
+		UserName:     build.UserName,
+		Runtime:      rt,
+		ContextDir:   spec.ContextDir,
+		Log:          &buildLog,
+		Pushing: func() {
+			build.Status = dal.BuildPushing
+			build.Log = buildLog.String()
+			if err := a.dal.UpdateBuild(build); err != nil {
+				log.Printf("Failed to update build %d in DB: %v", build.ID, err)
+			}
+		},
+	})
+	build.Log = buildLog.String()
+	if err != nil {
+		log.Printf("Build %d of function %s failed: %v", build.ID, functionName, err)
+		finishBuild(a, build, err)
+		return
+	}
+	log.Printf("Pushed %s@%s", spec.Image, digest)
+
+	// The function may have been deleted during the build, along
+	// with its builds. The function cannot be deleted while its
+	// version is recorded.
+	unlock := a.functionLocks.lock(build.UserName, functionName)
+	defer unlock()
+	if _, err := a.dal.GetBuild(build.ID); err == sql.ErrNoRows {
+		log.Printf("Function %s was deleted during build %d", functionName, build.ID)
+		err := docker.DeleteImage(spec.Image, a.registryAuth, isInsecureRegistry(a, a.conf.DockerRegistry), nil)
+		if err != nil {
+			log.Printf("Failed to delete image %s of deleted function %s: %v", spec.Image, functionName, err)
+		}
+		removeBuildContext(build)
+		return
+	}
+
+	version := &dal.Version{
+		FunctionName: functionName,
+		CodeHash:     spec.CodeHash,
+		Image:        spec.Image,
+		ImageDigest:  digest,
+		Content:      spec.Content,
+	}
+	if err := putFunctionVersion(a, build.UserName, version, spec.Limits, spec.Update); err != nil {
+		finishBuild(a, build, err)
+		return
+	}
+	build.Version = version.Version
+
+	finishBuild(a, build, nil)
+}
+
+// finishBuild records the final state of a build. It has failed if
+// `err` is not nil. The build context is removed, since only unfinished
+// builds are run again.
+func finishBuild(a *appContext, build *dal.Build, err error) {
+	build.Status = dal.BuildReady
+	build.Finished = time.Now()
+	if err != nil {
+		build.Status = dal.BuildFailed
+		build.Error = err.Error()
+	}
+
+	if err := a.dal.UpdateBuild(build); err != nil {
+		log.Printf("Failed to update build %d in DB: %v", build.ID, err)
+	}
+	removeBuildContext(build)
+}
+
+// removeBuildContext removes the build context of a build, if it has
+// one.
+func removeBuildContext(build *dal.Build) {
+	var spec buildSpec
+	if err := json.Unmarshal([]byte(build.Spec), &spec); err != nil || !isBuildContext(spec.ContextDir) {
+		return
+	}
+	if err := os.RemoveAll(spec.ContextDir); err != nil {
+		log.Printf("Failed to remove build context %s: %v", spec.ContextDir, err)
+	}
+}
+
+// buildInfo is the status of a build as reported by the builds
+// endpoint.
+type buildInfo struct {
+	ID       int64      `json:"id"`
+	Function string     `json:"function"`
+	Status   string     `json:"status"`
+	Version  int        `json:"version,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Log      string     `json:"log"`
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+func buildInfoFromRecord(build *dal.Build) *buildInfo {
+	info := &buildInfo{
+		ID:       build.ID,
+		Function: build.FunctionName,
+		Status:   build.Status,
+		Version:  build.Version,
+		Error:    build.Error,
+		Log:      buildLogPath(build),
+		Created:  build.Created,
+	}
+	if !build.Finished.IsZero() {
+		finished := build.Finished
+		info.Finished = &finished
+	}
+	return info
+}
+
+// buildPath is where the status of a build is served.
+func buildPath(build *dal.Build) string {
+	return fmt.Sprintf("/builds/%d", build.ID) + buildQuery(build)
+}
+
+// buildLogPath is where the log of a build is served.
+func buildLogPath(build *dal.Build) string {
+	return fmt.Sprintf("/functions/%s/builds/%d/log", build.FunctionName, build.ID) + buildQuery(build)
+}
+
+// buildQuery is the query of the paths of a build of a group function,
+// which name the group.
+func buildQuery(build *dal.Build) string {
+	if group, ok := dal.OwnerGroup(build.UserName); ok {
+		return "?group=" + url.QueryEscape(group)
+	}
+	return ""
+}
+
+// sourceHash hashes the sources of a function: the files of its build
+// context, before the runtime adds its own, and the runtime they are
+// built with. The same sources always give the same hash.
+func sourceHash(ctxDir, runtime string) (string, error) {
+	h := sha256.New()
+	io.WriteString(h, runtime+"\x00")
+
+	// Walk visits files in lexical order
+	err := filepath.Walk(ctxDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(ctxDir, path)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		fmt.Fprintf(h, "%s\x00%d\x00", filepath.ToSlash(rel), info.Size())
+		_, err = io.Copy(h, f)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeContext writes the files of a build context into a new directory,
+// in the given order, and returns the directory.
+func writeContext(t *testing.T, files ...string) string {
+	dir, err := ioutil.TempDir("", "source-hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(files); i += 2 {
+		path := filepath.Join(dir, filepath.FromSlash(files[i]))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(files[i+1]), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestSourceHash(t *testing.T) {
+	hash := func(dir, runtime string) string {
+		h, err := sourceHash(dir, runtime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	base := writeContext(t, "handler.py", "print(1)", "lib/util.py", "x = 1", "data.txt", "ab")
+	defer os.RemoveAll(base)
+	want := hash(base, "python27")
+
+	if h := hash(base, "python27"); h != want {
+		t.Errorf("Hash of the same context changed from %s to %s", want, h)
+	}
+
+	// Files written in another order
+	reordered := writeContext(t, "data.txt", "ab", "lib/util.py", "x = 1", "handler.py", "print(1)")
+	defer os.RemoveAll(reordered)
+	if h := hash(reordered, "python27"); h != want {
+		t.Errorf("Hash of a copy of the context is %s, want %s", h, want)
+	}
+
+	others := []struct {
+		name    string
+		dir     string
+		runtime string
+	}{
+		{"runtime", base, "python3"},
+		{"content", writeContext(t, "handler.py", "print(2)", "lib/util.py", "x = 1", "data.txt", "ab"), "python27"},
+		{"name", writeContext(t, "handler.py", "print(1)", "lib/utils.py", "x = 1", "data.txt", "ab"), "python27"},
+		{"directory", writeContext(t, "handler.py", "print(1)", "util.py", "x = 1", "data.txt", "ab"), "python27"},
+		{"extra file", writeContext(t, "handler.py", "print(1)", "lib/util.py", "x = 1", "data.txt", "ab", "empty", ""), "python27"},
+		{"moved byte", writeContext(t, "handler.py", "bprint(1)", "lib/util.py", "x = 1", "data.txt", "a"), "python27"},
+	}
+	for _, other := range others {
+		if other.dir != base {
+			defer os.RemoveAll(other.dir)
+		}
+		if h := hash(other.dir, other.runtime); h == want {
+			t.Errorf("Context with another %s has the same hash %s", other.name, h)
+		}
+	}
+}
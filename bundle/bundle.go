@@ -0,0 +1,255 @@
+/*
+Copyright 2016 Xuan Tang. All rights reserved.
+Use of this source code is governed by a license
+that can be found in the LICENSE file.
+*/
+
+// Package bundle unpacks function packages, ie zip or tar archives
+// holding the code of a function, its helper modules and dependency
+// manifests, into build contexts.
+//
+// Archives come from users, so they are validated while they are
+// unpacked: their size, their unpacked size and their number of files
+// are bounded, and entries must be regular files or directories whose
+// path stays inside the build context.
+package bundle
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// Used for the limits left unset
+	DefaultLimits = Limits{
+		MaxSize:         10 << 20,
+		MaxUnpackedSize: 50 << 20,
+		MaxFiles:        1000,
+	}
+
+	ErrTooLarge = errors.New("Package too large")
+)
+
+// Limits bound what a package may hold.
+type Limits struct {
+	// Bytes of the archive itself
+	MaxSize int64
+
+	// Bytes of all its files once unpacked
+	MaxUnpackedSize int64
+
+	// Number of files, directories excluded
+	MaxFiles int
+}
+
+// WithDefaults returns a copy of the limits in which every unset value
+// is taken from `defaults`.
+func (l Limits) WithDefaults(defaults Limits) Limits {
+	if l.MaxSize <= 0 {
+		l.MaxSize = defaults.MaxSize
+	}
+	if l.MaxUnpackedSize <= 0 {
+		l.MaxUnpackedSize = defaults.MaxUnpackedSize
+	}
+	if l.MaxFiles <= 0 {
+		l.MaxFiles = defaults.MaxFiles
+	}
+	return l
+}
+
+// Unpack unpacks a zip, tar or gzipped tar archive into `dir`, which
+// must exist. The format is detected from the content of the archive.
+// Unset limits are taken from DefaultLimits.
+//
+// It returns the number of files unpacked. On error, `dir` may hold
+// part of the archive.
+func Unpack(r io.Reader, dir string, limits Limits) (int, error) {
+	limits = limits.WithDefaults(DefaultLimits)
+
+	br := bufio.NewReader(&limitedReader{r: r, n: limits.MaxSize})
+	magic, err := br.Peek(4)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	u := &unpacker{dir: dir, limits: limits}
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		err = u.unzip(br)
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(br); err == nil {
+			err = u.untar(gz)
+			gz.Close()
+		}
+	default:
+		err = u.untar(br)
+	}
+	return u.files, err
+}
+
+// limitedReader fails with ErrTooLarge, rather than stopping silently,
+// once more than `n` bytes are read.
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, ErrTooLarge
+	}
+	return n, err
+}
+
+type unpacker struct {
+	dir      string
+	limits   Limits
+	files    int
+	unpacked int64
+}
+
+func (u *unpacker) unzip(r io.Reader) error {
+	// Zip archives are read from their end, so the archive is kept in
+	// memory. It is at most MaxSize bytes.
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf), int64(len(buf)))
+	if err != nil {
+		return fmt.Errorf("Invalid zip archive: %v", err)
+	}
+
+	for _, f := range zr.File {
+		mode := f.Mode()
+		if mode.IsDir() {
+			if err := u.mkdir(f.Name); err != nil {
+				return err
+			}
+			continue
+		}
+		if !mode.IsRegular() {
+			return fmt.Errorf("Unsupported file type for %q", f.Name)
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = u.writeFile(f.Name, mode, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *unpacker) untar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Invalid tar archive: %v", err)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := u.mkdir(hdr.Name); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := u.writeFile(hdr.Name, os.FileMode(hdr.Mode), tr); err != nil {
+				return err
+			}
+		case tar.TypeXGlobalHeader:
+			// pax metadata, not a file
+		default:
+			return fmt.Errorf("Unsupported file type for %q", hdr.Name)
+		}
+	}
+}
+
+// path returns where an entry of the archive goes, making sure it
+// stays inside the unpack directory.
+func (u *unpacker) path(name string) (string, error) {
+	trimmed := strings.TrimSuffix(name, "/")
+	clean := path.Clean(trimmed)
+	if trimmed == "" || path.IsAbs(name) || strings.Contains(name, "\\") ||
+		clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("Invalid path %q in package", name)
+	}
+	if clean == "." {
+		return "", nil
+	}
+	return filepath.Join(u.dir, filepath.FromSlash(clean)), nil
+}
+
+func (u *unpacker) mkdir(name string) error {
+	dst, err := u.path(name)
+	if err != nil || dst == "" {
+		return err
+	}
+	return os.MkdirAll(dst, 0755)
+}
+
+func (u *unpacker) writeFile(name string, mode os.FileMode, r io.Reader) error {
+	dst, err := u.path(name)
+	if err != nil {
+		return err
+	}
+	if dst == "" {
+		return fmt.Errorf("Invalid path %q in package", name)
+	}
+
+	u.files++
+	if u.files > u.limits.MaxFiles {
+		return fmt.Errorf("Package has more than %d files", u.limits.MaxFiles)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	perm := os.FileMode(0644)
+	if mode&0111 != 0 {
+		perm = 0755
+	}
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if os.IsExist(err) {
+		return fmt.Errorf("File %q appears twice in package", name)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	remaining := u.limits.MaxUnpackedSize - u.unpacked
+	n, err := io.Copy(f, io.LimitReader(r, remaining+1))
+	u.unpacked += n
+	if err != nil {
+		return err
+	}
+	if n > remaining {
+		return fmt.Errorf("Package is larger than %d bytes once unpacked", u.limits.MaxUnpackedSize)
+	}
+	return nil
+}
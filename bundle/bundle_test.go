@@ -0,0 +1,146 @@
+package bundle
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type entry struct {
+	name     string
+	content  string
+	typeflag byte
+}
+
+func zipArchive(t *testing.T, entries []entry) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(e.content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarArchive(t *testing.T, entries []entry, compress bool) []byte {
+	var buf bytes.Buffer
+	var tw *tar.Writer
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(&buf)
+		tw = tar.NewWriter(gz)
+	} else {
+		tw = tar.NewWriter(&buf)
+	}
+
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Mode:     0644,
+			Size:     int64(len(e.content)),
+			Typeflag: e.typeflag,
+		}
+		if e.typeflag == 0 {
+			hdr.Typeflag = tar.TypeReg
+		}
+		if hdr.Typeflag == tar.TypeSymlink {
+			hdr.Linkname, hdr.Size = e.content, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Size > 0 {
+			tw.Write([]byte(e.content))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gz != nil {
+		gz.Close()
+	}
+	return buf.Bytes()
+}
+
+func unpack(t *testing.T, archive []byte, limits Limits) (string, int, error) {
+	dir, err := ioutil.TempDir("", "bundle-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := Unpack(bytes.NewReader(archive), dir, limits)
+	return dir, n, err
+}
+
+func TestUnpack(t *testing.T) {
+	entries := []entry{
+		{name: "requirements.txt", content: "requests\n"},
+		{name: "lib/", typeflag: tar.TypeDir},
+		{name: "lib/helpers.py", content: "def help(): pass\n"},
+	}
+	archives := map[string][]byte{
+		"zip":    zipArchive(t, entries),
+		"tar":    tarArchive(t, entries, false),
+		"tar.gz": tarArchive(t, entries, true),
+	}
+
+	for format, archive := range archives {
+		dir, n, err := unpack(t, archive, Limits{})
+		defer os.RemoveAll(dir)
+		if err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+		if n != 2 {
+			t.Errorf("%s: unpacked %d files, want 2", format, n)
+		}
+
+		content, err := ioutil.ReadFile(filepath.Join(dir, "lib", "helpers.py"))
+		if err != nil || string(content) != "def help(): pass\n" {
+			t.Errorf("%s: lib/helpers.py = %q, %v", format, content, err)
+		}
+	}
+}
+
+func TestUnpackInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		archive []byte
+		limits  Limits
+		err     string
+	}{
+		{"parent", zipArchive(t, []entry{{name: "../evil.py"}}), Limits{}, "Invalid path"},
+		{"nested parent", tarArchive(t, []entry{{name: "lib/../../evil.py"}}, false), Limits{}, "Invalid path"},
+		{"absolute", tarArchive(t, []entry{{name: "/etc/evil"}}, true), Limits{}, "Invalid path"},
+		{"symlink", tarArchive(t, []entry{{name: "link", content: "/etc/passwd", typeflag: tar.TypeSymlink}}, false), Limits{}, "Unsupported file type"},
+		{"duplicate", tarArchive(t, []entry{{name: "a.py"}, {name: "./a.py"}}, false), Limits{}, "appears twice"},
+		{"file count", zipArchive(t, []entry{{name: "a"}, {name: "b"}, {name: "c"}}), Limits{MaxFiles: 2}, "more than 2 files"},
+		{"unpacked size", tarArchive(t, []entry{{name: "big", content: strings.Repeat("x", 4096)}}, true), Limits{MaxUnpackedSize: 1024}, "larger than 1024 bytes"},
+		{"size", tarArchive(t, []entry{{name: "big", content: strings.Repeat("x", 4096)}}, false), Limits{MaxSize: 1024}, "too large"},
+		{"garbage", []byte("not an archive at all"), Limits{}, "Invalid tar archive"},
+	}
+
+	for _, test := range tests {
+		dir, _, err := unpack(t, test.archive, test.limits)
+		os.RemoveAll(dir)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.err)
+		}
+	}
+
+	// Nothing may be written outside the unpack directory
+	if _, err := os.Stat(filepath.Join(os.TempDir(), "evil.py")); err == nil {
+		t.Errorf("Package escaped its directory")
+	}
+}
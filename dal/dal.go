@@ -4,119 +4,167 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
+)
+
+// Environment variables overriding the settings of a DalConfig, so
+// credentials need not be in the config file
+const (
+	EnvDriver   = "KEXEC_DB_DRIVER"
+	EnvHost     = "KEXEC_DB_HOST"
+	EnvPort     = "KEXEC_DB_PORT"
+	EnvUsername = "KEXEC_DB_USERNAME"
+	EnvPassword = "KEXEC_DB_PASSWORD"
+	EnvName     = "KEXEC_DB_NAME"
+	EnvPath     = "KEXEC_DB_PATH"
 )
 
 type DalConfig struct {
-	// data source
+	// Backend, one of Drivers(). Default DefaultDriver.
+	Driver string
+
+	// data source of server backends
 	DBHost   string
+	DBPort   int
 	Username string
 	Password string
 
 	// db
 	DBName string
 
+	// File of the database of file backends (sqlite3)
+	DBPath string
+
 	// tables
 	UsersTable      string
 	FunctionsTable  string
 	ExecutionsTable string
-}
+	BuildsTable     string
+	VersionsTable   string
 
-func (c *DalConfig) getDataSourceName() string {
-	return fmt.Sprintf("%s:%s@tcp(%s:3306)/?parseTime=true", c.Username, c.Password, c.DBHost)
-}
+	// Groups and their members
+	GroupsTable       string
+	GroupMembersTable string
 
-type MySQL struct {
-	*sql.DB
+	// Table recording the migrations applied to the schema
+	SchemaVersionTable string
 
-	UsersTable      string
-	FunctionsTable  string
-	ExecutionsTable string
+	// Migrations are run with the migrate command only. The server
+	// does not start while some are pending.
+	ManualMigrations bool
 }
 
-func NewMySQL(config *DalConfig) (*MySQL, error) {
-	db, err := sql.Open("mysql", config.getDataSourceName())
-	if err != nil {
-		return nil, err
+// LoadEnv overrides the settings set in the environment.
+func (c *DalConfig) LoadEnv() error {
+	for env, setting := range map[string]*string{
+		EnvDriver:   &c.Driver,
+		EnvHost:     &c.DBHost,
+		EnvUsername: &c.Username,
+		EnvPassword: &c.Password,
+		EnvName:     &c.DBName,
+		EnvPath:     &c.DBPath,
+	} {
+		if v := os.Getenv(env); v != "" {
+			*setting = v
+		}
 	}
 
-	_, err = db.Exec(fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", config.DBName))
-	if err != nil {
-		return nil, err
+	if v := os.Getenv(EnvPort); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil || port <= 0 {
+			return fmt.Errorf("Invalid %s %q", EnvPort, v)
+		}
+		c.DBPort = port
 	}
+	return nil
+}
 
-	_, err = db.Exec("USE " + config.DBName)
-	if err != nil {
-		return nil, err
+// withDefaults returns a copy of the config with the unset settings
+// set to their defaults.
+func (c *DalConfig) withDefaults() *DalConfig {
+	config := *c
+	defaults := []struct {
+		setting *string
+		value   string
+	}{
+		{&config.Driver, DefaultDriver},
+		{&config.DBName, "kexec"},
+		{&config.DBPath, "kexec.db"},
+		{&config.UsersTable, "users"},
+		{&config.FunctionsTable, "functions"},
+		{&config.ExecutionsTable, "executions"},
+		{&config.BuildsTable, "builds"},
+		{&config.VersionsTable, "versions"},
+		// groups is a reserved word of MySQL
+		{&config.GroupsTable, "user_groups"},
+		{&config.GroupMembersTable, "group_members"},
+		{&config.SchemaVersionTable, "schema_version"},
 	}
-
-	// Create the users table if not already existed
-	_, err = db.Exec(fmt.Sprintf(`
-	CREATE TABLE IF NOT EXISTS %s ( 
-		u_id INT NOT NULL AUTO_INCREMENT, 
-		name VARCHAR(255) NOT NULL, 
-		created TIMESTAMP, 
-		PRIMARY KEY (u_id),
-		UNIQUE(name)
-	)`, config.UsersTable))
-
-	if err != nil {
-		return nil, err
+	for _, d := range defaults {
+		if *d.setting == "" {
+			*d.setting = d.value
+		}
 	}
+	return &config
+}
 
-	// Create a unique index on (name) column of users table
-	// This method creates index everytime NewMySQL is called.
-	// _, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD UNIQUE (name)", config.UsersTable))
+// sqlDAL implements the DAL on a SQL database. Backends have their own
+// migrations creating the tables, and tell what of their SQL differs.
+type sqlDAL struct {
+	*sql.DB
+	dialect
 
-	if err != nil {
-		return nil, err
-	}
+	UsersTable         string
+	FunctionsTable     string
+	ExecutionsTable    string
+	BuildsTable        string
+	VersionsTable      string
+	GroupsTable        string
+	GroupMembersTable  string
+	SchemaVersionTable string
 
-	// Create the functions table if not already existed
-	_, err = db.Exec(fmt.Sprintf(`
-	CREATE TABLE IF NOT EXISTS %s ( 
-		f_id INT NOT NULL AUTO_INCREMENT, 
-		u_id INT NOT NULL,
-		name VARCHAR(255) NOT NULL,
-		content TEXT, 
-		created TIMESTAMP, 
-		updated TIMESTAMP, 
-		PRIMARY KEY (f_id), 
-		FOREIGN KEY (u_id) REFERENCES %s(u_id)
-	)`, config.FunctionsTable, config.UsersTable))
+	migrations []Migration
+	tables     *strings.Replacer
+}
 
-	if err != nil {
-		return nil, err
-	}
+// dialect is the SQL which differs between backends.
+type dialect struct {
+	// Inserts a row unless it breaks a unique constraint
+	insertIgnore string
 
-	// Create the executions table if not already existed
-	_, err = db.Exec(fmt.Sprintf(`
-	CREATE TABLE IF NOT EXISTS %s (
-		e_id INT NOT NULL AUTO_INCREMENT, 
-		f_id INT NOT NULL,
-		uuid VARCHAR(255) NOT NULL,
-		log TEXT, 
-		created TIMESTAMP, 
-		PRIMARY KEY (e_id), 
-		FOREIGN KEY (f_id) REFERENCES %s(f_id)
-	)`, config.ExecutionsTable, config.FunctionsTable))
+	// Ends a SELECT locking the rows it reads until the end of the
+	// transaction, if the backend locks rows
+	forUpdate string
 
-	if err != nil {
-		return nil, err
-	}
+	// Tells whether a statement failed because the column, index or
+	// table it adds already exists
+	isExisting func(err error) bool
+}
 
-	return &MySQL{
+func newSQLDAL(db *sql.DB, d dialect, migrations []Migration, config *DalConfig) *sqlDAL {
+	return &sqlDAL{
 		db,
+		d,
 		config.UsersTable,
 		config.FunctionsTable,
 		config.ExecutionsTable,
-	}, nil
+		config.BuildsTable,
+		config.VersionsTable,
+		config.GroupsTable,
+		config.GroupMembersTable,
+		config.SchemaVersionTable,
+		migrations,
+		tableNames(config),
+	}
 }
 
 // List all functions created by a user
-func (dal *MySQL) ListFunctionsOfUser(namespace, username string, userId int64) ([]*Function, error) {
+func (dal *sqlDAL) ListFunctionsOfUser(namespace, username string, userId int64) ([]*Function, error) {
 
 	uid := userId
 
@@ -174,11 +222,12 @@ func (dal *MySQL) ListFunctionsOfUser(namespace, username string, userId int64)
 }
 
 // PutUserIfNotExists inserts user into DB if the user
-// is not already inserted. The caller is responsible for
+// is not already inserted, and makes the user a member of group
+// `groupName` if it is not empty. The caller is responsible for
 // making sure `userName` is not empty.
-func (dal *MySQL) PutUserIfNotExisted(groupName, userName string) (int64, int64, error) {
+func (dal *sqlDAL) PutUserIfNotExisted(groupName, userName string) (int64, int64, error) {
 	stmt, err := dal.Prepare(fmt.Sprintf(
-		"INSERT IGNORE INTO %s (name, created) VALUES (?, ?)",
+		dal.insertIgnore+" INTO %s (name, created) VALUES (?, ?)",
 		dal.UsersTable))
 
 	if err != nil {
@@ -201,37 +250,540 @@ func (dal *MySQL) PutUserIfNotExisted(groupName, userName string) (int64, int64,
 		return -1, -1, err
 	}
 
+	if groupName != "" {
+		if err := dal.addGroupMember(groupName, userName); err != nil {
+			return -1, -1, err
+		}
+	}
+
 	return lastId, rowCnt, nil
 }
 
-// is not already inserted.
+// ListUsers lists the names of all the users, by name.
+func (dal *sqlDAL) ListUsers() ([]string, error) {
+	rows, err := dal.Query(fmt.Sprintf("SELECT name FROM %s ORDER BY name", dal.UsersTable))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// PutFunctionIfNotExisted inserts function into DB if the user has no
+// function of the same name yet, in which case no row is affected.
 //
-// When both `userName` and `userId` are not empty, the function check
-// userId first.
-func (dal *MySQL) PutFunctionIfNotExisted(userName, funcName, funcContent string, userId int64) (int64, int64, error) {
+// When both `userName` and `function.UserID` are not empty, the function
+// check function.UserID first.
+func (dal *sqlDAL) PutFunctionIfNotExisted(userName string, function *Function) (int64, int64, error) {
+	return dal.putFunction(dal.DB, userName, function)
+}
 
-	uid := userId
+func (dal *sqlDAL) putFunction(q queryer, userName string, function *Function) (int64, int64, error) {
+
+	uid := function.UserID
 
 	if uid < 0 && userName == "" {
 		return -1, -1, errors.New("Either userName or userId should be valid")
 	}
 
 	if uid < 0 {
-		err := dal.QueryRow(fmt.Sprintf("SELECT u_id FROM %s WHERE name = ?", dal.UsersTable), userName).Scan(&uid)
+		err := q.QueryRow(fmt.Sprintf("SELECT u_id FROM %s WHERE name = ?", dal.UsersTable), userName).Scan(&uid)
 		if err != nil {
 			return -1, -1, err
 		}
 	}
 
+	res, err := q.Exec(fmt.Sprintf(
+		dal.insertIgnore+` INTO %s (u_id, name, content, cpu_request, cpu_limit,
+			memory_request, memory_limit, max_runtime, image_digest,
+			default_version, created)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		dal.FunctionsTable), uid, function.Name, function.Content,
+		function.CPURequest, function.CPULimit,
+		function.MemoryRequest, function.MemoryLimit, function.MaxRuntime,
+		function.ImageDigest, function.DefaultVersion, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return -1, -1, err
+	}
+
+	lastId, err := res.LastInsertId()
+	if err != nil {
+		return -1, -1, err
+	}
+
+	rowCnt, err := res.RowsAffected()
+	if err != nil {
+		return -1, -1, err
+	}
+
+	// Not every backend resets the insert id of ignored rows
+	if rowCnt == 0 {
+		lastId = 0
+	}
+
+	return lastId, rowCnt, nil
+}
+
+// UpdateFunction updates the content, resource limits, image digest and
+// default version of the function `function.Name` of user `userName`,
+// and bumps its update time, in a transaction. The previous content of
+// a function created before versions is recorded as its version 0 first,
+// so it is not lost.
+func (dal *sqlDAL) UpdateFunction(userName string, function *Function) error {
+	tx, err := dal.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := dal.updateFunction(tx, userName, function); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (dal *sqlDAL) updateFunction(q queryer, userName string, function *Function) error {
+	previous := &Function{}
+	err := q.QueryRow(fmt.Sprintf(
+		`SELECT f.f_id, f.u_id, f.content, f.image_digest, f.default_version, f.created
+		FROM %s f JOIN %s u ON f.u_id = u.u_id
+		WHERE u.name = ? AND f.name = ?`+dal.forUpdate,
+		dal.FunctionsTable, dal.UsersTable), userName, function.Name).Scan(
+		&previous.ID, &previous.UserID, &previous.Content, &previous.ImageDigest,
+		&previous.DefaultVersion, &previous.Created)
+	if err != nil {
+		return err
+	}
+
+	if previous.DefaultVersion == 0 {
+		_, err = q.Exec(fmt.Sprintf(
+			dal.insertIgnore+` INTO %s (u_id, function_name, version, code_hash, image,
+				image_digest, content, created)
+			VALUES (?, ?, 0, '', '', ?, ?, ?)`,
+			dal.VersionsTable), previous.UserID, function.Name, previous.ImageDigest,
+			previous.Content, previous.Created)
+		if err != nil {
+			return err
+		}
+	}
+
+	function.Updated = time.Now()
+	_, err = q.Exec(fmt.Sprintf(
+		`UPDATE %s SET content = ?, cpu_request = ?, cpu_limit = ?, memory_request = ?,
+			memory_limit = ?, max_runtime = ?, image_digest = ?, default_version = ?,
+			updated = ?
+		WHERE f_id = ?`,
+		dal.FunctionsTable), function.Content, function.CPURequest, function.CPULimit,
+		function.MemoryRequest, function.MemoryLimit, function.MaxRuntime,
+		function.ImageDigest, function.DefaultVersion, function.Updated, previous.ID)
+	if err != nil {
+		return err
+	}
+
+	function.ID = previous.ID
+	function.UserID = previous.UserID
+	function.Created = previous.Created
+	return nil
+}
+
+// GetFunction gets the function `funcName` of user `userName`.
+func (dal *sqlDAL) GetFunction(userName, funcName string) (*Function, error) {
+	function := &Function{}
+	var updated mysql.NullTime
+
+	err := dal.QueryRow(fmt.Sprintf(
+		`SELECT f.f_id, f.u_id, f.name, f.content, f.cpu_request, f.cpu_limit,
+			f.memory_request, f.memory_limit, f.max_runtime, f.image_digest,
+			f.default_version, f.created, f.updated
+		FROM %s f JOIN %s u ON f.u_id = u.u_id
+		WHERE u.name = ? AND f.name = ?
+		ORDER BY f.f_id DESC LIMIT 1`,
+		dal.FunctionsTable, dal.UsersTable), userName, funcName).Scan(
+		&function.ID, &function.UserID, &function.Name, &function.Content,
+		&function.CPURequest, &function.CPULimit,
+		&function.MemoryRequest, &function.MemoryLimit, &function.MaxRuntime,
+		&function.ImageDigest, &function.DefaultVersion, &function.Created, &updated)
+	if err != nil {
+		return nil, err
+	}
+
+	function.Updated = updated.Time
+
+	return function, nil
+}
+
+// PutExecution inserts an execution of the function `funcName` owned
+// by `userName`. If there are several functions with the same name,
+// the latest one is used.
+func (dal *sqlDAL) PutExecution(userName, funcName string, execution *FunctionExecution) (int64, int64, error) {
+	var fid int64
+	err := dal.QueryRow(fmt.Sprintf(
+		"SELECT f.f_id FROM %s f JOIN %s u ON f.u_id = u.u_id WHERE u.name = ? AND f.name = ? ORDER BY f.f_id DESC LIMIT 1",
+		dal.FunctionsTable, dal.UsersTable), userName, funcName).Scan(&fid)
+	if err != nil {
+		return -1, -1, err
+	}
+
 	stmt, err := dal.Prepare(fmt.Sprintf(
-		"INSERT INTO %s (u_id, name, content, created) VALUES (?, ?, ?, ?)",
-		dal.FunctionsTable))
+		"INSERT INTO %s (f_id, uuid, job_name, namespace, params, status, version, created) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		dal.ExecutionsTable))
+	if err != nil {
+		return -1, -1, err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(fid, execution.UUID, execution.JobName, execution.Namespace,
+		execution.Params, execution.Status, execution.Version, execution.Timestamp)
+	if err != nil {
+		return -1, -1, err
+	}
+
+	lastId, err := res.LastInsertId()
+	if err != nil {
+		return -1, -1, err
+	}
+
+	rowCnt, err := res.RowsAffected()
+	if err != nil {
+		return -1, -1, err
+	}
+
+	execution.ID = lastId
+	execution.FunctionID = fid
+
+	return lastId, rowCnt, nil
+}
+
+// UpdateExecution records the final state of an execution.
+func (dal *sqlDAL) UpdateExecution(execution *FunctionExecution) error {
+	stmt, err := dal.Prepare(fmt.Sprintf(
+		"UPDATE %s SET status = ?, exit_code = ?, duration = ?, log = ?, result = ?, finished = ? WHERE uuid = ?",
+		dal.ExecutionsTable))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(execution.Status, execution.ExitCode,
+		int64(execution.Duration/time.Millisecond), execution.Log,
+		execution.Result, execution.Finished, execution.UUID)
+	if err != nil {
+		return err
+	}
+
+	rowCnt, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowCnt == 0 {
+		return fmt.Errorf("Execution %s not found", execution.UUID)
+	}
+
+	return nil
+}
+
+// selectExecutions is the common part of the queries reading executions.
+// Users and functions are joined in so the owner and function name can
+// be reported along with the execution.
+func (dal *sqlDAL) selectExecutions() string {
+	return fmt.Sprintf(`
+	SELECT e.e_id, e.f_id, u.name, f.name, e.uuid, e.job_name, e.namespace,
+		e.params, e.status,
+		e.exit_code, e.duration, e.log, e.result, e.version, e.created, e.finished
+	FROM %s e
+		JOIN %s f ON e.f_id = f.f_id
+		JOIN %s u ON f.u_id = u.u_id`,
+		dal.ExecutionsTable, dal.FunctionsTable, dal.UsersTable)
+}
+
+func scanExecution(row interface {
+	Scan(dest ...interface{}) error
+}) (*FunctionExecution, error) {
+	var (
+		params   sql.NullString
+		exitCode sql.NullInt64
+		duration sql.NullInt64
+		funcLog  sql.NullString
+		result   sql.NullString
+		finished mysql.NullTime
+	)
+
+	execution := &FunctionExecution{}
+	err := row.Scan(&execution.ID, &execution.FunctionID, &execution.UserName,
+		&execution.FunctionName, &execution.UUID, &execution.JobName,
+		&execution.Namespace, &params, &execution.Status,
+		&exitCode, &duration, &funcLog, &result, &execution.Version,
+		&execution.Timestamp, &finished)
+	if err != nil {
+		return nil, err
+	}
+
+	execution.Params = params.String
+	execution.ExitCode = int(exitCode.Int64)
+	execution.Duration = time.Duration(duration.Int64) * time.Millisecond
+	execution.Log = funcLog.String
+	execution.Result = result.String
+	execution.Finished = finished.Time
+
+	return execution, nil
+}
+
+// GetExecution gets an execution by its uuid.
+func (dal *sqlDAL) GetExecution(uuid string) (*FunctionExecution, error) {
+	row := dal.QueryRow(dal.selectExecutions()+" WHERE e.uuid = ?", uuid)
+	return scanExecution(row)
+}
+
+// ListExecutionsOfFunction lists the executions of a function, latest
+// first, narrowed down by `filter` if it is not nil.
+func (dal *sqlDAL) ListExecutionsOfFunction(userName, funcName string, filter *ExecutionFilter) ([]*FunctionExecution, error) {
+	query := dal.selectExecutions() + " WHERE u.name = ? AND f.name = ?"
+	args := []interface{}{userName, funcName}
+
+	if filter != nil {
+		if filter.Status != "" {
+			query += " AND e.status = ?"
+			args = append(args, filter.Status)
+		}
+		if !filter.Since.IsZero() {
+			query += " AND e.created >= ?"
+			args = append(args, filter.Since)
+		}
+		if !filter.Until.IsZero() {
+			query += " AND e.created < ?"
+			args = append(args, filter.Until)
+		}
+	}
+
+	query += " ORDER BY e.e_id DESC"
+	if filter != nil && filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+
+	executions := make([]*FunctionExecution, 0, 5)
+
+	rows, err := dal.Query(query, args...)
+	if err != nil {
+		return executions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		execution, err := scanExecution(rows)
+		if err != nil {
+			return executions, err
+		}
+		executions = append(executions, execution)
+	}
+
+	if err = rows.Err(); err != nil {
+		return executions, err
+	}
+
+	return executions, nil
+}
 
+// PutBuild inserts a build of the function `build.FunctionName` of
+// user `userName`.
+func (dal *sqlDAL) PutBuild(userName string, build *Build) (int64, int64, error) {
+	var uid int64
+	err := dal.QueryRow(fmt.Sprintf("SELECT u_id FROM %s WHERE name = ?", dal.UsersTable), userName).Scan(&uid)
 	if err != nil {
 		return -1, -1, err
 	}
 
-	res, err := stmt.Exec(uid, funcName, funcContent, time.Now().Format(time.RFC3339))
+	if build.Created.IsZero() {
+		build.Created = time.Now()
+	}
+
+	stmt, err := dal.Prepare(fmt.Sprintf(
+		"INSERT INTO %s (u_id, function_name, status, spec, log, error, created) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		dal.BuildsTable))
+	if err != nil {
+		return -1, -1, err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(uid, build.FunctionName, build.Status, build.Spec, build.Log, build.Error, build.Created)
+	if err != nil {
+		return -1, -1, err
+	}
+
+	lastId, err := res.LastInsertId()
+	if err != nil {
+		return -1, -1, err
+	}
+
+	rowCnt, err := res.RowsAffected()
+	if err != nil {
+		return -1, -1, err
+	}
+
+	build.ID = lastId
+	build.UserName = userName
+
+	return lastId, rowCnt, nil
+}
+
+// UpdateBuild records the progress of a build. The finish time is left
+// unset while it is zero.
+func (dal *sqlDAL) UpdateBuild(build *Build) error {
+	stmt, err := dal.Prepare(fmt.Sprintf(
+		"UPDATE %s SET status = ?, log = ?, error = ?, version = ?, finished = ? WHERE b_id = ?",
+		dal.BuildsTable))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	finished := mysql.NullTime{Time: build.Finished, Valid: !build.Finished.IsZero()}
+	res, err := stmt.Exec(build.Status, build.Log, build.Error, build.Version, finished, build.ID)
+	if err != nil {
+		return err
+	}
+
+	rowCnt, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowCnt == 0 {
+		return fmt.Errorf("Build %d not found", build.ID)
+	}
+
+	return nil
+}
+
+// selectBuilds is the common part of the queries reading builds.
+func (dal *sqlDAL) selectBuilds() string {
+	return fmt.Sprintf(`
+	SELECT b.b_id, u.name, b.function_name, b.status, b.spec, b.log,
+		b.error, b.version, b.created, b.finished
+	FROM %s b JOIN %s u ON b.u_id = u.u_id`,
+		dal.BuildsTable, dal.UsersTable)
+}
+
+func scanBuild(row interface {
+	Scan(dest ...interface{}) error
+}) (*Build, error) {
+	var (
+		spec     sql.NullString
+		buildLog sql.NullString
+		buildErr sql.NullString
+		finished mysql.NullTime
+	)
+
+	build := &Build{}
+	err := row.Scan(&build.ID, &build.UserName, &build.FunctionName,
+		&build.Status, &spec, &buildLog, &buildErr, &build.Version,
+		&build.Created, &finished)
+	if err != nil {
+		return nil, err
+	}
+
+	build.Spec = spec.String
+	build.Log = buildLog.String
+	build.Error = buildErr.String
+	build.Finished = finished.Time
+
+	return build, nil
+}
+
+// GetBuild gets a build by its id.
+func (dal *sqlDAL) GetBuild(id int64) (*Build, error) {
+	return scanBuild(dal.QueryRow(dal.selectBuilds()+" WHERE b.b_id = ?", id))
+}
+
+// ListUnfinishedBuilds lists the builds which did not finish, oldest
+// first.
+func (dal *sqlDAL) ListUnfinishedBuilds() ([]*Build, error) {
+	return dal.listBuilds(" WHERE b.status IN (?, ?, ?) ORDER BY b.b_id",
+		BuildQueued, BuildBuilding, BuildPushing)
+}
+
+// ListBuilds lists the builds of a function, latest first.
+func (dal *sqlDAL) ListBuilds(userName, funcName string) ([]*Build, error) {
+	return dal.listBuilds(" WHERE u.name = ? AND b.function_name = ? ORDER BY b.b_id DESC",
+		userName, funcName)
+}
+
+func (dal *sqlDAL) listBuilds(where string, args ...interface{}) ([]*Build, error) {
+	builds := make([]*Build, 0, 5)
+
+	rows, err := dal.Query(dal.selectBuilds()+where, args...)
+	if err != nil {
+		return builds, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		build, err := scanBuild(rows)
+		if err != nil {
+			return builds, err
+		}
+		builds = append(builds, build)
+	}
+
+	if err := rows.Err(); err != nil {
+		return builds, err
+	}
+
+	return builds, nil
+}
+
+// PutVersion inserts a version of the function `version.FunctionName`
+// of user `userName`. The version number is assigned in the insert
+// transaction, after the latest version of the function.
+func (dal *sqlDAL) PutVersion(userName string, version *Version) (int64, int64, error) {
+	tx, err := dal.Begin()
+	if err != nil {
+		return -1, -1, err
+	}
+	defer tx.Rollback()
+
+	lastId, rowCnt, err := dal.putVersion(tx, userName, version)
+	if err != nil {
+		return -1, -1, err
+	}
+	if err := tx.Commit(); err != nil {
+		return -1, -1, err
+	}
+	return lastId, rowCnt, nil
+}
+
+func (dal *sqlDAL) putVersion(q queryer, userName string, version *Version) (int64, int64, error) {
+	var uid int64
+	err := q.QueryRow(fmt.Sprintf("SELECT u_id FROM %s WHERE name = ?", dal.UsersTable), userName).Scan(&uid)
+	if err != nil {
+		return -1, -1, err
+	}
+
+	var latest int
+	err = q.QueryRow(fmt.Sprintf(
+		"SELECT COALESCE(MAX(version), 0) FROM %s WHERE u_id = ? AND function_name = ?"+dal.forUpdate,
+		dal.VersionsTable), uid, version.FunctionName).Scan(&latest)
+	if err != nil {
+		return -1, -1, err
+	}
+
+	if version.Created.IsZero() {
+		version.Created = time.Now()
+	}
+
+	res, err := q.Exec(fmt.Sprintf(
+		`INSERT INTO %s (u_id, function_name, version, code_hash, image,
+			image_digest, content, created)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		dal.VersionsTable), uid, version.FunctionName, latest+1, version.CodeHash,
+		version.Image, version.ImageDigest, version.Content, version.Created)
 	if err != nil {
 		return -1, -1, err
 	}
@@ -246,16 +798,227 @@ func (dal *MySQL) PutFunctionIfNotExisted(userName, funcName, funcContent string
 		return -1, -1, err
 	}
 
+	version.ID = lastId
+	version.UserName = userName
+	version.Version = latest + 1
+
+	return lastId, rowCnt, nil
+}
+
+// PutFunctionVersion inserts a version of a function like PutVersion,
+// and makes it the default version of `function`, in a transaction. The
+// function is created, unless `update` is set and the function exists,
+// in which case it is updated like with UpdateFunction. If the function
+// exists and `update` is not set, nothing is inserted and no row is
+// affected.
+func (dal *sqlDAL) PutFunctionVersion(userName string, function *Function, version *Version, update bool) (int64, int64, error) {
+	tx, err := dal.Begin()
+	if err != nil {
+		return -1, -1, err
+	}
+	defer tx.Rollback()
+
+	lastId, rowCnt, err := dal.putVersion(tx, userName, version)
+	if err != nil {
+		return -1, -1, err
+	}
+	function.DefaultVersion = version.Version
+
+	err = sql.ErrNoRows
+	if update {
+		err = dal.updateFunction(tx, userName, function)
+	}
+	switch err {
+	case nil:
+	case sql.ErrNoRows:
+		_, created, err := dal.putFunction(tx, userName, function)
+		if err != nil {
+			return -1, -1, err
+		}
+		if created == 0 {
+			return 0, 0, nil
+		}
+	default:
+		return -1, -1, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return -1, -1, err
+	}
 	return lastId, rowCnt, nil
 }
 
+func (dal *sqlDAL) selectVersions() string {
+	return fmt.Sprintf(`
+	SELECT v.ver_id, u.name, v.function_name, v.version, v.code_hash,
+		v.image, v.image_digest, v.content, v.created
+	FROM %s v JOIN %s u ON v.u_id = u.u_id`,
+		dal.VersionsTable, dal.UsersTable)
+}
+
+func scanVersion(row interface {
+	Scan(dest ...interface{}) error
+}) (*Version, error) {
+	var content sql.NullString
+
+	version := &Version{}
+	err := row.Scan(&version.ID, &version.UserName, &version.FunctionName,
+		&version.Version, &version.CodeHash, &version.Image,
+		&version.ImageDigest, &content, &version.Created)
+	if err != nil {
+		return nil, err
+	}
+
+	version.Content = content.String
+
+	return version, nil
+}
+
+// GetVersion gets the version `version` of the function `funcName` of
+// user `userName`.
+func (dal *sqlDAL) GetVersion(userName, funcName string, version int) (*Version, error) {
+	row := dal.QueryRow(dal.selectVersions()+" WHERE u.name = ? AND v.function_name = ? AND v.version = ?",
+		userName, funcName, version)
+	return scanVersion(row)
+}
+
+// ListVersions lists the versions of a function, latest first.
+func (dal *sqlDAL) ListVersions(userName, funcName string) ([]*Version, error) {
+	versions := make([]*Version, 0, 5)
+
+	rows, err := dal.Query(dal.selectVersions()+" WHERE u.name = ? AND v.function_name = ? ORDER BY v.version DESC",
+		userName, funcName)
+	if err != nil {
+		return versions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		version, err := scanVersion(rows)
+		if err != nil {
+			return versions, err
+		}
+		versions = append(versions, version)
+	}
+
+	if err := rows.Err(); err != nil {
+		return versions, err
+	}
+
+	return versions, nil
+}
+
+// SetDefaultVersion makes `version` the default version of a function,
+// with the content and image digest of the version, and bumps its update
+// time, in a transaction. If there are several functions with the same
+// name, the latest one is updated.
+func (dal *sqlDAL) SetDefaultVersion(userName, funcName string, version int) error {
+	tx, err := dal.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var fid, uid int64
+	err = tx.QueryRow(fmt.Sprintf(
+		`SELECT f.f_id, f.u_id FROM %s f JOIN %s u ON f.u_id = u.u_id
+		WHERE u.name = ? AND f.name = ?
+		ORDER BY f.f_id DESC LIMIT 1`+dal.forUpdate,
+		dal.FunctionsTable, dal.UsersTable), userName, funcName).Scan(&fid, &uid)
+	if err != nil {
+		return err
+	}
+
+	var content, imageDigest string
+	err = tx.QueryRow(fmt.Sprintf(
+		"SELECT content, image_digest FROM %s WHERE u_id = ? AND function_name = ? AND version = ?",
+		dal.VersionsTable), uid, funcName, version).Scan(&content, &imageDigest)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf(
+		"UPDATE %s SET default_version = ?, content = ?, image_digest = ?, updated = ? WHERE f_id = ?",
+		dal.FunctionsTable), version, content, imageDigest, time.Now(), fid)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteFunction deletes the functions named `funcName` of user
+// `userName`, their executions, versions and builds, in a transaction.
+func (dal *sqlDAL) DeleteFunction(userName, funcName string) (int64, error) {
+	tx, err := dal.Begin()
+	if err != nil {
+		return -1, err
+	}
+	defer tx.Rollback()
+
+	var uid int64
+	err = tx.QueryRow(fmt.Sprintf("SELECT u_id FROM %s WHERE name = ?", dal.UsersTable), userName).Scan(&uid)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return -1, err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf(
+		"DELETE FROM %s WHERE f_id IN (SELECT f_id FROM %s WHERE u_id = ? AND name = ?)",
+		dal.ExecutionsTable, dal.FunctionsTable), uid, funcName)
+	if err != nil {
+		return -1, err
+	}
+
+	res, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE u_id = ? AND name = ?", dal.FunctionsTable),
+		uid, funcName)
+	if err != nil {
+		return -1, err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return -1, err
+	}
+
+	for _, table := range []string{dal.VersionsTable, dal.BuildsTable} {
+		_, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE u_id = ? AND function_name = ?", table),
+			uid, funcName)
+		if err != nil {
+			return -1, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return -1, err
+	}
+	return deleted, nil
+}
+
 // Careful with this function, it drops your entire database.
 // Only used for test purpose.
-func (dal *MySQL) ClearDatabase() error {
+func (dal *sqlDAL) ClearDatabase() error {
+	if _, err := dal.Exec(fmt.Sprintf("DELETE FROM %s", dal.GroupMembersTable)); err != nil {
+		return err
+	}
+
+	if _, err := dal.Exec(fmt.Sprintf("DELETE FROM %s", dal.GroupsTable)); err != nil {
+		return err
+	}
+
 	if _, err := dal.Exec(fmt.Sprintf("DELETE FROM %s", dal.ExecutionsTable)); err != nil {
 		return err
 	}
 
+	if _, err := dal.Exec(fmt.Sprintf("DELETE FROM %s", dal.BuildsTable)); err != nil {
+		return err
+	}
+
+	if _, err := dal.Exec(fmt.Sprintf("DELETE FROM %s", dal.VersionsTable)); err != nil {
+		return err
+	}
+
 	if _, err := dal.Exec(fmt.Sprintf("DELETE FROM %s", dal.FunctionsTable)); err != nil {
 		return err
 	}
@@ -1,9 +1,11 @@
 package dal
 
 import (
-	"errors"
+	"database/sql"
 	"fmt"
-	"log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -14,76 +16,901 @@ def foo():
 foo()
 `
 
-func TestMain(m *testing.M) {
+// Set to run the conformance suite against a MySQL server too, with
+// the KEXEC_DB_* variables for the other settings. The database is
+// emptied.
+const envTestMySQL = "KEXEC_TEST_MYSQL"
 
-	config := &DalConfig{
-		dbhost:   "100.73.145.91",
-		username: "kexec",
-		password: "password",
+// backend opens an empty DAL of a backend, and closes it.
+type backend struct {
+	name string
+	open func(t *testing.T) (DAL, func())
+}
 
-		dbname: "kexectest",
+func testBackends() []backend {
+	return []backend{
+		{"memory", func(t *testing.T) (DAL, func()) {
+			return NewMemory(), func() {}
+		}},
+		{"sqlite3", func(t *testing.T) (DAL, func()) {
+			dir, err := ioutil.TempDir("", "dal-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			dal, err := NewSQLite(&DalConfig{DBPath: filepath.Join(dir, "kexec.db")})
+			if err != nil {
+				os.RemoveAll(dir)
+				t.Fatal(err)
+			}
+			if _, err := dal.Migrate(dal.LatestVersion()); err != nil {
+				t.Fatal(err)
+			}
+			return dal, func() {
+				dal.Close()
+				os.RemoveAll(dir)
+			}
+		}},
+		{"mysql", func(t *testing.T) (DAL, func()) {
+			if os.Getenv(envTestMySQL) == "" {
+				t.Skipf("%s not set", envTestMySQL)
+			}
+			config := &DalConfig{DBName: "kexectest"}
+			if err := config.LoadEnv(); err != nil {
+				t.Fatal(err)
+			}
+			dal, err := NewMySQL(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := dal.Migrate(dal.LatestVersion()); err != nil {
+				t.Fatal(err)
+			}
+			if err := dal.ClearDatabase(); err != nil {
+				t.Fatal(err)
+			}
+			return dal, func() {
+				dal.ClearDatabase()
+				dal.Close()
+			}
+		}},
+	}
+}
 
-		usersTable:      "users",
-		functionsTable:  "functions",
-		executionsTable: "executions",
+// TestConformance runs every backend through the same checks, so they
+// all behave the same.
+func TestConformance(t *testing.T) {
+	checks := []struct {
+		name  string
+		check func(t *testing.T, dal DAL)
+	}{
+		{"Users", testUsers},
+		{"Functions", testFunctions},
+		{"Executions", testExecutions},
+		{"Builds", testBuilds},
+		{"Versions", testVersions},
+		{"UpdateFunction", testUpdateFunction},
+		{"PutFunctionVersion", testPutFunctionVersion},
+		{"DeleteFunction", testDeleteFunction},
+		{"Groups", testGroups},
 	}
 
-	dal, err := NewMySQL(config)
+	for _, b := range testBackends() {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			for _, c := range checks {
+				c := c
+				t.Run(c.name, func(t *testing.T) {
+					dal, close := b.open(t)
+					defer close()
+					c.check(t, dal)
+				})
+			}
+		})
+	}
+}
 
+// putUser puts a new user and returns its id.
+func putUser(t *testing.T, dal DAL, userName string) int64 {
+	userId, rowCnt, err := dal.PutUserIfNotExisted("", userName)
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
 	}
+	if rowCnt != 1 {
+		t.Fatalf("Putting user %s affected %d rows", userName, rowCnt)
+	}
+	return userId
+}
 
-	if err = dal.Ping(); err != nil {
-		panic(err)
+func putFunction(t *testing.T, dal DAL, userName, funcName string) {
+	_, rowCnt, err := dal.PutFunctionIfNotExisted(userName, &Function{
+		UserID:  -1,
+		Name:    funcName,
+		Content: fmt.Sprintf(funcContentTemp, 1),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rowCnt != 1 {
+		t.Fatalf("Putting function %s affected %d rows", funcName, rowCnt)
 	}
+}
 
-	// Clear DB before test
-	if err = dal.ClearDatabase(); err != nil {
-		panic(err)
+func testUsers(t *testing.T, dal DAL) {
+	_, rowCnt, err := dal.PutUserIfNotExisted("", "TestUser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rowCnt != 1 {
+		t.Errorf("Putting a new user affected %d rows", rowCnt)
 	}
 
-	testUsername := "TestUser"
+	_, rowCnt, err = dal.PutUserIfNotExisted("", "TestUser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rowCnt != 0 {
+		t.Errorf("Putting an existing user affected %d rows", rowCnt)
+	}
 
-	log.Printf("Inserting user...")
-	lastId, rowCount, err := dal.PutUserIfNotExisted("", testUsername)
-	userId := lastId
+	putUser(t, dal, "AnotherUser")
+	names, err := dal.ListUsers()
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
+	}
+	if fmt.Sprint(names) != "[AnotherUser TestUser]" {
+		t.Errorf("Listed users %v", names)
 	}
-	log.Printf("Last ID: %d, Rows affected: %d", lastId, rowCount)
 
-	funcList := make([]*Function, 0, 5)
+	if _, err := dal.ListFunctionsOfUser("default", "Nobody", -1); err != sql.ErrNoRows {
+		t.Errorf("Listing functions of an unknown user: %v", err)
+	}
+	if _, err := dal.ListFunctionsOfUser("default", "", -1); err == nil {
+		t.Errorf("Listed functions without user")
+	}
+}
+
+func testFunctions(t *testing.T, dal DAL) {
+	userId := putUser(t, dal, "TestUser")
+	putUser(t, dal, "OtherUser")
+
 	for i := 0; i < 3; i++ {
-		function := &Function{
-			ID:      -1,
-			UserID:  userId,
-			Name:    fmt.Sprintf("TestFunction%d", i+1),
-			Content: fmt.Sprintf(funcContentTemp, i+1),
-			Created: time.Now(),
+		_, _, err := dal.PutFunctionIfNotExisted("", &Function{
+			ID:            -1,
+			UserID:        userId,
+			Name:          fmt.Sprintf("TestFunction%d", i+1),
+			Content:       fmt.Sprintf(funcContentTemp, i+1),
+			CPULimit:      "500m",
+			MemoryLimit:   "256Mi",
+			MaxRuntime:    60,
+			ImageDigest:   "sha256:0123",
+			Created:       time.Now(),
+			CPURequest:    "100m",
+			MemoryRequest: "64Mi",
+		})
+		if err != nil {
+			t.Fatal(err)
 		}
-		funcList = append(funcList, function)
+	}
+	putFunction(t, dal, "OtherUser", "TestFunction1")
+
+	id, rowCnt, err := dal.PutFunctionIfNotExisted("TestUser", &Function{UserID: -1, Name: "TestFunction1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 0 || rowCnt != 0 {
+		t.Errorf("Putting an existing function returned %d, %d", id, rowCnt)
+	}
+
+	if _, _, err := dal.PutFunctionIfNotExisted("Nobody", &Function{UserID: -1, Name: "f"}); err == nil {
+		t.Errorf("Put a function of an unknown user")
+	}
+
+	byName, err := dal.ListFunctionsOfUser("default", "TestUser", -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byId, err := dal.ListFunctionsOfUser("default", "", userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(byName) != 3 || len(byId) != 3 {
+		t.Fatalf("Listed %d functions by name and %d by id, want 3", len(byName), len(byId))
+	}
+	for i, function := range byName {
+		if function.Name != fmt.Sprintf("TestFunction%d", i+1) || function.UserID != userId {
+			t.Errorf("Function %d is %s of user %d", i, function.Name, function.UserID)
+		}
+		if function.Content != fmt.Sprintf(funcContentTemp, i+1) {
+			t.Errorf("Content of %s is %q", function.Name, function.Content)
+		}
+		if function.Created.IsZero() {
+			t.Errorf("Function %s has no creation time", function.Name)
+		}
+	}
+
+	function, err := dal.GetFunction("TestUser", "TestFunction2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if function.UserID != userId || function.CPURequest != "100m" || function.CPULimit != "500m" ||
+		function.MemoryRequest != "64Mi" || function.MemoryLimit != "256Mi" ||
+		function.MaxRuntime != 60 || function.ImageDigest != "sha256:0123" {
+		t.Errorf("Got function %+v", function)
+	}
+
+	if _, err := dal.GetFunction("TestUser", "Missing"); err != sql.ErrNoRows {
+		t.Errorf("Getting a missing function: %v", err)
+	}
+	if _, err := dal.GetFunction("Nobody", "TestFunction1"); err != sql.ErrNoRows {
+		t.Errorf("Getting a function of an unknown user: %v", err)
+	}
+}
+
+func testExecutions(t *testing.T, dal DAL) {
+	putUser(t, dal, "TestUser")
+	putFunction(t, dal, "TestUser", "hello")
+	putFunction(t, dal, "TestUser", "other")
+
+	function, err := dal.GetFunction("TestUser", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for i, status := range []string{ExecutionSucceeded, ExecutionFailed, ExecutionRunning} {
+		execution := &FunctionExecution{
+			UUID:      fmt.Sprintf("uuid-%d", i),
+			JobName:   fmt.Sprintf("hello-uuid-%d", i),
+			Namespace: "testuser",
+			Params:    `{"n": 1}`,
+			Status:    ExecutionPending,
+			Version:   1,
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+		}
+		if _, _, err := dal.PutExecution("TestUser", "hello", execution); err != nil {
+			t.Fatal(err)
+		}
+		if execution.ID <= 0 || execution.FunctionID != function.ID {
+			t.Errorf("Put execution got id %d of function %d", execution.ID, execution.FunctionID)
+		}
+
+		execution.Status = status
+		execution.ExitCode = i
+		execution.Duration = time.Duration(i) * time.Second
+		execution.Log = "log"
+		execution.Result = `{"ok": true}`
+		execution.Finished = execution.Timestamp.Add(time.Second)
+		if err := dal.UpdateExecution(execution); err != nil {
+			t.Fatal(err)
+		}
+
+		// Updating with the same values changes nothing, but the
+		// execution exists
+		if err := dal.UpdateExecution(execution); err != nil {
+			t.Errorf("Updating execution %s again: %v", execution.UUID, err)
+		}
+	}
+
+	if _, _, err := dal.PutExecution("TestUser", "hello", &FunctionExecution{
+		UUID: "uuid-0", Status: ExecutionPending, Timestamp: start,
+	}); err == nil {
+		t.Errorf("Put an execution with a taken uuid")
+	}
+	if _, _, err := dal.PutExecution("TestUser", "missing", &FunctionExecution{
+		UUID: "uuid-x", Status: ExecutionPending, Timestamp: start,
+	}); err == nil {
+		t.Errorf("Put an execution of a missing function")
+	}
+	if err := dal.UpdateExecution(&FunctionExecution{UUID: "missing", Status: ExecutionFailed}); err == nil {
+		t.Errorf("Updated a missing execution")
+	}
+
+	execution, err := dal.GetExecution("uuid-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if execution.UserName != "TestUser" || execution.FunctionName != "hello" ||
+		execution.JobName != "hello-uuid-1" || execution.Namespace != "testuser" ||
+		execution.Params != `{"n": 1}` || execution.Status != ExecutionFailed ||
+		execution.ExitCode != 1 || execution.Duration != time.Second ||
+		execution.Log != "log" || execution.Result != `{"ok": true}` || execution.Version != 1 {
+		t.Errorf("Got execution %+v", execution)
+	}
+	if execution.Finished.IsZero() {
+		t.Errorf("Execution has no finish time")
+	}
+	if _, err := dal.GetExecution("missing"); err != sql.ErrNoRows {
+		t.Errorf("Getting a missing execution: %v", err)
 	}
 
-	for _, function := range funcList {
-		log.Printf("Inserting function %s...", function.Name)
-		lastId, rowCount, err = dal.PutFunctionIfNotExisted("", function.Name, function.Content, function.UserID)
+	uuids := func(filter *ExecutionFilter) []string {
+		executions, err := dal.ListExecutionsOfFunction("TestUser", "hello", filter)
 		if err != nil {
-			panic(err)
+			t.Fatal(err)
 		}
-		log.Printf("Last ID: %d, Rows affected: %d", lastId, rowCount)
+		list := make([]string, 0, len(executions))
+		for _, execution := range executions {
+			list = append(list, execution.UUID)
+		}
+		return list
+	}
+	for _, test := range []struct {
+		filter *ExecutionFilter
+		want   string
+	}{
+		{nil, "[uuid-2 uuid-1 uuid-0]"},
+		{&ExecutionFilter{Status: ExecutionFailed}, "[uuid-1]"},
+		{&ExecutionFilter{Limit: 2}, "[uuid-2 uuid-1]"},
+		{&ExecutionFilter{Since: start.Add(time.Minute)}, "[uuid-2 uuid-1]"},
+		{&ExecutionFilter{Until: start.Add(time.Minute)}, "[uuid-0]"},
+	} {
+		if got := fmt.Sprint(uuids(test.filter)); got != test.want {
+			t.Errorf("Executions with filter %+v are %s, want %s", test.filter, got, test.want)
+		}
+	}
+
+	others, err := dal.ListExecutionsOfFunction("TestUser", "other", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(others) != 0 {
+		t.Errorf("Function other has %d executions", len(others))
+	}
+}
+
+func testBuilds(t *testing.T, dal DAL) {
+	putUser(t, dal, "TestUser")
+
+	var ids []int64
+	for _, funcName := range []string{"hello", "hello", "other"} {
+		build := &Build{FunctionName: funcName, Status: BuildQueued, Spec: `{"Runtime": "python27"}`}
+		if _, _, err := dal.PutBuild("TestUser", build); err != nil {
+			t.Fatal(err)
+		}
+		if build.ID <= 0 || build.UserName != "TestUser" || build.Created.IsZero() {
+			t.Errorf("Put build %+v", build)
+		}
+		ids = append(ids, build.ID)
+	}
+	if _, _, err := dal.PutBuild("Nobody", &Build{FunctionName: "hello", Status: BuildQueued}); err == nil {
+		t.Errorf("Put a build of an unknown user")
+	}
+
+	finished := &Build{ID: ids[0], Status: BuildReady, Log: "built", Version: 1, Finished: time.Now()}
+	if err := dal.UpdateBuild(finished); err != nil {
+		t.Fatal(err)
+	}
+	if err := dal.UpdateBuild(finished); err != nil {
+		t.Errorf("Updating build %d again: %v", finished.ID, err)
+	}
+	if err := dal.UpdateBuild(&Build{ID: ids[2] + 100, Status: BuildFailed}); err == nil {
+		t.Errorf("Updated a missing build")
+	}
+
+	build, err := dal.GetBuild(ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if build.UserName != "TestUser" || build.FunctionName != "hello" || build.Status != BuildReady ||
+		build.Spec != `{"Runtime": "python27"}` || build.Log != "built" || build.Version != 1 ||
+		build.Finished.IsZero() {
+		t.Errorf("Got build %+v", build)
+	}
+	if _, err := dal.GetBuild(ids[2] + 100); err != sql.ErrNoRows {
+		t.Errorf("Getting a missing build: %v", err)
+	}
+
+	unfinished, err := dal.ListUnfinishedBuilds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unfinished) != 2 || unfinished[0].ID != ids[1] || unfinished[1].ID != ids[2] {
+		t.Errorf("Unfinished builds are %v", unfinished)
+	}
+
+	builds, err := dal.ListBuilds("TestUser", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(builds) != 2 || builds[0].ID != ids[1] || builds[1].ID != ids[0] {
+		t.Errorf("Builds of hello are %v", builds)
+	}
+}
+
+func testVersions(t *testing.T, dal DAL) {
+	putUser(t, dal, "TestUser")
+	putFunction(t, dal, "TestUser", "hello")
+
+	for i := 1; i <= 3; i++ {
+		version := &Version{
+			FunctionName: "hello",
+			CodeHash:     fmt.Sprintf("hash%d", i),
+			Image:        fmt.Sprintf("registry/testuser/hello:hash%d", i),
+			ImageDigest:  "sha256:0123",
+			Content:      fmt.Sprintf(funcContentTemp, i),
+		}
+		if _, _, err := dal.PutVersion("TestUser", version); err != nil {
+			t.Fatal(err)
+		}
+		if version.Version != i || version.UserName != "TestUser" {
+			t.Errorf("Put version %d as %d of %s", i, version.Version, version.UserName)
+		}
+	}
+	if _, _, err := dal.PutVersion("Nobody", &Version{FunctionName: "hello"}); err == nil {
+		t.Errorf("Put a version of an unknown user")
+	}
+
+	version, err := dal.GetVersion("TestUser", "hello", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version.CodeHash != "hash2" || version.Image != "registry/testuser/hello:hash2" ||
+		version.ImageDigest != "sha256:0123" || version.Content != fmt.Sprintf(funcContentTemp, 2) {
+		t.Errorf("Got version %+v", version)
+	}
+	if _, err := dal.GetVersion("TestUser", "hello", 4); err != sql.ErrNoRows {
+		t.Errorf("Getting a missing version: %v", err)
+	}
+
+	versions, err := dal.ListVersions("TestUser", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 || versions[0].Version != 3 || versions[2].Version != 1 {
+		t.Errorf("Versions of hello are %v", versions)
+	}
+
+	if err := dal.SetDefaultVersion("TestUser", "hello", 2); err != nil {
+		t.Fatal(err)
+	}
+	function, err := dal.GetFunction("TestUser", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if function.DefaultVersion != 2 {
+		t.Errorf("Default version is %d, want 2", function.DefaultVersion)
+	}
+	if function.Content != fmt.Sprintf(funcContentTemp, 2) || function.ImageDigest != "sha256:0123" {
+		t.Errorf("Function has content %q and digest %q, not those of version 2",
+			function.Content, function.ImageDigest)
+	}
+	if function.Updated.IsZero() {
+		t.Errorf("Function has no update time after a new default version")
+	}
+	if err := dal.SetDefaultVersion("TestUser", "missing", 1); err != sql.ErrNoRows {
+		t.Errorf("Setting the default version of a missing function: %v", err)
 	}
+	if err := dal.SetDefaultVersion("TestUser", "hello", 4); err != sql.ErrNoRows {
+		t.Errorf("Setting a missing version as default: %v", err)
+	}
+}
 
-	functions, err := dal.ListFunctionsOfUser("default", testUsername, -1)
+func testUpdateFunction(t *testing.T, dal DAL) {
+	putUser(t, dal, "TestUser")
+	putFunction(t, dal, "TestUser", "hello")
+	created, err := dal.GetFunction("TestUser", "hello")
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
+	}
+	if !created.Updated.IsZero() {
+		t.Errorf("New function was updated at %v", created.Updated)
+	}
+
+	function := &Function{
+		Name:           "hello",
+		Content:        fmt.Sprintf(funcContentTemp, 2),
+		MaxRuntime:     30,
+		ImageDigest:    "sha256:4567",
+		DefaultVersion: 1,
+	}
+	if err := dal.UpdateFunction("TestUser", function); err != nil {
+		t.Fatal(err)
+	}
+	if function.ID != created.ID || function.UserID != created.UserID {
+		t.Errorf("Updated function %d of user %d, want %d of %d",
+			function.ID, function.UserID, created.ID, created.UserID)
+	}
+
+	updated, err := dal.GetFunction("TestUser", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Content != function.Content || updated.MaxRuntime != 30 ||
+		updated.ImageDigest != "sha256:4567" || updated.DefaultVersion != 1 {
+		t.Errorf("Got updated function %+v", updated)
+	}
+	if updated.Updated.IsZero() {
+		t.Errorf("Updated function has no update time")
+	}
+
+	// The content before versions is kept as version 0, once
+	version, err := dal.GetVersion("TestUser", "hello", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version.Content != created.Content {
+		t.Errorf("Version 0 has content %q, want %q", version.Content, created.Content)
+	}
+	function.Content = fmt.Sprintf(funcContentTemp, 3)
+	if err := dal.UpdateFunction("TestUser", function); err != nil {
+		t.Fatal(err)
+	}
+	if versions, _ := dal.ListVersions("TestUser", "hello"); len(versions) != 1 {
+		t.Errorf("Function has %d versions after updates, want 1", len(versions))
+	}
+
+	if err := dal.UpdateFunction("TestUser", &Function{Name: "missing"}); err != sql.ErrNoRows {
+		t.Errorf("Updating a missing function: %v", err)
+	}
+}
+
+func testPutFunctionVersion(t *testing.T, dal DAL) {
+	putUser(t, dal, "TestUser")
+
+	put := func(content string, update bool) int64 {
+		function := &Function{UserID: -1, Name: "hello", Content: content}
+		version := &Version{FunctionName: "hello", Content: content}
+		_, rowCnt, err := dal.PutFunctionVersion("TestUser", function, version, update)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rowCnt
+	}
+	check := func(content string, defaultVersion, versions int) {
+		function, err := dal.GetFunction("TestUser", "hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if function.Content != content || function.DefaultVersion != defaultVersion {
+			t.Errorf("Got function %+v, want content %q and version %d", function, content, defaultVersion)
+		}
+		if list, _ := dal.ListVersions("TestUser", "hello"); len(list) != versions {
+			t.Errorf("Function has %d versions, want %d", len(list), versions)
+		}
+	}
+
+	// Updating a missing function creates it
+	if rowCnt := put("v1", true); rowCnt != 1 {
+		t.Errorf("Putting a new function affected %d rows", rowCnt)
+	}
+	check("v1", 1, 1)
+
+	// Creating an existing function leaves no version behind
+	if rowCnt := put("v2", false); rowCnt != 0 {
+		t.Errorf("Putting an existing function affected %d rows", rowCnt)
+	}
+	check("v1", 1, 1)
+
+	if rowCnt := put("v2", true); rowCnt != 1 {
+		t.Errorf("Updating a function affected %d rows", rowCnt)
+	}
+	check("v2", 2, 2)
+
+	_, _, err := dal.PutFunctionVersion("Nobody", &Function{UserID: -1, Name: "hello"}, &Version{FunctionName: "hello"}, false)
+	if err != sql.ErrNoRows {
+		t.Errorf("Putting a function of an unknown user: %v", err)
+	}
+}
+
+func testDeleteFunction(t *testing.T, dal DAL) {
+	putUser(t, dal, "TestUser")
+	for _, funcName := range []string{"hello", "other"} {
+		putFunction(t, dal, "TestUser", funcName)
+		execution := &FunctionExecution{UUID: "uuid-" + funcName, Status: ExecutionPending, Timestamp: time.Now()}
+		if _, _, err := dal.PutExecution("TestUser", funcName, execution); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := dal.PutVersion("TestUser", &Version{FunctionName: funcName, CodeHash: "h", Image: "i"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := dal.PutBuild("TestUser", &Build{FunctionName: funcName, Status: BuildReady}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deleted, err := dal.DeleteFunction("TestUser", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Errorf("Deleted %d functions, want 1", deleted)
+	}
+
+	if _, err := dal.GetFunction("TestUser", "hello"); err != sql.ErrNoRows {
+		t.Errorf("Getting a deleted function: %v", err)
+	}
+	if _, err := dal.GetExecution("uuid-hello"); err != sql.ErrNoRows {
+		t.Errorf("Getting an execution of a deleted function: %v", err)
+	}
+	if versions, _ := dal.ListVersions("TestUser", "hello"); len(versions) != 0 {
+		t.Errorf("Deleted function has %d versions", len(versions))
+	}
+	if builds, _ := dal.ListBuilds("TestUser", "hello"); len(builds) != 0 {
+		t.Errorf("Deleted function has %d builds", len(builds))
+	}
+
+	// Other functions are left alone
+	if _, err := dal.GetExecution("uuid-other"); err != nil {
+		t.Errorf("Getting an execution of another function: %v", err)
+	}
+	if versions, _ := dal.ListVersions("TestUser", "other"); len(versions) != 1 {
+		t.Errorf("Other function has %d versions", len(versions))
+	}
+
+	for _, userName := range []string{"TestUser", "Nobody"} {
+		deleted, err := dal.DeleteFunction(userName, "hello")
+		if err != nil || deleted != 0 {
+			t.Errorf("Deleting a missing function of %s: %d, %v", userName, deleted, err)
+		}
+	}
+}
+
+// groupNames returns the names of groups.
+func groupNames(groups []*Group) []string {
+	names := make([]string, 0, len(groups))
+	for _, group := range groups {
+		names = append(names, group.Name)
+	}
+	return names
+}
+
+func testGroups(t *testing.T, dal DAL) {
+	for _, groupName := range []string{"team", "admins"} {
+		if _, rowCnt, err := dal.PutGroupIfNotExisted(groupName); err != nil || rowCnt != 1 {
+			t.Fatalf("Putting group %s affected %d rows: %v", groupName, rowCnt, err)
+		}
+	}
+	if id, rowCnt, err := dal.PutGroupIfNotExisted("team"); err != nil || id != 0 || rowCnt != 0 {
+		t.Errorf("Putting an existing group returned %d, %d, %v", id, rowCnt, err)
+	}
+
+	// Functions of a group belong to its owner account
+	putFunction(t, dal, GroupOwner("team"), "hello")
+	if _, err := dal.GetFunction(GroupOwner("team"), "hello"); err != nil {
+		t.Errorf("Getting a function of a group: %v", err)
+	}
+
+	if _, rowCnt, err := dal.PutUserIfNotExisted("team", "alice"); err != nil || rowCnt != 1 {
+		t.Fatalf("Putting a member affected %d rows: %v", rowCnt, err)
+	}
+	putUser(t, dal, "bob")
+	if _, rowCnt, err := dal.PutUserIfNotExisted("team", "bob"); err != nil || rowCnt != 0 {
+		t.Errorf("Adding an existing user to a group affected %d rows: %v", rowCnt, err)
+	}
+	if _, _, err := dal.PutUserIfNotExisted("missing", "alice"); err != sql.ErrNoRows {
+		t.Errorf("Adding a user to a missing group: %v", err)
+	}
+
+	for _, c := range []struct {
+		user   string
+		member bool
+	}{{"alice", true}, {"bob", true}, {"carol", false}} {
+		member, err := dal.IsGroupMember("team", c.user)
+		if err != nil || member != c.member {
+			t.Errorf("%s is a member of team: %v, %v", c.user, member, err)
+		}
+	}
+	if _, err := dal.IsGroupMember("missing", "alice"); err != sql.ErrNoRows {
+		t.Errorf("Checking the members of a missing group: %v", err)
+	}
+
+	group, err := dal.GetGroup("team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(group.Users) != 2 || group.Users[0].Name != "alice" || group.Users[1].Name != "bob" {
+		t.Errorf("Members of team are %v", group.Users)
+	}
+	if _, err := dal.GetGroup("missing"); err != sql.ErrNoRows {
+		t.Errorf("Getting a missing group: %v", err)
+	}
+
+	groups, err := dal.ListGroups("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := groupNames(groups); fmt.Sprint(names) != "[admins team]" {
+		t.Errorf("Groups are %v", names)
+	}
+
+	// Syncs create groups, and drop the memberships of former syncs
+	// only
+	if err := dal.SyncUserGroups("bob", []string{"ops", "admins"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dal.SyncUserGroups("alice", []string{"ops"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dal.SyncUserGroups("bob", []string{"ops"}); err != nil {
+		t.Fatal(err)
+	}
+	for user, want := range map[string]string{"alice": "[ops team]", "bob": "[ops team]"} {
+		groups, err := dal.ListGroups(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if names := groupNames(groups); fmt.Sprint(names) != want {
+			t.Errorf("Groups of %s are %v, want %s", user, names, want)
+		}
+	}
+	if err := dal.SyncUserGroups("alice", nil); err != nil {
+		t.Fatal(err)
+	}
+	if member, _ := dal.IsGroupMember("ops", "alice"); member {
+		t.Errorf("alice is still a member of ops")
+	}
+	if err := dal.SyncUserGroups("nobody", nil); err != sql.ErrNoRows {
+		t.Errorf("Syncing the groups of an unknown user: %v", err)
+	}
+
+	if removed, err := dal.RemoveGroupMember("team", "alice"); err != nil || removed != 1 {
+		t.Errorf("Removing a member removed %d: %v", removed, err)
+	}
+	if removed, err := dal.RemoveGroupMember("team", "alice"); err != nil || removed != 0 {
+		t.Errorf("Removing a former member removed %d: %v", removed, err)
+	}
+}
+
+func TestGroupOwner(t *testing.T) {
+	owner := GroupOwner("team")
+	if group, ok := OwnerGroup(owner); !ok || group != "team" {
+		t.Errorf("Group of %s is %q, %v", owner, group, ok)
+	}
+	if _, ok := OwnerGroup("alice"); ok {
+		t.Errorf("alice is the account of a group")
+	}
+}
+
+// TestMigrations migrates the backends having a schema all the way down
+// and up again.
+func TestMigrations(t *testing.T) {
+	for _, b := range testBackends() {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			dal, close := b.open(t)
+			defer close()
+
+			m, ok := dal.(Migrator)
+			if !ok {
+				t.Skipf("%s has no schema", b.name)
+			}
+			latest := m.LatestVersion()
+			if err := CheckSchema(m); err != nil {
+				t.Fatal(err)
+			}
+
+			steps, err := m.PlanMigration(latest)
+			if err != nil || len(steps) != 0 {
+				t.Errorf("Planned %v, %v at the latest version", steps, err)
+			}
+			if _, err := m.PlanMigration(latest + 1); err == nil {
+				t.Errorf("Planned a migration to an unknown version")
+			}
+
+			steps, err = m.Migrate(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(steps) != latest || !steps[0].Revert || steps[0].Version != latest {
+				t.Errorf("Migrating down ran %v", steps)
+			}
+			if err := CheckSchema(m); err == nil {
+				t.Errorf("Schema at version 0 is the latest")
+			}
+			if _, err := dal.GetFunction("TestUser", "hello"); err == nil || err == sql.ErrNoRows {
+				t.Errorf("Tables are left after migrating down: %v", err)
+			}
+
+			// A dry run changes nothing
+			if steps, err := m.PlanMigration(latest); err != nil || len(steps) != latest {
+				t.Errorf("Planned %v, %v", steps, err)
+			}
+			if version, err := m.SchemaVersion(); err != nil || version != 0 {
+				t.Errorf("Schema version after planning is %d, %v", version, err)
+			}
+
+			steps, err = m.Migrate(latest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(steps) != latest || steps[0].Revert || steps[0].Version != 1 {
+				t.Errorf("Migrating up ran %v", steps)
+			}
+			testUsers(t, dal)
+		})
+	}
+}
+
+// TestAdoptDatabase migrates a SQLite database created before
+// migrations.
+func TestAdoptDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dal-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dal, err := NewSQLite(&DalConfig{DBPath: filepath.Join(dir, "kexec.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dal.Close()
+
+	for _, statement := range sqliteMigrations[0].Up {
+		if _, err := dal.Exec(dal.tables.Replace(statement)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	putUser(t, dal, "TestUser")
+
+	// Functions used to be put again on every submission
+	for i := 0; i < 2; i++ {
+		putFunction(t, dal, "TestUser", "hello")
+	}
+	if _, err := dal.Exec("INSERT INTO executions (f_id, uuid, status, created) VALUES (1, 'uuid', 'Pending', CURRENT_TIMESTAMP)"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := dal.Migrate(dal.LatestVersion()); err != nil {
+		t.Fatal(err)
+	}
+	if _, rowCnt, err := dal.PutUserIfNotExisted("", "TestUser"); err != nil || rowCnt != 0 {
+		t.Errorf("User is lost after migrating: %d, %v", rowCnt, err)
+	}
+
+	functions, err := dal.ListFunctionsOfUser("default", "TestUser", -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(functions) != 1 || functions[0].ID != 2 {
+		t.Fatalf("Functions after migrating are %v, want the latest", functions)
+	}
+	execution, err := dal.GetExecution("uuid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if execution.FunctionID != 2 {
+		t.Errorf("Execution of a duplicate is of function %d, want 2", execution.FunctionID)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	for _, driver := range []string{"memory", "mysql", "sqlite3"} {
+		found := false
+		for _, d := range Drivers() {
+			found = found || d == driver
+		}
+		if !found {
+			t.Errorf("Driver %s is not registered", driver)
+		}
+	}
+
+	if dal, err := Open(&DalConfig{Driver: "memory"}); err != nil {
+		t.Error(err)
+	} else if _, ok := dal.(*Memory); !ok {
+		t.Errorf("Opened a %T", dal)
+	}
+	if _, err := Open(&DalConfig{Driver: "oracle"}); err == nil {
+		t.Errorf("Opened an unknown driver")
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	defer os.Unsetenv(EnvDriver)
+	defer os.Unsetenv(EnvPort)
+
+	config := &DalConfig{Driver: "mysql", DBHost: "db", DBPort: 3306}
+	os.Setenv(EnvDriver, "sqlite3")
+	os.Setenv(EnvPort, "3307")
+	if err := config.LoadEnv(); err != nil {
+		t.Fatal(err)
 	}
-	if len(functions) != len(funcList) {
-		panic(errors.New("Size of function list is not right."))
+	if config.Driver != "sqlite3" || config.DBPort != 3307 || config.DBHost != "db" {
+		t.Errorf("Loaded %+v", config)
 	}
 
-	// Clear DB after test
-	if err = dal.ClearDatabase(); err != nil {
-		panic(err)
+	os.Setenv(EnvPort, "mysql")
+	if err := config.LoadEnv(); err == nil {
+		t.Errorf("Loaded an invalid port")
 	}
 }
@@ -0,0 +1,264 @@
+package dal
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// GroupOwnerPrefix starts the names of the accounts owning the functions
+// of groups. LDAP user names do not have it.
+const GroupOwnerPrefix = "group:"
+
+// GroupOwner returns the name of the account owning the functions of a
+// group. The functions, versions, builds and executions of a group are
+// recorded under this name, like those of a user.
+func GroupOwner(groupName string) string {
+	return GroupOwnerPrefix + groupName
+}
+
+// OwnerGroup returns the group whose functions an account owns, if it
+// is the account of a group.
+func OwnerGroup(owner string) (string, bool) {
+	if !strings.HasPrefix(owner, GroupOwnerPrefix) {
+		return "", false
+	}
+	return owner[len(GroupOwnerPrefix):], true
+}
+
+// queryer is a database or a transaction.
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func (dal *sqlDAL) PutGroupIfNotExisted(groupName string) (int64, int64, error) {
+	tx, err := dal.Begin()
+	if err != nil {
+		return -1, -1, err
+	}
+	defer tx.Rollback()
+
+	lastId, rowCnt, err := dal.putGroup(tx, groupName)
+	if err != nil {
+		return -1, -1, err
+	}
+	if err := tx.Commit(); err != nil {
+		return -1, -1, err
+	}
+	return lastId, rowCnt, nil
+}
+
+// putGroup inserts a group and its owner account unless they exist.
+func (dal *sqlDAL) putGroup(q queryer, groupName string) (int64, int64, error) {
+	owner := GroupOwner(groupName)
+	_, err := q.Exec(fmt.Sprintf(dal.insertIgnore+" INTO %s (name, created) VALUES (?, ?)",
+		dal.UsersTable), owner, time.Now())
+	if err != nil {
+		return -1, -1, err
+	}
+
+	var ownerId int64
+	err = q.QueryRow(fmt.Sprintf("SELECT u_id FROM %s WHERE name = ?", dal.UsersTable), owner).Scan(&ownerId)
+	if err != nil {
+		return -1, -1, err
+	}
+
+	res, err := q.Exec(fmt.Sprintf(dal.insertIgnore+" INTO %s (name, u_id, created) VALUES (?, ?, ?)",
+		dal.GroupsTable), groupName, ownerId, time.Now())
+	if err != nil {
+		return -1, -1, err
+	}
+
+	lastId, err := res.LastInsertId()
+	if err != nil {
+		return -1, -1, err
+	}
+	rowCnt, err := res.RowsAffected()
+	if err != nil {
+		return -1, -1, err
+	}
+	if rowCnt == 0 {
+		lastId = 0
+	}
+	return lastId, rowCnt, nil
+}
+
+// groupID returns the id of a group, or sql.ErrNoRows.
+func (dal *sqlDAL) groupID(q queryer, groupName string) (int64, error) {
+	var id int64
+	err := q.QueryRow(fmt.Sprintf("SELECT g_id FROM %s WHERE name = ?", dal.GroupsTable), groupName).Scan(&id)
+	return id, err
+}
+
+// addGroupMember makes a user a member of a group. The membership is
+// kept by later syncs.
+func (dal *sqlDAL) addGroupMember(groupName, userName string) error {
+	tx, err := dal.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	gid, err := dal.groupID(tx, groupName)
+	if err != nil {
+		return err
+	}
+	var uid int64
+	err = tx.QueryRow(fmt.Sprintf("SELECT u_id FROM %s WHERE name = ?", dal.UsersTable), userName).Scan(&uid)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf(dal.insertIgnore+" INTO %s (g_id, u_id, synced, created) VALUES (?, ?, ?, ?)",
+		dal.GroupMembersTable), gid, uid, false, time.Now())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET synced = ? WHERE g_id = ? AND u_id = ?",
+		dal.GroupMembersTable), false, gid, uid)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (dal *sqlDAL) GetGroup(groupName string) (*Group, error) {
+	group := &Group{}
+	err := dal.QueryRow(fmt.Sprintf("SELECT g_id, name, created FROM %s WHERE name = ?",
+		dal.GroupsTable), groupName).Scan(&group.ID, &group.Name, &group.Created)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := dal.Query(fmt.Sprintf(
+		`SELECT u.u_id, u.name, u.created
+		FROM %s m JOIN %s u ON m.u_id = u.u_id
+		WHERE m.g_id = ?
+		ORDER BY u.name`,
+		dal.GroupMembersTable, dal.UsersTable), group.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	group.Users = make([]User, 0, 5)
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Name, &user.Created); err != nil {
+			return nil, err
+		}
+		group.Users = append(group.Users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+func (dal *sqlDAL) ListGroups(userName string) ([]*Group, error) {
+	var rows *sql.Rows
+	var err error
+	if userName == "" {
+		rows, err = dal.Query(fmt.Sprintf("SELECT g_id, name, created FROM %s ORDER BY name",
+			dal.GroupsTable))
+	} else {
+		rows, err = dal.Query(fmt.Sprintf(
+			`SELECT g.g_id, g.name, g.created
+			FROM %s g
+				JOIN %s m ON g.g_id = m.g_id
+				JOIN %s u ON m.u_id = u.u_id
+			WHERE u.name = ?
+			ORDER BY g.name`,
+			dal.GroupsTable, dal.GroupMembersTable, dal.UsersTable), userName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := make([]*Group, 0, 5)
+	for rows.Next() {
+		group := &Group{}
+		if err := rows.Scan(&group.ID, &group.Name, &group.Created); err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+func (dal *sqlDAL) IsGroupMember(groupName, userName string) (bool, error) {
+	gid, err := dal.groupID(dal, groupName)
+	if err != nil {
+		return false, err
+	}
+
+	var count int
+	err = dal.QueryRow(fmt.Sprintf(
+		`SELECT COUNT(*) FROM %s m JOIN %s u ON m.u_id = u.u_id
+		WHERE m.g_id = ? AND u.name = ?`,
+		dal.GroupMembersTable, dal.UsersTable), gid, userName).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (dal *sqlDAL) RemoveGroupMember(groupName, userName string) (int64, error) {
+	res, err := dal.Exec(fmt.Sprintf(
+		`DELETE FROM %s
+		WHERE g_id IN (SELECT g_id FROM %s WHERE name = ?)
+			AND u_id IN (SELECT u_id FROM %s WHERE name = ?)`,
+		dal.GroupMembersTable, dal.GroupsTable, dal.UsersTable), groupName, userName)
+	if err != nil {
+		return -1, err
+	}
+	return res.RowsAffected()
+}
+
+func (dal *sqlDAL) SyncUserGroups(userName string, groupNames []string) error {
+	tx, err := dal.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var uid int64
+	err = tx.QueryRow(fmt.Sprintf("SELECT u_id FROM %s WHERE name = ?", dal.UsersTable), userName).Scan(&uid)
+	if err != nil {
+		return err
+	}
+
+	gids := make([]interface{}, 0, len(groupNames))
+	for _, groupName := range groupNames {
+		if _, _, err := dal.putGroup(tx, groupName); err != nil {
+			return err
+		}
+		gid, err := dal.groupID(tx, groupName)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(fmt.Sprintf(dal.insertIgnore+" INTO %s (g_id, u_id, synced, created) VALUES (?, ?, ?, ?)",
+			dal.GroupMembersTable), gid, uid, true, time.Now())
+		if err != nil {
+			return err
+		}
+		gids = append(gids, gid)
+	}
+
+	// Drop the synced memberships of the groups the user left
+	query := fmt.Sprintf("DELETE FROM %s WHERE u_id = ? AND synced = ?", dal.GroupMembersTable)
+	if len(gids) > 0 {
+		query += " AND g_id NOT IN (?" + strings.Repeat(", ?", len(gids)-1) + ")"
+	}
+	if _, err := tx.Exec(query, append([]interface{}{uid, true}, gids...)...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
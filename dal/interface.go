@@ -4,17 +4,144 @@ type DAL interface {
 	// List functions created by a user
 	ListFunctionsOfUser(namespace, username string, userId int64) ([]*Function, error)
 
-	// Insert user into DB if not existed.
+	// Insert user into DB if not existed, and make the user a member
+	// of group `groupName` if it is not empty. The group must exist.
 	//
 	// Returns: (int64) insert row id,
 	//          (int64) # of rows influenced,
 	//          (error) if there is one
 	PutUserIfNotExisted(groupName, userName string) (int64, int64, error)
 
-	// Insert function into DB if not existed.
+	// List the names of all the users, including the accounts owning
+	// the functions of groups, by name
+	ListUsers() ([]string, error)
+
+	// Insert function into DB if the user has no function of the same
+	// name.
+	//
+	// Returns: (int64) insert row id,
+	//          (int64) # of rows influenced,
+	//          (error) if there is one
+	//
+	// `function.UserID` is used to find the owner if it is not negative,
+	// `userName` otherwise.
+	PutFunctionIfNotExisted(userName string, function *Function) (int64, int64, error)
+
+	// Get a function of a user by name
+	GetFunction(userName, funcName string) (*Function, error)
+
+	// Update the content, resource limits, image digest and default
+	// version of the function `function.Name` of a user, and bump its
+	// update time. The previous content of a function created before
+	// versions is recorded as its version 0.
+	UpdateFunction(userName string, function *Function) error
+
+	// Insert an execution of a function into DB. It is called when
+	// the job of the execution is submitted.
+	//
+	// Returns: (int64) insert row id,
+	//          (int64) # of rows influenced,
+	//          (error) if there is one
+	PutExecution(userName, funcName string, execution *FunctionExecution) (int64, int64, error)
+
+	// Update status, exit code, duration, log and finish time of an
+	// execution, found by its uuid.
+	UpdateExecution(execution *FunctionExecution) error
+
+	// Get an execution by its uuid
+	GetExecution(uuid string) (*FunctionExecution, error)
+
+	// List executions of a function, latest first
+	ListExecutionsOfFunction(userName, funcName string, filter *ExecutionFilter) ([]*FunctionExecution, error)
+
+	// Insert a build of a function into DB. It is called when the
+	// build is submitted.
 	//
 	// Returns: (int64) insert row id,
 	//          (int64) # of rows influenced,
 	//          (error) if there is one
-	PutFunctionIfNotExisted(userName, funcName, funcContent string, userId int64) (int64, int64, error)
+	PutBuild(userName string, build *Build) (int64, int64, error)
+
+	// Update status, log, error, version and finish time of a build
+	UpdateBuild(build *Build) error
+
+	// Get a build by its id
+	GetBuild(id int64) (*Build, error)
+
+	// List the builds which are queued, building or pushing, oldest
+	// first
+	ListUnfinishedBuilds() ([]*Build, error)
+
+	// List the builds of a function, latest first
+	ListBuilds(userName, funcName string) ([]*Build, error)
+
+	// Insert a version of a function into DB, numbered after the
+	// latest version of the function.
+	//
+	// Returns: (int64) insert row id,
+	//          (int64) # of rows influenced,
+	//          (error) if there is one
+	PutVersion(userName string, version *Version) (int64, int64, error)
+
+	// Insert a version of a function like PutVersion, and make it the
+	// default version of `function`, in a transaction. The function is
+	// created, unless `update` is set and the function exists, in which
+	// case it is updated like with UpdateFunction. If the function exists
+	// and `update` is not set, nothing is inserted.
+	//
+	// Returns: (int64) insert row id of the version,
+	//          (int64) # of rows influenced, 0 if the function exists,
+	//          (error) if there is one
+	PutFunctionVersion(userName string, function *Function, version *Version, update bool) (int64, int64, error)
+
+	// Get a version of a function by its number
+	GetVersion(userName, funcName string, version int) (*Version, error)
+
+	// List the versions of a function, latest first
+	ListVersions(userName, funcName string) ([]*Version, error)
+
+	// Make a version the default version of a function, with the
+	// content and image digest of the version, and bump its update
+	// time. It fails with sql.ErrNoRows if the function or the version
+	// does not exist.
+	SetDefaultVersion(userName, funcName string, version int) error
+
+	// Delete the functions named `funcName` of a user, along with
+	// their executions, versions and builds. Deleting a function which
+	// does not exist is not an error.
+	//
+	// Returns: (int64) # of functions deleted,
+	//          (error) if there is one
+	DeleteFunction(userName, funcName string) (int64, error)
+
+	// Insert a group into DB if not existed, along with the account
+	// owning its functions, GroupOwner(groupName).
+	//
+	// Returns: (int64) insert row id,
+	//          (int64) # of rows influenced,
+	//          (error) if there is one
+	PutGroupIfNotExisted(groupName string) (int64, int64, error)
+
+	// Get a group by name, with its members
+	GetGroup(groupName string) (*Group, error)
+
+	// List the groups a user is a member of, or all the groups if
+	// `userName` is empty, by name. Members are not read.
+	ListGroups(userName string) ([]*Group, error)
+
+	// Tell whether a user is a member of a group. It fails with
+	// sql.ErrNoRows if the group does not exist.
+	IsGroupMember(groupName, userName string) (bool, error)
+
+	// Remove a user from a group.
+	//
+	// Returns: (int64) # of members removed,
+	//          (error) if there is one
+	RemoveGroupMember(groupName, userName string) (int64, error)
+
+	// Make a user a member of the groups `groupNames`, creating them
+	// if needed, and remove the user from the other groups a previous
+	// sync made the user a member of. Memberships added with
+	// PutUserIfNotExisted are kept.
+	SyncUserGroups(userName string, groupNames []string) error
 }
@@ -0,0 +1,731 @@
+package dal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory is a DAL keeping everything in memory, for tests and trying
+// things out. It has the semantics of the SQL backends, down to the
+// errors: missing rows are sql.ErrNoRows. Nothing survives a restart.
+type Memory struct {
+	mu sync.Mutex
+
+	users      []*User
+	functions  []*Function
+	executions []*FunctionExecution
+	builds     []*Build
+	versions   []*Version
+	groups     []*Group
+	members    []*memoryMember
+
+	// Last id given per table
+	lastID map[string]int64
+}
+
+// memoryMember is a membership of a user in a group.
+type memoryMember struct {
+	groupID int64
+	userID  int64
+
+	// Added by SyncUserGroups
+	synced bool
+}
+
+func init() {
+	Register("memory", func(config *DalConfig) (DAL, error) {
+		return NewMemory(), nil
+	})
+}
+
+// NewMemory creates an empty in-memory DAL.
+func NewMemory() *Memory {
+	return &Memory{lastID: make(map[string]int64)}
+}
+
+func (m *Memory) nextID(table string) int64 {
+	m.lastID[table]++
+	return m.lastID[table]
+}
+
+func (m *Memory) userByName(userName string) *User {
+	for _, user := range m.users {
+		if user.Name == userName {
+			return user
+		}
+	}
+	return nil
+}
+
+func (m *Memory) userByID(uid int64) *User {
+	for _, user := range m.users {
+		if user.ID == uid {
+			return user
+		}
+	}
+	return nil
+}
+
+// userID finds a user by id if `uid` is not negative, by name
+// otherwise.
+func (m *Memory) userID(userName string, uid int64) (int64, error) {
+	if uid < 0 && userName == "" {
+		return -1, errors.New("Either userName or userId should be valid")
+	}
+
+	if uid >= 0 {
+		if m.userByID(uid) == nil {
+			return -1, fmt.Errorf("User %d not found", uid)
+		}
+		return uid, nil
+	}
+
+	user := m.userByName(userName)
+	if user == nil {
+		return -1, sql.ErrNoRows
+	}
+	return user.ID, nil
+}
+
+// latestFunction returns the latest function named `funcName` of a
+// user.
+func (m *Memory) latestFunction(userName, funcName string) *Function {
+	user := m.userByName(userName)
+	if user == nil {
+		return nil
+	}
+	for i := len(m.functions) - 1; i >= 0; i-- {
+		function := m.functions[i]
+		if function.UserID == user.ID && function.Name == funcName {
+			return function
+		}
+	}
+	return nil
+}
+
+func (m *Memory) ListFunctionsOfUser(namespace, username string, userId int64) ([]*Function, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	uid := userId
+	if uid < 0 {
+		var err error
+		if uid, err = m.userID(username, uid); err != nil {
+			return nil, err
+		}
+	}
+
+	funcList := make([]*Function, 0, 5)
+	for _, function := range m.functions {
+		if function.UserID == uid {
+			f := *function
+			funcList = append(funcList, &f)
+		}
+	}
+	return funcList, nil
+}
+
+func (m *Memory) PutUserIfNotExisted(groupName, userName string) (int64, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var lastId, rowCnt int64
+	user := m.userByName(userName)
+	if user == nil {
+		user = &User{ID: m.nextID("users"), Name: userName, Created: time.Now()}
+		m.users = append(m.users, user)
+		lastId, rowCnt = user.ID, 1
+	}
+
+	if groupName != "" {
+		group := m.groupByName(groupName)
+		if group == nil {
+			return -1, -1, sql.ErrNoRows
+		}
+		if member := m.member(group.ID, user.ID); member != nil {
+			member.synced = false
+		} else {
+			m.members = append(m.members, &memoryMember{groupID: group.ID, userID: user.ID})
+		}
+	}
+	return lastId, rowCnt, nil
+}
+
+func (m *Memory) ListUsers() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.users))
+	for _, user := range m.users {
+		names = append(names, user.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (m *Memory) PutFunctionIfNotExisted(userName string, function *Function) (int64, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.putFunction(userName, function)
+}
+
+func (m *Memory) putFunction(userName string, function *Function) (int64, int64, error) {
+	uid, err := m.userID(userName, function.UserID)
+	if err != nil {
+		return -1, -1, err
+	}
+	for _, f := range m.functions {
+		if f.UserID == uid && f.Name == function.Name {
+			return 0, 0, nil
+		}
+	}
+
+	f := *function
+	f.ID = m.nextID("functions")
+	f.UserID = uid
+	f.Created = time.Now()
+	f.Updated = time.Time{}
+	m.functions = append(m.functions, &f)
+	return f.ID, 1, nil
+}
+
+func (m *Memory) GetFunction(userName, funcName string) (*Function, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	function := m.latestFunction(userName, funcName)
+	if function == nil {
+		return nil, sql.ErrNoRows
+	}
+	f := *function
+	return &f, nil
+}
+
+func (m *Memory) UpdateFunction(userName string, function *Function) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.updateFunction(userName, function)
+}
+
+func (m *Memory) updateFunction(userName string, function *Function) error {
+	previous := m.latestFunction(userName, function.Name)
+	if previous == nil {
+		return sql.ErrNoRows
+	}
+
+	if previous.DefaultVersion == 0 && m.version(userName, function.Name, 0) == nil {
+		m.versions = append(m.versions, &Version{
+			ID:           m.nextID("versions"),
+			UserName:     userName,
+			FunctionName: function.Name,
+			ImageDigest:  previous.ImageDigest,
+			Content:      previous.Content,
+			Created:      previous.Created,
+		})
+	}
+
+	function.ID = previous.ID
+	function.UserID = previous.UserID
+	function.Created = previous.Created
+	function.Updated = time.Now()
+	f := *function
+	*previous = f
+	return nil
+}
+
+func (m *Memory) PutExecution(userName, funcName string, execution *FunctionExecution) (int64, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	function := m.latestFunction(userName, funcName)
+	if function == nil {
+		return -1, -1, sql.ErrNoRows
+	}
+	for _, e := range m.executions {
+		if e.UUID == execution.UUID {
+			return -1, -1, fmt.Errorf("Execution %s already exists", execution.UUID)
+		}
+	}
+
+	execution.ID = m.nextID("executions")
+	execution.FunctionID = function.ID
+
+	e := *execution
+	e.UserName = userName
+	e.FunctionName = funcName
+	e.ExitCode = 0
+	e.Duration = 0
+	e.Log = ""
+	e.Result = ""
+	e.Finished = time.Time{}
+	m.executions = append(m.executions, &e)
+	return e.ID, 1, nil
+}
+
+func (m *Memory) UpdateExecution(execution *FunctionExecution) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, e := range m.executions {
+		if e.UUID == execution.UUID {
+			e.Status = execution.Status
+			e.ExitCode = execution.ExitCode
+			e.Duration = execution.Duration / time.Millisecond * time.Millisecond
+			e.Log = execution.Log
+			e.Result = execution.Result
+			e.Finished = execution.Finished
+			return nil
+		}
+	}
+	return fmt.Errorf("Execution %s not found", execution.UUID)
+}
+
+func (m *Memory) GetExecution(uuid string) (*FunctionExecution, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, e := range m.executions {
+		if e.UUID == uuid {
+			execution := *e
+			return &execution, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *Memory) ListExecutionsOfFunction(userName, funcName string, filter *ExecutionFilter) ([]*FunctionExecution, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	executions := make([]*FunctionExecution, 0, 5)
+	for i := len(m.executions) - 1; i >= 0; i-- {
+		e := m.executions[i]
+		if e.UserName != userName || e.FunctionName != funcName {
+			continue
+		}
+		if filter != nil {
+			if filter.Status != "" && e.Status != filter.Status {
+				continue
+			}
+			if !filter.Since.IsZero() && e.Timestamp.Before(filter.Since) {
+				continue
+			}
+			if !filter.Until.IsZero() && !e.Timestamp.Before(filter.Until) {
+				continue
+			}
+			if filter.Limit > 0 && len(executions) == filter.Limit {
+				break
+			}
+		}
+		execution := *e
+		executions = append(executions, &execution)
+	}
+	return executions, nil
+}
+
+func (m *Memory) PutBuild(userName string, build *Build) (int64, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.userByName(userName) == nil {
+		return -1, -1, sql.ErrNoRows
+	}
+
+	if build.Created.IsZero() {
+		build.Created = time.Now()
+	}
+	build.ID = m.nextID("builds")
+	build.UserName = userName
+
+	b := *build
+	b.Version = 0
+	b.Finished = time.Time{}
+	m.builds = append(m.builds, &b)
+	return b.ID, 1, nil
+}
+
+func (m *Memory) UpdateBuild(build *Build) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, b := range m.builds {
+		if b.ID == build.ID {
+			b.Status = build.Status
+			b.Log = build.Log
+			b.Error = build.Error
+			b.Version = build.Version
+			b.Finished = build.Finished
+			return nil
+		}
+	}
+	return fmt.Errorf("Build %d not found", build.ID)
+}
+
+func (m *Memory) GetBuild(id int64) (*Build, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, b := range m.builds {
+		if b.ID == id {
+			build := *b
+			return &build, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *Memory) ListUnfinishedBuilds() ([]*Build, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	builds := make([]*Build, 0, 5)
+	for _, b := range m.builds {
+		switch b.Status {
+		case BuildQueued, BuildBuilding, BuildPushing:
+			build := *b
+			builds = append(builds, &build)
+		}
+	}
+	return builds, nil
+}
+
+func (m *Memory) ListBuilds(userName, funcName string) ([]*Build, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	builds := make([]*Build, 0, 5)
+	for i := len(m.builds) - 1; i >= 0; i-- {
+		b := m.builds[i]
+		if b.UserName == userName && b.FunctionName == funcName {
+			build := *b
+			builds = append(builds, &build)
+		}
+	}
+	return builds, nil
+}
+
+func (m *Memory) PutVersion(userName string, version *Version) (int64, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.putVersion(userName, version)
+}
+
+func (m *Memory) putVersion(userName string, version *Version) (int64, int64, error) {
+	if m.userByName(userName) == nil {
+		return -1, -1, sql.ErrNoRows
+	}
+
+	latest := 0
+	for _, v := range m.versions {
+		if v.UserName == userName && v.FunctionName == version.FunctionName && v.Version > latest {
+			latest = v.Version
+		}
+	}
+
+	if version.Created.IsZero() {
+		version.Created = time.Now()
+	}
+	version.ID = m.nextID("versions")
+	version.UserName = userName
+	version.Version = latest + 1
+
+	v := *version
+	m.versions = append(m.versions, &v)
+	return v.ID, 1, nil
+}
+
+func (m *Memory) PutFunctionVersion(userName string, function *Function, version *Version, update bool) (int64, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	exists := m.latestFunction(userName, function.Name) != nil
+	if exists && !update {
+		return 0, 0, nil
+	}
+
+	lastId, rowCnt, err := m.putVersion(userName, version)
+	if err != nil {
+		return -1, -1, err
+	}
+	function.DefaultVersion = version.Version
+
+	if exists {
+		err = m.updateFunction(userName, function)
+	} else {
+		_, _, err = m.putFunction(userName, function)
+	}
+	if err != nil {
+		return -1, -1, err
+	}
+	return lastId, rowCnt, nil
+}
+
+func (m *Memory) version(userName, funcName string, version int) *Version {
+	for _, v := range m.versions {
+		if v.UserName == userName && v.FunctionName == funcName && v.Version == version {
+			return v
+		}
+	}
+	return nil
+}
+
+func (m *Memory) GetVersion(userName, funcName string, version int) (*Version, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	v := m.version(userName, funcName, version)
+	if v == nil {
+		return nil, sql.ErrNoRows
+	}
+	found := *v
+	return &found, nil
+}
+
+func (m *Memory) ListVersions(userName, funcName string) ([]*Version, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	versions := make([]*Version, 0, 5)
+	for _, v := range m.versions {
+		if v.UserName == userName && v.FunctionName == funcName {
+			version := *v
+			versions = append(versions, &version)
+		}
+	}
+	sort.Sort(sort.Reverse(byVersion(versions)))
+	return versions, nil
+}
+
+func (m *Memory) SetDefaultVersion(userName, funcName string, version int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	function := m.latestFunction(userName, funcName)
+	if function == nil {
+		return sql.ErrNoRows
+	}
+	v := m.version(userName, funcName, version)
+	if v == nil {
+		return sql.ErrNoRows
+	}
+	function.DefaultVersion = version
+	function.Content = v.Content
+	function.ImageDigest = v.ImageDigest
+	function.Updated = time.Now()
+	return nil
+}
+
+func (m *Memory) DeleteFunction(userName, funcName string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user := m.userByName(userName)
+	if user == nil {
+		return 0, nil
+	}
+
+	var deleted int64
+	functions := m.functions[:0]
+	for _, function := range m.functions {
+		if function.UserID == user.ID && function.Name == funcName {
+			deleted++
+			continue
+		}
+		functions = append(functions, function)
+	}
+	m.functions = functions
+
+	executions := m.executions[:0]
+	for _, e := range m.executions {
+		if e.UserName != userName || e.FunctionName != funcName {
+			executions = append(executions, e)
+		}
+	}
+	m.executions = executions
+
+	versions := m.versions[:0]
+	for _, v := range m.versions {
+		if v.UserName != userName || v.FunctionName != funcName {
+			versions = append(versions, v)
+		}
+	}
+	m.versions = versions
+
+	builds := m.builds[:0]
+	for _, b := range m.builds {
+		if b.UserName != userName || b.FunctionName != funcName {
+			builds = append(builds, b)
+		}
+	}
+	m.builds = builds
+
+	return deleted, nil
+}
+
+type byVersion []*Version
+
+func (v byVersion) Len() int           { return len(v) }
+func (v byVersion) Less(i, j int) bool { return v[i].Version < v[j].Version }
+func (v byVersion) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+
+func (m *Memory) groupByName(groupName string) *Group {
+	for _, group := range m.groups {
+		if group.Name == groupName {
+			return group
+		}
+	}
+	return nil
+}
+
+func (m *Memory) member(groupID, userID int64) *memoryMember {
+	for _, member := range m.members {
+		if member.groupID == groupID && member.userID == userID {
+			return member
+		}
+	}
+	return nil
+}
+
+// putGroup puts a group and its owner account unless they exist.
+func (m *Memory) putGroup(groupName string) (*Group, bool) {
+	if group := m.groupByName(groupName); group != nil {
+		return group, false
+	}
+
+	owner := GroupOwner(groupName)
+	if m.userByName(owner) == nil {
+		m.users = append(m.users, &User{ID: m.nextID("users"), Name: owner, Created: time.Now()})
+	}
+	group := &Group{ID: m.nextID("groups"), Name: groupName, Created: time.Now()}
+	m.groups = append(m.groups, group)
+	return group, true
+}
+
+func (m *Memory) PutGroupIfNotExisted(groupName string) (int64, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	group, created := m.putGroup(groupName)
+	if !created {
+		return 0, 0, nil
+	}
+	return group.ID, 1, nil
+}
+
+func (m *Memory) GetGroup(groupName string) (*Group, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	group := m.groupByName(groupName)
+	if group == nil {
+		return nil, sql.ErrNoRows
+	}
+
+	found := *group
+	found.Users = make([]User, 0, 5)
+	for _, member := range m.members {
+		if member.groupID == group.ID {
+			found.Users = append(found.Users, *m.userByID(member.userID))
+		}
+	}
+	sort.Sort(byUserName(found.Users))
+	return &found, nil
+}
+
+func (m *Memory) ListGroups(userName string) ([]*Group, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	groups := make([]*Group, 0, 5)
+	user := m.userByName(userName)
+	for _, group := range m.groups {
+		if userName == "" || (user != nil && m.member(group.ID, user.ID) != nil) {
+			found := *group
+			groups = append(groups, &found)
+		}
+	}
+	sort.Sort(byGroupName(groups))
+	return groups, nil
+}
+
+func (m *Memory) IsGroupMember(groupName, userName string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	group := m.groupByName(groupName)
+	if group == nil {
+		return false, sql.ErrNoRows
+	}
+	user := m.userByName(userName)
+	return user != nil && m.member(group.ID, user.ID) != nil, nil
+}
+
+func (m *Memory) RemoveGroupMember(groupName, userName string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	group, user := m.groupByName(groupName), m.userByName(userName)
+	if group == nil || user == nil {
+		return 0, nil
+	}
+
+	var removed int64
+	members := m.members[:0]
+	for _, member := range m.members {
+		if member.groupID == group.ID && member.userID == user.ID {
+			removed++
+			continue
+		}
+		members = append(members, member)
+	}
+	m.members = members
+	return removed, nil
+}
+
+func (m *Memory) SyncUserGroups(userName string, groupNames []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user := m.userByName(userName)
+	if user == nil {
+		return sql.ErrNoRows
+	}
+
+	synced := make(map[int64]bool)
+	for _, groupName := range groupNames {
+		group, _ := m.putGroup(groupName)
+		if m.member(group.ID, user.ID) == nil {
+			m.members = append(m.members, &memoryMember{groupID: group.ID, userID: user.ID, synced: true})
+		}
+		synced[group.ID] = true
+	}
+
+	members := m.members[:0]
+	for _, member := range m.members {
+		if member.userID == user.ID && member.synced && !synced[member.groupID] {
+			continue
+		}
+		members = append(members, member)
+	}
+	m.members = members
+	return nil
+}
+
+type byUserName []User
+
+func (u byUserName) Len() int           { return len(u) }
+func (u byUserName) Less(i, j int) bool { return u[i].Name < u[j].Name }
+func (u byUserName) Swap(i, j int)      { u[i], u[j] = u[j], u[i] }
+
+type byGroupName []*Group
+
+func (g byGroupName) Len() int           { return len(g) }
+func (g byGroupName) Less(i, j int) bool { return g[i].Name < g[j].Name }
+func (g byGroupName) Swap(i, j int)      { g[i], g[j] = g[j], g[i] }
@@ -0,0 +1,187 @@
+package dal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Migration is a change of the schema of a backend. Migrations are
+// numbered from 1, in the order they apply.
+type Migration struct {
+	Version     int
+	Description string
+
+	// Statements applying and reverting the migration. Tables are
+	// written by their default name in braces ({users}, {functions},
+	// {executions}, {builds}, {versions}, {groups} and
+	// {group_members}), so the configured names are used.
+	Up   []string
+	Down []string
+
+	// Columns, indexes and tables the schema already has are skipped
+	// rather than failing the migration. Databases created before
+	// migrations have some of what the first migrations add.
+	IgnoreExisting bool
+}
+
+// MigrationStep is a migration applied or reverted.
+type MigrationStep struct {
+	*Migration
+	Revert bool
+}
+
+func (s MigrationStep) String() string {
+	direction := "up"
+	if s.Revert {
+		direction = "down"
+	}
+	return fmt.Sprintf("%s %d: %s", direction, s.Version, s.Description)
+}
+
+// Migrator is implemented by the backends having a schema.
+type Migrator interface {
+	// Version of the schema, 0 when no migration is applied
+	SchemaVersion() (int, error)
+
+	// Version of the last migration of the backend
+	LatestVersion() int
+
+	// List the steps bringing the schema to version `target`, in the
+	// order Migrate would run them, without running them
+	PlanMigration(target int) ([]MigrationStep, error)
+
+	// Bring the schema to version `target`, applying or reverting
+	// migrations one transaction each. It returns the steps run, up to
+	// the failed one if any.
+	Migrate(target int) ([]MigrationStep, error)
+}
+
+func (dal *sqlDAL) LatestVersion() int {
+	return len(dal.migrations)
+}
+
+// createSchemaVersionTable creates the table recording which migrations
+// are applied, one row each.
+func (dal *sqlDAL) createSchemaVersionTable() error {
+	_, err := dal.Exec(fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		version INT NOT NULL,
+		description VARCHAR(255) NOT NULL,
+		applied TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (version)
+	)`, dal.SchemaVersionTable))
+	return err
+}
+
+func (dal *sqlDAL) SchemaVersion() (int, error) {
+	if err := dal.createSchemaVersionTable(); err != nil {
+		return -1, err
+	}
+
+	var version int
+	err := dal.QueryRow(fmt.Sprintf("SELECT COALESCE(MAX(version), 0) FROM %s",
+		dal.SchemaVersionTable)).Scan(&version)
+	if err != nil {
+		return -1, err
+	}
+	return version, nil
+}
+
+func (dal *sqlDAL) PlanMigration(target int) ([]MigrationStep, error) {
+	current, err := dal.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	latest := dal.LatestVersion()
+	if current > latest {
+		return nil, fmt.Errorf("Schema version %d is newer than the latest migration %d", current, latest)
+	}
+	if target < 0 || target > latest {
+		return nil, fmt.Errorf("No schema version %d, the latest is %d", target, latest)
+	}
+
+	steps := make([]MigrationStep, 0, 5)
+	for v := current + 1; v <= target; v++ {
+		steps = append(steps, MigrationStep{&dal.migrations[v-1], false})
+	}
+	for v := current; v > target; v-- {
+		steps = append(steps, MigrationStep{&dal.migrations[v-1], true})
+	}
+	return steps, nil
+}
+
+func (dal *sqlDAL) Migrate(target int) ([]MigrationStep, error) {
+	steps, err := dal.PlanMigration(target)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, step := range steps {
+		if err := dal.runMigrationStep(step); err != nil {
+			return steps[:i], fmt.Errorf("Migration %s failed: %v", step, err)
+		}
+	}
+	return steps, nil
+}
+
+// runMigrationStep runs a migration step and records it in a
+// transaction. Backends committing schema changes implicitly (MySQL)
+// cannot undo the statements run before a failed one.
+func (dal *sqlDAL) runMigrationStep(step MigrationStep) error {
+	tx, err := dal.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := step.Up
+	if step.Revert {
+		statements = step.Down
+	}
+	for _, statement := range statements {
+		_, err := tx.Exec(dal.tables.Replace(statement))
+		if err != nil && !(step.IgnoreExisting && !step.Revert && dal.isExisting(err)) {
+			return err
+		}
+	}
+
+	if step.Revert {
+		_, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE version = ?", dal.SchemaVersionTable),
+			step.Version)
+	} else {
+		_, err = tx.Exec(fmt.Sprintf("INSERT INTO %s (version, description) VALUES (?, ?)",
+			dal.SchemaVersionTable), step.Version, step.Description)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// tableNames replaces the table names in braces of migrations.
+func tableNames(config *DalConfig) *strings.Replacer {
+	return strings.NewReplacer(
+		"{users}", config.UsersTable,
+		"{functions}", config.FunctionsTable,
+		"{executions}", config.ExecutionsTable,
+		"{builds}", config.BuildsTable,
+		"{versions}", config.VersionsTable,
+		"{groups}", config.GroupsTable,
+		"{group_members}", config.GroupMembersTable,
+	)
+}
+
+// CheckSchema fails unless the schema of a backend is at the latest
+// version.
+func CheckSchema(m Migrator) error {
+	current, err := m.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if current != m.LatestVersion() {
+		return fmt.Errorf("Schema version is %d, want %d: run the migrations", current, m.LatestVersion())
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package dal
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// MySQL is the DAL on a MySQL server.
+type MySQL struct {
+	*sqlDAL
+}
+
+func init() {
+	Register("mysql", func(config *DalConfig) (DAL, error) {
+		dal, err := NewMySQL(config)
+		if err != nil {
+			return nil, err
+		}
+		return dal, nil
+	})
+}
+
+// Migrations of the MySQL schema. The first ones adopt the databases
+// created before migrations, whatever columns they have.
+var mysqlMigrations = []Migration{
+	{
+		Version:     1,
+		Description: "Create users, functions and executions",
+		Up: []string{`
+		CREATE TABLE IF NOT EXISTS {users} (
+			u_id INT NOT NULL AUTO_INCREMENT,
+			name VARCHAR(255) NOT NULL,
+			created TIMESTAMP,
+			PRIMARY KEY (u_id),
+			UNIQUE(name)
+		)`, `
+		CREATE TABLE IF NOT EXISTS {functions} (
+			f_id INT NOT NULL AUTO_INCREMENT,
+			u_id INT NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			content TEXT,
+			created TIMESTAMP,
+			updated TIMESTAMP,
+			PRIMARY KEY (f_id),
+			FOREIGN KEY (u_id) REFERENCES {users}(u_id)
+		)`, `
+		CREATE TABLE IF NOT EXISTS {executions} (
+			e_id INT NOT NULL AUTO_INCREMENT,
+			f_id INT NOT NULL,
+			uuid VARCHAR(255) NOT NULL,
+			log TEXT,
+			created TIMESTAMP,
+			PRIMARY KEY (e_id),
+			FOREIGN KEY (f_id) REFERENCES {functions}(f_id)
+		)`},
+		Down: []string{
+			"DROP TABLE {executions}",
+			"DROP TABLE {functions}",
+			"DROP TABLE {users}",
+		},
+		IgnoreExisting: true,
+	},
+	{
+		// Executions tables created before executions were tracked
+		// only have the columns of the first migration.
+		Version:     2,
+		Description: "Record the jobs, status and results of executions",
+		Up: []string{
+			"ALTER TABLE {executions} ADD COLUMN job_name VARCHAR(255) NOT NULL DEFAULT ''",
+			"ALTER TABLE {executions} ADD COLUMN namespace VARCHAR(255) NOT NULL DEFAULT ''",
+			"ALTER TABLE {executions} ADD COLUMN params TEXT",
+			"ALTER TABLE {executions} ADD COLUMN status VARCHAR(32) NOT NULL DEFAULT ''",
+			"ALTER TABLE {executions} ADD COLUMN exit_code INT",
+			"ALTER TABLE {executions} ADD COLUMN duration BIGINT",
+			"ALTER TABLE {executions} ADD COLUMN result TEXT",
+			"ALTER TABLE {executions} ADD COLUMN finished TIMESTAMP NULL",
+			"ALTER TABLE {executions} ADD UNIQUE uuid (uuid)",
+		},
+		Down: []string{`
+		ALTER TABLE {executions}
+			DROP INDEX uuid,
+			DROP COLUMN job_name,
+			DROP COLUMN namespace,
+			DROP COLUMN params,
+			DROP COLUMN status,
+			DROP COLUMN exit_code,
+			DROP COLUMN duration,
+			DROP COLUMN result,
+			DROP COLUMN finished`,
+		},
+		IgnoreExisting: true,
+	},
+	{
+		Version:     3,
+		Description: "Add the resource limits and image digest of functions",
+		Up: []string{
+			"ALTER TABLE {functions} ADD COLUMN cpu_request VARCHAR(32) NOT NULL DEFAULT ''",
+			"ALTER TABLE {functions} ADD COLUMN cpu_limit VARCHAR(32) NOT NULL DEFAULT ''",
+			"ALTER TABLE {functions} ADD COLUMN memory_request VARCHAR(32) NOT NULL DEFAULT ''",
+			"ALTER TABLE {functions} ADD COLUMN memory_limit VARCHAR(32) NOT NULL DEFAULT ''",
+			"ALTER TABLE {functions} ADD COLUMN max_runtime INT NOT NULL DEFAULT 0",
+			"ALTER TABLE {functions} ADD COLUMN image_digest VARCHAR(255) NOT NULL DEFAULT ''",
+		},
+		Down: []string{`
+		ALTER TABLE {functions}
+			DROP COLUMN cpu_request,
+			DROP COLUMN cpu_limit,
+			DROP COLUMN memory_request,
+			DROP COLUMN memory_limit,
+			DROP COLUMN max_runtime,
+			DROP COLUMN image_digest`,
+		},
+		IgnoreExisting: true,
+	},
+	{
+		Version:     4,
+		Description: "Create builds",
+		Up: []string{`
+		CREATE TABLE IF NOT EXISTS {builds} (
+			b_id INT NOT NULL AUTO_INCREMENT,
+			u_id INT NOT NULL,
+			function_name VARCHAR(255) NOT NULL,
+			status VARCHAR(32) NOT NULL,
+			spec TEXT,
+			log MEDIUMTEXT,
+			error TEXT,
+			version INT NOT NULL DEFAULT 0,
+			created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			finished TIMESTAMP NULL,
+			PRIMARY KEY (b_id),
+			INDEX (u_id, function_name),
+			INDEX (status),
+			FOREIGN KEY (u_id) REFERENCES {users}(u_id)
+		)`},
+		Down: []string{
+			"DROP TABLE {builds}",
+		},
+		IgnoreExisting: true,
+	},
+	{
+		Version:     5,
+		Description: "Create versions and add the version of functions and executions",
+		Up: []string{`
+		CREATE TABLE IF NOT EXISTS {versions} (
+			ver_id INT NOT NULL AUTO_INCREMENT,
+			u_id INT NOT NULL,
+			function_name VARCHAR(255) NOT NULL,
+			version INT NOT NULL,
+			code_hash VARCHAR(64) NOT NULL,
+			image VARCHAR(512) NOT NULL,
+			image_digest VARCHAR(255) NOT NULL DEFAULT '',
+			content TEXT,
+			created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (ver_id),
+			UNIQUE (u_id, function_name, version),
+			FOREIGN KEY (u_id) REFERENCES {users}(u_id)
+		)`,
+			"ALTER TABLE {functions} ADD COLUMN default_version INT NOT NULL DEFAULT 0",
+			"ALTER TABLE {executions} ADD COLUMN version INT NOT NULL DEFAULT 0",
+		},
+		Down: []string{
+			"ALTER TABLE {executions} DROP COLUMN version",
+			"ALTER TABLE {functions} DROP COLUMN default_version",
+			"DROP TABLE {versions}",
+		},
+		IgnoreExisting: true,
+	},
+	{
+		Version:     6,
+		Description: "Make function names unique per user",
+		Up: []string{
+			// Executions of duplicates go to the latest function of
+			// the name, the one calls ran
+			`UPDATE {executions} SET f_id = (
+				SELECT MAX(l.f_id) FROM {functions} f
+					JOIN {functions} l ON f.u_id = l.u_id AND f.name = l.name
+				WHERE f.f_id = {executions}.f_id)`,
+			`DELETE FROM {functions} WHERE f_id NOT IN (
+				SELECT f_id FROM (
+					SELECT MAX(f_id) AS f_id FROM {functions} GROUP BY u_id, name) latest)`,
+			"ALTER TABLE {functions} ADD UNIQUE user_function (u_id, name)",
+		},
+		Down: []string{
+			// The unique index may have replaced the index of the
+			// foreign key
+			"ALTER TABLE {functions} ADD INDEX user_id (u_id), DROP INDEX user_function",
+		},
+	},
+	{
+		Version:     7,
+		Description: "Create groups and group members",
+		Up: []string{`
+		CREATE TABLE {groups} (
+			g_id INT NOT NULL AUTO_INCREMENT,
+			name VARCHAR(255) NOT NULL,
+			u_id INT NOT NULL,
+			created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (g_id),
+			UNIQUE (name),
+			FOREIGN KEY (u_id) REFERENCES {users}(u_id)
+		)`, `
+		CREATE TABLE {group_members} (
+			g_id INT NOT NULL,
+			u_id INT NOT NULL,
+			synced BOOLEAN NOT NULL DEFAULT FALSE,
+			created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (g_id, u_id),
+			FOREIGN KEY (g_id) REFERENCES {groups}(g_id),
+			FOREIGN KEY (u_id) REFERENCES {users}(u_id)
+		)`},
+		Down: []string{
+			"DROP TABLE {group_members}",
+			"DROP TABLE {groups}",
+		},
+	},
+}
+
+func (c *DalConfig) getDataSourceName(dbName string) string {
+	port := c.DBPort
+	if port <= 0 {
+		port = 3306
+	}
+	// With clientFoundRows, updates count the rows they match rather
+	// than those they change, like the other backends, so updating a
+	// row with the values it has is not taken for a missing row
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true&clientFoundRows=true",
+		c.Username, c.Password, c.DBHost, port, dbName)
+}
+
+// NewMySQL connects to the database of a MySQL server, and creates the
+// database if needed. Its tables are created by the migrations.
+func NewMySQL(config *DalConfig) (*MySQL, error) {
+	config = config.withDefaults()
+
+	server, err := sql.Open("mysql", config.getDataSourceName(""))
+	if err != nil {
+		return nil, err
+	}
+	defer server.Close()
+
+	_, err = server.Exec(fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", config.DBName))
+	if err != nil {
+		return nil, err
+	}
+
+	// The database is in the data source, so that every connection of
+	// the pool uses it
+	db, err := sql.Open("mysql", config.getDataSourceName(config.DBName))
+	if err != nil {
+		return nil, err
+	}
+
+	return &MySQL{newSQLDAL(db, dialect{
+		insertIgnore: "INSERT IGNORE",
+		forUpdate:    " FOR UPDATE",
+		isExisting:   isMySQLExisting,
+	}, mysqlMigrations, config)}, nil
+}
+
+// isMySQLExisting tells whether an error is a duplicate column or key
+// name.
+func isMySQLExisting(err error) bool {
+	e, ok := err.(*mysql.MySQLError)
+	return ok && (e.Number == 1060 || e.Number == 1061)
+}
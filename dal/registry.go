@@ -0,0 +1,64 @@
+package dal
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Used when the config leaves it unset
+var DefaultDriver = "mysql"
+
+// Opener opens the DAL of a backend.
+type Opener func(config *DalConfig) (DAL, error)
+
+var (
+	backendsMu sync.Mutex
+	backends   = make(map[string]Opener)
+)
+
+// Register makes a backend available under the name `driver`. It is
+// called from the init function of the backend, and panics if the name
+// is taken.
+func Register(driver string, open Opener) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	if open == nil {
+		panic("dal: Register opener is nil")
+	}
+	if _, taken := backends[driver]; taken {
+		panic("dal: Register called twice for driver " + driver)
+	}
+	backends[driver] = open
+}
+
+// Drivers lists the names of the registered backends, sorted.
+func Drivers() []string {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	drivers := make([]string, 0, len(backends))
+	for driver := range backends {
+		drivers = append(drivers, driver)
+	}
+	sort.Strings(drivers)
+	return drivers
+}
+
+// Open opens the DAL of the backend named in config.
+func Open(config *DalConfig) (DAL, error) {
+	driver := config.Driver
+	if driver == "" {
+		driver = DefaultDriver
+	}
+
+	backendsMu.Lock()
+	open, ok := backends[driver]
+	backendsMu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Unknown DAL driver %q (registered: %v)", driver, Drivers())
+	}
+	return open(config)
+}
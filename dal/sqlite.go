@@ -0,0 +1,179 @@
+package dal
+
+import (
+	"database/sql"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLite is the DAL on an embedded SQLite database, for single node
+// installs and development. It needs no database server.
+type SQLite struct {
+	*sqlDAL
+}
+
+func init() {
+	Register("sqlite3", func(config *DalConfig) (DAL, error) {
+		dal, err := NewSQLite(config)
+		if err != nil {
+			return nil, err
+		}
+		return dal, nil
+	})
+}
+
+// Migrations of the SQLite schema
+var sqliteMigrations = []Migration{
+	{
+		Version:     1,
+		Description: "Create users, functions, executions, builds and versions",
+		Up: []string{`
+		CREATE TABLE {users} (
+			u_id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name VARCHAR(255) NOT NULL UNIQUE,
+			created TIMESTAMP
+		)`, `
+		CREATE TABLE {functions} (
+			f_id INTEGER PRIMARY KEY AUTOINCREMENT,
+			u_id INTEGER NOT NULL REFERENCES {users}(u_id),
+			name VARCHAR(255) NOT NULL,
+			content TEXT,
+			cpu_request VARCHAR(32) NOT NULL DEFAULT '',
+			cpu_limit VARCHAR(32) NOT NULL DEFAULT '',
+			memory_request VARCHAR(32) NOT NULL DEFAULT '',
+			memory_limit VARCHAR(32) NOT NULL DEFAULT '',
+			max_runtime INTEGER NOT NULL DEFAULT 0,
+			image_digest VARCHAR(255) NOT NULL DEFAULT '',
+			default_version INTEGER NOT NULL DEFAULT 0,
+			created TIMESTAMP,
+			updated TIMESTAMP
+		)`, `
+		CREATE TABLE {executions} (
+			e_id INTEGER PRIMARY KEY AUTOINCREMENT,
+			f_id INTEGER NOT NULL REFERENCES {functions}(f_id),
+			uuid VARCHAR(255) NOT NULL UNIQUE,
+			job_name VARCHAR(255) NOT NULL DEFAULT '',
+			namespace VARCHAR(255) NOT NULL DEFAULT '',
+			params TEXT,
+			status VARCHAR(32) NOT NULL,
+			exit_code INTEGER,
+			duration BIGINT,
+			log TEXT,
+			result TEXT,
+			version INTEGER NOT NULL DEFAULT 0,
+			created TIMESTAMP,
+			finished TIMESTAMP NULL
+		)`, `
+		CREATE TABLE {builds} (
+			b_id INTEGER PRIMARY KEY AUTOINCREMENT,
+			u_id INTEGER NOT NULL REFERENCES {users}(u_id),
+			function_name VARCHAR(255) NOT NULL,
+			status VARCHAR(32) NOT NULL,
+			spec TEXT,
+			log TEXT,
+			error TEXT,
+			version INTEGER NOT NULL DEFAULT 0,
+			created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			finished TIMESTAMP NULL
+		)`,
+			"CREATE INDEX {builds}_function ON {builds} (u_id, function_name)",
+			"CREATE INDEX {builds}_status ON {builds} (status)", `
+		CREATE TABLE {versions} (
+			ver_id INTEGER PRIMARY KEY AUTOINCREMENT,
+			u_id INTEGER NOT NULL REFERENCES {users}(u_id),
+			function_name VARCHAR(255) NOT NULL,
+			version INTEGER NOT NULL,
+			code_hash VARCHAR(64) NOT NULL,
+			image VARCHAR(512) NOT NULL,
+			image_digest VARCHAR(255) NOT NULL DEFAULT '',
+			content TEXT,
+			created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (u_id, function_name, version)
+		)`},
+		Down: []string{
+			"DROP TABLE {versions}",
+			"DROP TABLE {builds}",
+			"DROP TABLE {executions}",
+			"DROP TABLE {functions}",
+			"DROP TABLE {users}",
+		},
+		// Databases created before migrations have all the tables
+		IgnoreExisting: true,
+	},
+	{
+		Version:     2,
+		Description: "Make function names unique per user",
+		Up: []string{
+			// Executions of duplicates go to the latest function of
+			// the name, the one calls ran
+			`UPDATE {executions} SET f_id = (
+				SELECT MAX(l.f_id) FROM {functions} f
+					JOIN {functions} l ON f.u_id = l.u_id AND f.name = l.name
+				WHERE f.f_id = {executions}.f_id)`,
+			`DELETE FROM {functions} WHERE f_id NOT IN (
+				SELECT f_id FROM (
+					SELECT MAX(f_id) AS f_id FROM {functions} GROUP BY u_id, name) latest)`,
+			"CREATE UNIQUE INDEX {functions}_user_name ON {functions} (u_id, name)",
+		},
+		Down: []string{
+			"DROP INDEX {functions}_user_name",
+		},
+	},
+	{
+		Version:     3,
+		Description: "Create groups and group members",
+		Up: []string{`
+		CREATE TABLE {groups} (
+			g_id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name VARCHAR(255) NOT NULL UNIQUE,
+			u_id INTEGER NOT NULL REFERENCES {users}(u_id),
+			created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`, `
+		CREATE TABLE {group_members} (
+			g_id INTEGER NOT NULL REFERENCES {groups}(g_id),
+			u_id INTEGER NOT NULL REFERENCES {users}(u_id),
+			synced BOOLEAN NOT NULL DEFAULT 0,
+			created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (g_id, u_id)
+		)`},
+		Down: []string{
+			"DROP TABLE {group_members}",
+			"DROP TABLE {groups}",
+		},
+	},
+}
+
+// NewSQLite opens the SQLite database in `config.DBPath`, and creates
+// the file if needed. Its tables are created by the migrations.
+func NewSQLite(config *DalConfig) (*SQLite, error) {
+	config = config.withDefaults()
+
+	db, err := sql.Open("sqlite3", config.DBPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite takes one writer at a time, and foreign keys are enabled
+	// per connection: a single connection does both
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec("PRAGMA foreign_keys = ON"); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// Writes are serialized by the single connection, so rows need no
+	// locks
+	return &SQLite{newSQLDAL(db, dialect{
+		insertIgnore: "INSERT OR IGNORE",
+		isExisting:   isSQLiteExisting,
+	}, sqliteMigrations, config)}, nil
+}
+
+// isSQLiteExisting tells whether an error is a duplicate column, or an
+// index or table which already exists.
+func isSQLiteExisting(err error) bool {
+	msg := err.Error()
+	return strings.HasPrefix(msg, "duplicate column name") || strings.HasSuffix(msg, "already exists")
+}
@@ -2,11 +2,16 @@ package dal
 
 import "time"
 
+// Group is a team of users sharing functions. The functions of a group
+// are owned by the account GroupOwner(Name), and any member can use
+// them.
 type Group struct {
 	ID      int64
 	Name    string
 	Created time.Time
-	Users   []User
+
+	// Members, when the group is read with them
+	Users []User
 }
 
 type User struct {
@@ -22,11 +27,114 @@ type Function struct {
 	Content string
 	Created time.Time
 	Updated time.Time
+
+	// Compute resources (kubernetes quantities) and max runtime in
+	// seconds of the function jobs. Empty or 0 means cluster default.
+	CPURequest    string
+	CPULimit      string
+	MemoryRequest string
+	MemoryLimit   string
+	MaxRuntime    int64
+
+	// Digest of the function image in the registry
+	ImageDigest string
+
+	// Version run when no version is asked for. 0 for functions
+	// created before versions, which run their untagged image.
+	DefaultVersion int
+}
+
+// Version is an immutable version of a function: the code it was built
+// from and its image. Versions are numbered from 1 per user and function
+// name. Version 0 is the content of a function created before versions,
+// recorded when the function is updated; it runs the untagged image of
+// the function.
+type Version struct {
+	ID           int64
+	UserName     string
+	FunctionName string
+	Version      int
+
+	// Hash of the sources of the version, the tag of its image
+	CodeHash string
+
+	// Tagged image reference and digest of the pushed image
+	Image       string
+	ImageDigest string
+
+	Content string
+	Created time.Time
 }
 
+// Status of a function execution
+const (
+	ExecutionPending   = "pending"
+	ExecutionRunning   = "running"
+	ExecutionSucceeded = "succeeded"
+	ExecutionFailed    = "failed"
+)
+
 type FunctionExecution struct {
-	ID         int64
-	FunctionID int64
-	Log        string
-	Timestamp  time.Time
+	ID           int64
+	FunctionID   int64
+	UserName     string
+	FunctionName string
+	UUID         string
+	JobName      string
+	Namespace    string
+	Params       string
+	Status       string
+	ExitCode     int
+	Duration     time.Duration
+	Log          string
+	Timestamp    time.Time
+	Finished     time.Time
+
+	// Output of the function handler, as written by the runtime
+	// wrapper: its JSON result or the error it raised
+	Result string
+
+	// Version of the function run, 0 if unversioned
+	Version int
+}
+
+// ExecutionFilter narrows down the executions listed for a function.
+// Zero values are ignored.
+type ExecutionFilter struct {
+	Status string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// Status of a function build
+const (
+	BuildQueued   = "queued"
+	BuildBuilding = "building"
+	BuildPushing  = "pushing"
+	BuildReady    = "ready"
+	BuildFailed   = "failed"
+)
+
+// Build is a build of the image of a function. Builds are kept by user
+// and function name, since a function only exists once it is built.
+type Build struct {
+	ID           int64
+	UserName     string
+	FunctionName string
+	Status       string
+
+	// What the build needs to run, encoded by whoever submitted it.
+	// Unfinished builds are run again from it after a restart.
+	Spec string
+
+	// Output of the image build, and why the build failed
+	Log   string
+	Error string
+
+	// Version of the function the build made, once it is ready
+	Version int
+
+	Created  time.Time
+	Finished time.Time
 }
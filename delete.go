@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/xuant/go-kexec/builder"
+	"github.com/xuant/go-kexec/docker"
+	"github.com/xuant/go-kexec/naming"
+)
+
+// deletion reports what deleting a function removed. Deleting a
+// function which does not exist removes nothing.
+type deletion struct {
+	Function string `json:"function"`
+	Deleted  bool   `json:"deleted"`
+	Jobs     int    `json:"jobs"`
+	Images   int    `json:"images"`
+	Contexts int    `json:"contexts"`
+}
+
+// deleteFunction deletes a function of a user: its jobs, its build jobs
+// if builds run in the cluster, its images in the registry, its build
+// contexts, then its records. The records go last, so a deletion failing
+// half way can be retried.
+func deleteFunction(a *appContext, userName, functionName string) (*deletion, error) {
+	d := &deletion{Function: functionName}
+
+	// Builds finishing meanwhile wait, then find their build gone
+	unlock := a.functionLocks.lock(userName, functionName)
+	defer unlock()
+
+	versions, err := a.dal.ListVersions(userName, functionName)
+	if err != nil {
+		return nil, err
+	}
+	builds, err := a.dal.ListBuilds(userName, functionName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Running executions are cancelled with their jobs
+	if d.Jobs, err = a.k.DeleteFunctionJobs(naming.Label(functionName), naming.Namespace(userName)); err != nil {
+		log.Printf("Failed to delete jobs of function %s: %v", functionName, err)
+		return nil, err
+	}
+	if a.conf.Builder.Backend == builder.BackendKubernetes {
+		namespace := a.conf.Builder.Kubernetes.Namespace
+		cancelled, err := a.k.DeleteBuildJobs(naming.Label(userName), naming.Label(functionName), namespace)
+		if err != nil {
+			log.Printf("Failed to delete build jobs of function %s: %v", functionName, err)
+			return nil, err
+		}
+		d.Jobs += cancelled
+	}
+
+	// Images registered from elsewhere are not ours to delete
+	repository := naming.Image(a.conf.DockerRegistry, userName, functionName)
+	images := []string{repository}
+	seen := make(map[string]bool)
+	for _, version := range versions {
+		image := imageOfVersion(version)
+		if strings.HasPrefix(version.Image, repository+":") && !seen[image] {
+			images = append(images, image)
+			seen[image] = true
+		}
+	}
+	insecure := isInsecureRegistry(a, a.conf.DockerRegistry)
+	for _, image := range images {
+		err := docker.DeleteImage(image, a.registryAuth, insecure, nil)
+		if err == docker.ErrDeleteUnsupported {
+			log.Printf("Image %s left in the registry: %v", image, err)
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to delete image %s: %v", image, err)
+			return nil, err
+		}
+		d.Images++
+	}
+
+	for _, build := range builds {
+		var spec buildSpec
+		if err := json.Unmarshal([]byte(build.Spec), &spec); err != nil || !isBuildContext(spec.ContextDir) {
+			continue
+		}
+		if _, err := os.Stat(spec.ContextDir); err != nil {
+			continue
+		}
+		if err := os.RemoveAll(spec.ContextDir); err != nil {
+			log.Printf("Failed to remove build context %s: %v", spec.ContextDir, err)
+			return nil, err
+		}
+		d.Contexts++
+	}
+
+	deleted, err := a.dal.DeleteFunction(userName, functionName)
+	if err != nil {
+		log.Printf("Failed to delete function %s from DB: %v", functionName, err)
+		return nil, err
+	}
+	d.Deleted = deleted > 0 || len(versions) > 0 || len(builds) > 0
+
+	log.Printf("Deleted function %s of %s: %d jobs, %d images, %d build contexts",
+		functionName, userName, d.Jobs, d.Images, d.Contexts)
+	return d, nil
+}
+
+// functionLocks serializes what must not interleave for a function:
+// recording a version, from a build or a registered image, rolling the
+// function back, and deleting the function.
+type functionLocks struct {
+	sync.Mutex
+	locks map[string]*functionLock
+}
+
+type functionLock struct {
+	sync.Mutex
+	users int
+}
+
+func newFunctionLocks() *functionLocks {
+	return &functionLocks{
+		locks: make(map[string]*functionLock),
+	}
+}
+
+// lock locks a function of a user, and returns the function unlocking
+// it.
+func (l *functionLocks) lock(userName, functionName string) func() {
+	key := userName + "/" + functionName
+
+	l.Lock()
+	fl, ok := l.locks[key]
+	if !ok {
+		fl = &functionLock{}
+		l.locks[key] = fl
+	}
+	fl.users++
+	l.Unlock()
+
+	fl.Lock()
+	return func() {
+		fl.Unlock()
+
+		l.Lock()
+		defer l.Unlock()
+		if fl.users--; fl.users == 0 {
+			delete(l.locks, key)
+		}
+	}
+}
+
+// isInsecureRegistry tells whether a registry is one of the insecure
+// registries of the config.
+func isInsecureRegistry(a *appContext, registry string) bool {
+	for _, insecure := range a.conf.InsecureRegistries {
+		if insecure == registry {
+			return true
+		}
+	}
+	return false
+}
+
+// isBuildContext tells whether a directory is a build context, so only
+// those are ever removed.
+func isBuildContext(dir string) bool {
+	rel, err := filepath.Rel(docker.IBContext, dir)
+	return err == nil && rel != "." && !strings.HasPrefix(rel, "..") && !strings.Contains(rel, string(filepath.Separator))
+}
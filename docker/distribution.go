@@ -0,0 +1,207 @@
+package docker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	// Manifests whose digest is asked for when deleting a tag
+	manifestMediaTypes = []string{
+		"application/vnd.docker.distribution.manifest.v2+json",
+		"application/vnd.docker.distribution.manifest.list.v2+json",
+		"application/vnd.docker.distribution.manifest.v1+prettyjws",
+	}
+
+	// Parameters of a WWW-Authenticate challenge
+	challengeParamRegexp = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+	// Returned by DeleteImage when the registry has deletes disabled
+	ErrDeleteUnsupported = errors.New("Registry does not support deleting images")
+)
+
+// DeleteImage deletes an image from its registry with the registry
+// API. An image given by tag is resolved to its digest first; deleting
+// the manifest of the digest removes all the tags pointing to it.
+// Images which are already gone are not an error.
+//
+// The registry is reached over https. If it does not answer https and
+// `insecure` is set, it is reached over http, as the docker client does
+// for insecure registries.
+//
+// `client` can be nil, in which case http.DefaultClient is used.
+func DeleteImage(image string, auth *RegistryAuth, insecure bool, client *http.Client) error {
+	ref, err := ParseImageReference(image)
+	if err != nil {
+		return err
+	}
+	if ref.Registry == "" {
+		return fmt.Errorf("Cannot delete %s from the default registry", image)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	base, err := registryBase(client, ref.Registry, insecure)
+	if err != nil {
+		return err
+	}
+	name := strings.TrimPrefix(ref.Repository, ref.Registry+"/")
+	manifests := base + "/v2/" + name + "/manifests/"
+
+	digest := ref.Digest
+	if digest == "" {
+		tag := ref.Tag
+		if tag == "" {
+			tag = "latest"
+		}
+
+		resp, err := registryRequest(client, "HEAD", manifests+tag, auth)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			return nil
+		case resp.StatusCode != http.StatusOK:
+			return fmt.Errorf("Failed to resolve %s: %s", image, resp.Status)
+		}
+		if digest = resp.Header.Get("Docker-Content-Digest"); digest == "" {
+			return fmt.Errorf("Registry did not report the digest of %s", image)
+		}
+	}
+
+	resp, err := registryRequest(client, "DELETE", manifests+digest, auth)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusAccepted, http.StatusOK, http.StatusNotFound:
+		return nil
+	case http.StatusMethodNotAllowed:
+		return ErrDeleteUnsupported
+	}
+	return fmt.Errorf("Failed to delete %s@%s: %s", ref.Repository, digest, resp.Status)
+}
+
+// registryBase returns the base URL of the API of a registry, pinging
+// it over https first, then over http if the registry is insecure.
+func registryBase(client *http.Client, registry string, insecure bool) (string, error) {
+	schemes := []string{"https://"}
+	if insecure {
+		schemes = append(schemes, "http://")
+	}
+
+	var err error
+	for _, scheme := range schemes {
+		var resp *http.Response
+		if resp, err = client.Get(scheme + registry + "/v2/"); err == nil {
+			resp.Body.Close()
+			return scheme + registry, nil
+		}
+	}
+	return "", err
+}
+
+// registryRequest sends a request to a registry with the credentials,
+// through the token service of the registry if it asks for one.
+func registryRequest(client *http.Client, method, target string, auth *RegistryAuth) (*http.Response, error) {
+	newRequest := func() (*http.Request, error) {
+		req, err := http.NewRequest(method, target, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, mediaType := range manifestMediaTypes {
+			req.Header.Add("Accept", mediaType)
+		}
+		return req, nil
+	}
+
+	req, err := newRequest()
+	if err != nil {
+		return nil, err
+	}
+	if auth != nil && auth.Username != "" {
+		req.SetBasicAuth(auth.Username, auth.Password)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	if resp.StatusCode != http.StatusUnauthorized || !strings.HasPrefix(challenge, "Bearer ") {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	token, err := fetchToken(client, challenge, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	if req, err = newRequest(); err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return client.Do(req)
+}
+
+// fetchToken gets a token from the token service named in a Bearer
+// challenge.
+func fetchToken(client *http.Client, challenge string, auth *RegistryAuth) (string, error) {
+	params := make(map[string]string)
+	for _, m := range challengeParamRegexp.FindAllStringSubmatch(challenge, -1) {
+		params[m[1]] = m[2]
+	}
+	if params["realm"] == "" {
+		return "", fmt.Errorf("Invalid registry challenge %q", challenge)
+	}
+
+	query := url.Values{}
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	req, err := http.NewRequest("GET", params["realm"]+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if auth != nil && auth.Username != "" {
+		req.SetBasicAuth(auth.Username, auth.Password)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Registry token service answered %s", resp.Status)
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.Token == "" {
+		body.Token = body.AccessToken
+	}
+	if body.Token == "" {
+		return "", errors.New("Registry token service returned no token")
+	}
+	return body.Token, nil
+}
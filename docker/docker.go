@@ -2,10 +2,9 @@ package docker
 
 import (
 	"bytes"
-	"errors"
+	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
@@ -19,14 +18,15 @@ import (
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/fileutils"
+	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/docker/docker/pkg/progress"
 	"github.com/docker/docker/pkg/streamformatter"
+	"github.com/xuant/go-kexec/runtimes"
 )
 
 var (
 	IBContext     = "/tmp/faas-imagebuild-context/"
-	RelDockerfile = "Dockerfile"
-	ExecutionFile = "exec"
+	RelDockerfile = runtimes.RelDockerfile
 )
 
 type Docker struct {
@@ -49,17 +49,13 @@ func (d *Docker) initCli() (*client.Client, error) {
 	return client.NewClient(d.Host, d.Version, d.HttpClient, d.HttpHeaders)
 }
 
-func (d *Docker) BuildFunction(registry, namespace, funcName, templateName, ctxDir string) error {
-	if _, err := os.Stat(filepath.Join(ctxDir, ExecutionFile)); err != nil {
-		log.Printf("Failed build function. Error: Execution file not found.")
-		return errors.New("Execution file not found.")
-	}
-
-	if err := setRuntimeTemplate(templateName, ctxDir); err != nil {
-		log.Printf("Failed to set up runtime template. Error:%s", err)
-		return err
-	}
-
+// BuildImage builds an image from the context directory and tags it
+// with `image`. The context directory must be prepared for the build,
+// ie hold the Dockerfile of the runtime.
+//
+// The output of the build is written to `buildLog`. A build failing in
+// one of its steps returns a *BuildError.
+func (d *Docker) BuildImage(image, ctxDir string, buildLog io.Writer) error {
 	f, err := os.Open(filepath.Join(ctxDir, ".dockerignore"))
 
 	if err != nil && !os.IsNotExist(err) {
@@ -100,7 +96,7 @@ func (d *Docker) BuildFunction(registry, namespace, funcName, templateName, ctxD
 	var body io.Reader = progress.NewProgressReader(buildCtx, progressOutput, 0, "", "Sending build context to Docker daemon")
 
 	opts := types.ImageBuildOptions{
-		Tags:       []string{registry + "/" + namespace + "/" + funcName},
+		Tags:       []string{image},
 		Dockerfile: RelDockerfile,
 		Squash:     true,
 	}
@@ -120,38 +116,53 @@ func (d *Docker) BuildFunction(registry, namespace, funcName, templateName, ctxD
 	}
 	defer resp.Body.Close()
 
-	buf, err := ioutil.ReadAll(resp.Body)
-
-	if err != nil {
-		log.Printf("Failed to read response body. Error: %s", err)
-		return err
-	}
+	return readBuildOutput(resp.Body, buildLog)
+}
 
-	log.Printf("Image build response Body: %s", string(buf))
-	log.Printf("Image build response OSType: %s", resp.OSType)
+// BuildError is the error reported by the daemon when a build fails,
+// eg on a RUN step exiting with a non-zero status.
+type BuildError struct {
+	Message string
+}
 
-	return nil
+func (e *BuildError) Error() string {
+	return "Build failed: " + e.Message
 }
 
-var python27Template = `FROM python:2.7
-ADD . ./
-ENTRYPOINT [ "python", "exec" ]
-`
+// readBuildOutput decodes the JSON messages of a build, writing their
+// text to `buildLog`, until the daemon reports the end of the build or
+// an error.
+func readBuildOutput(r io.Reader, buildLog io.Writer) error {
+	decoder := json.NewDecoder(r)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := decoder.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("Failed to read build output: %v", err)
+		}
 
-// setRuntimeEnv creates the runtime environment for building a docker image.
-//
-// Based on the templateName, this method will create a corresponding Dockerfile
-// in the context directory (i.e. /tmp/faas-imagebuild-context/xxxx). To make the build process fast,
-// runtime template should be proloaded onto the system.
-//
-// Now supporting Python27 only. Other template can be added easi
-func setRuntimeTemplate(templateName, ctxDir string) error {
-	switch templateName {
-	case "python27":
-		ioutil.WriteFile(filepath.Join(ctxDir, RelDockerfile), []byte(python27Template), 0644)
-		return nil
-	default:
-		return errors.New("Runtime template " + templateName + " invalid or not supported yet.")
+		if msg.Error != nil || msg.ErrorMessage != "" {
+			message := msg.ErrorMessage
+			if msg.Error != nil {
+				message = msg.Error.Message
+			}
+			fmt.Fprintf(buildLog, "ERROR: %s\n", message)
+			return &BuildError{message}
+		}
 
+		// Progress bars of base image pulls are left out
+		inProgress := msg.Progress != nil && (msg.Progress.Current > 0 || msg.Progress.Total > 0)
+
+		switch {
+		case msg.Stream != "":
+			io.WriteString(buildLog, msg.Stream)
+		case msg.Status != "" && !inProgress:
+			if msg.ID != "" {
+				fmt.Fprintf(buildLog, "%s: %s\n", msg.ID, msg.Status)
+			} else {
+				fmt.Fprintln(buildLog, msg.Status)
+			}
+		}
 	}
 }
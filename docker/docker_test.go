@@ -1,22 +1,316 @@
 package docker
 
 import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/xuant/go-kexec/runtimes"
 )
 
-func TestBuildFunction(t *testing.T) {
-	config := &DockerConfig{
-		HttpHeaders: map[string]string{"User-Agent": "engine-api-cli-1.0"},
-		Host:        "unix:///var/run/docker.sock",
-		Version:     "v1.22",
-		HttpClient:  nil,
+var testDigest = "sha256:" + strings.Repeat("ab", 32)
+
+func TestBuildImage(t *testing.T) {
+	if _, err := os.Stat("/var/run/docker.sock"); err != nil {
+		t.Skip("No docker daemon")
+	}
+
+	registry, err := runtimes.Load("../runtimes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt, _ := registry.Get("python27")
+
+	ctxDir, err := ioutil.TempDir("", "docker-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(ctxDir)
+
+	code, err := rt.WrapCode("def handler(event, context):\n    return event", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(ctxDir, rt.FileName), []byte(code), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.WriteContext(ctxDir, "hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDocker(map[string]string{"User-Agent": "engine-api-cli-1.0"}, "unix:///var/run/docker.sock", "v1.22", nil)
+	var buildLog bytes.Buffer
+	if err := d.BuildImage("go-kexec-test/hello", ctxDir, &buildLog); err != nil {
+		t.Fatalf("%v\n%s", err, buildLog.String())
+	}
+}
+
+func TestReadBuildOutput(t *testing.T) {
+	output := strings.Join([]string{
+		`{"stream":"Step 1/3 : FROM python:2.7\n"}`,
+		`{"status":"Pulling fs layer","progressDetail":{},"id":"5040bd298390"}`,
+		`{"status":"Downloading","progressDetail":{"current":1024,"total":4096},"progress":"[==>   ]","id":"5040bd298390"}`,
+		`{"stream":"Step 2/3 : RUN pip install -r requirements.txt\n"}`,
+		`{"stream":"Could not find a version that satisfies the requirement nope\n"}`,
+		`{"errorDetail":{"code":1,"message":"The command '/bin/sh -c pip install -r requirements.txt' returned a non-zero code: 1"},"error":"The command '/bin/sh -c pip install -r requirements.txt' returned a non-zero code: 1"}`,
+	}, "\n")
+
+	var buildLog bytes.Buffer
+	err := readBuildOutput(strings.NewReader(output), &buildLog)
+	if _, ok := err.(*BuildError); !ok || !strings.Contains(err.Error(), "non-zero code: 1") {
+		t.Errorf("Got error %v", err)
+	}
+
+	want := "Step 1/3 : FROM python:2.7\n" +
+		"5040bd298390: Pulling fs layer\n" +
+		"Step 2/3 : RUN pip install -r requirements.txt\n" +
+		"Could not find a version that satisfies the requirement nope\n" +
+		"ERROR: The command '/bin/sh -c pip install -r requirements.txt' returned a non-zero code: 1\n"
+	if buildLog.String() != want {
+		t.Errorf("Build log:\n%s\nwant:\n%s", buildLog.String(), want)
+	}
+
+	buildLog.Reset()
+	if err := readBuildOutput(strings.NewReader(`{"stream":"Successfully built 0123456789ab\n"}`), &buildLog); err != nil {
+		t.Errorf("Got error %v for a successful build", err)
 	}
+}
+
+// daemon stands in for the push endpoint of a docker daemon. It answers
+// with `output`, a stream of JSON messages, and records the credentials
+// it was given.
+func daemon(t *testing.T, output []string, auth *types.AuthConfig) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || !strings.HasSuffix(r.URL.Path, "/images/localhost:5000/alice/hello/push") {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+
+		buf, err := base64.URLEncoding.DecodeString(r.Header.Get("X-Registry-Auth"))
+		if err != nil {
+			t.Errorf("Invalid X-Registry-Auth: %v", err)
+		}
+		if err := json.Unmarshal(buf, auth); err != nil {
+			t.Errorf("Invalid X-Registry-Auth: %v", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		for _, line := range output {
+			fmt.Fprintln(w, line)
+		}
+	}))
+}
 
-	d := NewDocker(config)
+func TestPushFunction(t *testing.T) {
+	var auth types.AuthConfig
+	server := daemon(t, []string{
+		`{"status":"The push refers to a repository [localhost:5000/alice/hello]"}`,
+		`{"status":"Preparing","progressDetail":{},"id":"5f70bf18a086"}`,
+		`{"status":"Pushing","progressDetail":{"current":512,"total":1024},"id":"5f70bf18a086"}`,
+		`{"status":"Pushed","progressDetail":{},"id":"5f70bf18a086"}`,
+		`{"status":"latest: digest: ` + testDigest + ` size: 528"}`,
+		`{"progressDetail":{},"aux":{"Tag":"latest","Digest":"` + testDigest + `","Size":528}}`,
+	}, &auth)
+	defer server.Close()
 
-	d.BuildFunction("xuant", "faas:v1", "python27")
+	d := NewDocker(nil, "tcp://"+server.Listener.Addr().String(), "v1.22", nil)
+	digest, err := d.PushFunction("localhost:5000/alice/hello", &RegistryAuth{Username: "alice", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != testDigest {
+		t.Errorf("Digest %s, want %s", digest, testDigest)
+	}
+	if auth.Username != "alice" || auth.Password != "secret" || auth.ServerAddress != "localhost:5000" {
+		t.Errorf("Credentials %+v", auth)
+	}
 }
 
-func TestRegisterFunction(t *testing.T) {
-	RegisterFunction("xuant", "faas:v1")
+func TestPushFunctionError(t *testing.T) {
+	var auth types.AuthConfig
+	server := daemon(t, []string{
+		`{"status":"The push refers to a repository [localhost:5000/alice/hello]"}`,
+		`{"errorDetail":{"message":"unauthorized: authentication required"},"error":"unauthorized: authentication required"}`,
+	}, &auth)
+	defer server.Close()
+
+	d := NewDocker(nil, "tcp://"+server.Listener.Addr().String(), "v1.22", nil)
+	_, err := d.PushFunction("localhost:5000/alice/hello", nil)
+	if err == nil || !strings.Contains(err.Error(), "authentication required") {
+		t.Errorf("Got error %v", err)
+	}
+	if auth.Username != "" {
+		t.Errorf("Credentials %+v, want none", auth)
+	}
+}
+
+func TestLoadRegistryAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "config.json")
+	config := `{"auths": {"registry.example.com:443": {"auth": "` +
+		base64.StdEncoding.EncodeToString([]byte("alice:s3cr:et")) + `"}}}`
+	if err := ioutil.WriteFile(file, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	auth, err := LoadRegistryAuth(file, "registry.example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.Username != "alice" || auth.Password != "s3cr:et" {
+		t.Errorf("Credentials %+v", auth)
+	}
+
+	auth, err = LoadRegistryAuth(file, "other.example.com")
+	if err != nil || *auth != (RegistryAuth{}) {
+		t.Errorf("Credentials of an unknown registry %+v, %v", auth, err)
+	}
+}
+
+func TestRegistryOf(t *testing.T) {
+	tests := map[string]string{
+		"registry.example.com:443/alice/hello": "registry.example.com:443",
+		"localhost/alice/hello":                "localhost",
+		"alice/hello":                          "",
+		"hello":                                "",
+	}
+	for image, want := range tests {
+		if got := registryOf(image); got != want {
+			t.Errorf("registryOf(%q) = %q, want %q", image, got, want)
+		}
+	}
+}
+
+func TestParseImageReference(t *testing.T) {
+	tests := map[string]ImageReference{
+		"alpine":                             {"", "alpine", "", ""},
+		"team/app:1.0":                       {"", "team/app", "1.0", ""},
+		"localhost:5000/team/app":            {"localhost:5000", "localhost:5000/team/app", "", ""},
+		"registry.example.com/team/app:v2.1": {"registry.example.com", "registry.example.com/team/app", "v2.1", ""},
+		"registry.example.com/team/app:v2@" + testDigest: {"registry.example.com", "registry.example.com/team/app", "v2", testDigest},
+	}
+	for ref, want := range tests {
+		r, err := ParseImageReference(ref)
+		if err != nil || *r != want {
+			t.Errorf("ParseImageReference(%q) = %+v, %v, want %+v", ref, r, err, want)
+		}
+	}
+
+	for _, ref := range []string{"", "Team/App", "team//app", "team/app:", "team/app@sha256:abc", "team/app:-tag", "-host.com/app"} {
+		if r, err := ParseImageReference(ref); err == nil {
+			t.Errorf("ParseImageReference(%q) = %+v, want an error", ref, r)
+		}
+	}
+
+	r, _ := ParseImageReference("team/app:v2@" + testDigest)
+	if r.String() != "team/app@"+testDigest {
+		t.Errorf("Pinned reference %s", r)
+	}
+}
+
+// registry stands in for the manifests endpoint of a registry holding
+// the tag v1 of alice/hello, served over https unless it is insecure.
+// It asks for tokens like the registries of the docker distribution
+// project.
+func registry(t *testing.T, deleted *[]string, insecure bool) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if user, pass, _ := r.BasicAuth(); user != "alice" || pass != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"token": "t0ken"}`)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer t0ken" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="registry",scope="repository:alice/hello:*"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch {
+		case r.Method == "HEAD" && r.URL.Path == "/v2/alice/hello/manifests/v1":
+			w.Header().Set("Docker-Content-Digest", testDigest)
+		case r.Method == "DELETE" && r.URL.Path == "/v2/alice/hello/manifests/"+testDigest:
+			*deleted = append(*deleted, testDigest)
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	if insecure {
+		server.Start()
+	} else {
+		server.StartTLS()
+	}
+	return server
+}
+
+func TestDeleteImage(t *testing.T) {
+	var deleted []string
+	server := registry(t, &deleted, false)
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	host := server.Listener.Addr().String()
+	auth := &RegistryAuth{Username: "alice", Password: "secret"}
+
+	if err := DeleteImage(host+"/alice/hello:v1", auth, false, client); err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 {
+		t.Errorf("Deleted %v", deleted)
+	}
+
+	// Images already gone are not an error
+	for _, image := range []string{host + "/alice/hello:v0", host + "/alice/gone@" + testDigest} {
+		if err := DeleteImage(image, auth, false, client); err != nil {
+			t.Errorf("Deleting %s: %v", image, err)
+		}
+	}
+
+	if err := DeleteImage(host+"/alice/hello:v1", &RegistryAuth{Username: "mallory"}, false, client); err == nil {
+		t.Errorf("Deleted an image without credentials")
+	}
+	if err := DeleteImage("alice/hello", auth, false, client); err == nil {
+		t.Errorf("Deleted an image of the default registry")
+	}
+}
+
+func TestDeleteImageInsecure(t *testing.T) {
+	var deleted []string
+	server := registry(t, &deleted, true)
+	defer server.Close()
+
+	host := server.Listener.Addr().String()
+	auth := &RegistryAuth{Username: "alice", Password: "secret"}
+
+	if err := DeleteImage(host+"/alice/hello:v1", auth, false, nil); err == nil {
+		t.Errorf("Deleted an image over http from a registry which is not insecure")
+	}
+	if err := DeleteImage(host+"/alice/hello:v1", auth, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 {
+		t.Errorf("Deleted %v", deleted)
+	}
 }
@@ -1,42 +1,264 @@
 package docker
 
-// At the time of developing docker registry handling, ie, pushing
-// created function to the registry, there is a bug that is not
-// resolved in the master branch of "github.com/docker/docker".
-// The bug can be found here:
-//
-//    https://github.com/docker/docker/issues/26781
-//
-//
-// The following is just a hack, simply calling command line from
-// golang code.
-//
-// After the community resolve the mentioned bug, the implementation
-// should be replaced with real go code. At the meantime, all the
-// dev regarding this issue can be found in branch "registry-test".
-// Do `git chechout registry-test` to check it out.
-
 import (
-	"errors"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
-	"os/exec"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+var (
+	// Status line of a push reporting the digest, used when the daemon
+	// does not send it as auxiliary data
+	digestRegexp = regexp.MustCompile(`digest: (sha256:[a-f0-9]{64})`)
+
+	// Parts of image references
+	pathComponentRegexp = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*$`)
+	hostRegexp          = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9.-]*[a-zA-Z0-9])?(:[0-9]+)?$`)
+	tagRegexp           = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+	imageDigestRegexp   = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
 )
 
-func RegisterFunction(registry, namespace, funcName string) error {
-	// cmd
-	app := "docker"
+// RegistryAuth are the credentials of a docker registry. An empty
+// RegistryAuth pushes anonymously.
+type RegistryAuth struct {
+	Username string
+	Password string
+
+	// Token given by the registry instead of a password
+	IdentityToken string
+}
+
+// LoadRegistryAuth reads the credentials of a registry from a docker
+// client config file, ie ~/.docker/config.json as written by
+// `docker login`. It returns empty credentials if the file has none for
+// the registry.
+func LoadRegistryAuth(file, registry string) (*RegistryAuth, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var config struct {
+		Auths map[string]struct {
+			Auth          string `json:"auth"`
+			IdentityToken string `json:"identitytoken"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(buf, &config); err != nil {
+		return nil, fmt.Errorf("Invalid docker config file %s: %v", file, err)
+	}
+
+	entry, ok := config.Auths[registry]
+	if !ok {
+		entry, ok = config.Auths["https://"+registry]
+	}
+	if !ok {
+		return &RegistryAuth{}, nil
+	}
+
+	auth := &RegistryAuth{IdentityToken: entry.IdentityToken}
+	if entry.Auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid credentials for %s in %s: %v", registry, file, err)
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid credentials for %s in %s", registry, file)
+		}
+		auth.Username, auth.Password = parts[0], parts[1]
+	}
+	return auth, nil
+}
+
+// encode encodes the credentials for the X-Registry-Auth header. The
+// daemon rejects pushes without the header, so empty credentials are
+// sent as well (see https://github.com/docker/docker/issues/26781).
+func (auth *RegistryAuth) encode(registry string) (string, error) {
+	config := types.AuthConfig{ServerAddress: registry}
+	if auth != nil {
+		config.Username = auth.Username
+		config.Password = auth.Password
+		config.IdentityToken = auth.IdentityToken
+	}
+
+	buf, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(buf), nil
+}
+
+// pushResult is the auxiliary data sent by the daemon once a tag is
+// pushed.
+type pushResult struct {
+	Tag    string
+	Digest string
+	Size   int
+}
 
-	// args
-	arg0 := "push"
-	arg1 := registry + "/" + namespace + "/" + funcName
-	cmd := exec.Command(app, arg0, arg1)
-	stdout, err := cmd.Output()
+// PushFunction pushes the image of a function to its registry with the
+// given credentials, which can be nil. It returns the digest of the
+// pushed image.
+func (d *Docker) PushFunction(image string, auth *RegistryAuth) (string, error) {
+	encoded, err := auth.encode(registryOf(image))
+	if err != nil {
+		return "", err
+	}
 
+	cli, err := d.initCli()
 	if err != nil {
-		log.Printf("Failed to execute exec command `docker push` %s\n.", err)
-		return errors.New("Failed to execuate exec command `docker push`")
+		log.Printf("Failed to init cli. Error: %s", err)
+		return "", err
 	}
 
-	log.Printf(string(stdout))
-	return nil
+	resp, err := cli.ImagePush(context.Background(), image, types.ImagePushOptions{
+		RegistryAuth: encoded,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Close()
+
+	return readPushOutput(image, resp)
+}
+
+// readPushOutput follows the progress of a push, logging every status
+// change, and returns the digest of the image. Errors reported by the
+// daemon in the stream are returned with their message.
+func readPushOutput(image string, r io.Reader) (string, error) {
+	var digest, lastStatus string
+
+	decoder := json.NewDecoder(r)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := decoder.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", fmt.Errorf("Failed to read push output of %s: %v", image, err)
+		}
+
+		if msg.Error != nil {
+			return "", fmt.Errorf("Failed to push %s: %s", image, msg.Error.Message)
+		}
+		if msg.ErrorMessage != "" {
+			return "", fmt.Errorf("Failed to push %s: %s", image, msg.ErrorMessage)
+		}
+
+		if msg.Aux != nil {
+			var result pushResult
+			if err := json.Unmarshal(*msg.Aux, &result); err == nil && result.Digest != "" {
+				digest = result.Digest
+			}
+		}
+
+		if msg.Status != "" && msg.Status != lastStatus {
+			if msg.ID != "" {
+				log.Printf("Push %s: %s: %s", image, msg.ID, msg.Status)
+			} else {
+				log.Printf("Push %s: %s", image, msg.Status)
+			}
+			lastStatus = msg.Status
+		}
+		if digest == "" {
+			if m := digestRegexp.FindStringSubmatch(msg.Status); m != nil {
+				digest = m[1]
+			}
+		}
+	}
+
+	if digest == "" {
+		return "", fmt.Errorf("Push of %s did not report a digest", image)
+	}
+	return digest, nil
+}
+
+// ImageReference is a parsed image reference, eg
+// registry.example.com/team/app:1.0@sha256:...
+type ImageReference struct {
+	// Registry host, "" for the default registry
+	Registry string
+
+	// Name of the image, registry included
+	Repository string
+
+	// Both optional
+	Tag    string
+	Digest string
+}
+
+// ParseImageReference parses and checks an image reference given by a
+// user.
+func ParseImageReference(ref string) (*ImageReference, error) {
+	invalid := fmt.Errorf("Invalid image reference %q", ref)
+
+	r := &ImageReference{}
+	name := ref
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, r.Digest = name[:i], name[i+1:]
+		if !imageDigestRegexp.MatchString(r.Digest) {
+			return nil, invalid
+		}
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, r.Tag = name[:i], name[i+1:]
+		if !tagRegexp.MatchString(r.Tag) {
+			return nil, invalid
+		}
+	}
+
+	r.Registry = registryOf(name)
+	path := name
+	if r.Registry != "" {
+		if !hostRegexp.MatchString(r.Registry) {
+			return nil, invalid
+		}
+		path = name[len(r.Registry)+1:]
+	}
+	for _, component := range strings.Split(path, "/") {
+		if !pathComponentRegexp.MatchString(component) {
+			return nil, invalid
+		}
+	}
+	if len(name) > 255 {
+		return nil, invalid
+	}
+
+	r.Repository = name
+	return r, nil
+}
+
+// String returns the reference, pinned by digest if it has one.
+func (r *ImageReference) String() string {
+	switch {
+	case r.Digest != "":
+		return r.Repository + "@" + r.Digest
+	case r.Tag != "":
+		return r.Repository + ":" + r.Tag
+	}
+	return r.Repository
+}
+
+// registryOf returns the registry host of an image name, or "" for
+// images of the default registry.
+func registryOf(image string) string {
+	i := strings.Index(image, "/")
+	if i < 0 {
+		return ""
+	}
+	host := image[:i]
+	if strings.ContainsAny(host, ".:") || host == "localhost" {
+		return host
+	}
+	return ""
 }
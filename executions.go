@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xuant/go-kexec/dal"
+	"github.com/xuant/go-kexec/kexec"
+	"github.com/xuant/go-kexec/runtimes"
+	v1 "k8s.io/client-go/1.4/pkg/api/v1"
+)
+
+// execution keeps track of a single function call. The ID is the
+// time based uuid generated in callFunction. Phases are the execution
+// statuses defined in the dal package.
+type execution struct {
+	ID           string     `json:"id"`
+	UserName     string     `json:"user"`
+	FunctionName string     `json:"function"`
+	Version      int        `json:"version,omitempty"`
+	Phase        string     `json:"phase"`
+	ExitCode     int        `json:"exitCode"`
+	Created      time.Time  `json:"created"`
+	Started      *time.Time `json:"started,omitempty"`
+	Finished     *time.Time `json:"finished,omitempty"`
+	Duration     string     `json:"duration,omitempty"`
+	Log          string     `json:"log,omitempty"`
+	Error        string     `json:"error,omitempty"`
+	Reason       string     `json:"reason,omitempty"`
+
+	// What the handler of the function returned or raised
+	Result        json.RawMessage `json:"result,omitempty"`
+	FunctionError *functionError  `json:"functionError,omitempty"`
+
+	jobName   string
+	namespace string
+	timeout   time.Duration
+}
+
+func (e *execution) finished() bool {
+	return e.Phase == dal.ExecutionSucceeded || e.Phase == dal.ExecutionFailed
+}
+
+// executionFromRecord converts an execution read from the DAL.
+func executionFromRecord(r *dal.FunctionExecution) *execution {
+	e := &execution{
+		ID:           r.UUID,
+		UserName:     r.UserName,
+		FunctionName: r.FunctionName,
+		Version:      r.Version,
+		Phase:        r.Status,
+		ExitCode:     r.ExitCode,
+		Created:      r.Timestamp,
+		Log:          r.Log,
+		jobName:      r.JobName,
+		namespace:    r.Namespace,
+	}
+	if !r.Finished.IsZero() {
+		finished := r.Finished
+		e.Finished = &finished
+		e.Duration = r.Duration.String()
+	}
+	if output, err := parseFunctionOutput(r.Result); err == nil && output != nil {
+		e.Result = output.Result
+		e.FunctionError = output.Error
+	}
+	return e
+}
+
+// functionOutput is what the runtime wrappers write to the result file
+// of a function: the JSON value returned by the handler, or the error
+// it raised.
+type functionOutput struct {
+	Result json.RawMessage `json:"result"`
+	Error  *functionError  `json:"error,omitempty"`
+}
+
+type functionError struct {
+	Type      string `json:"type"`
+	Message   string `json:"message"`
+	Traceback string `json:"traceback"`
+}
+
+// parseFunctionOutput decodes the content of a result file. It returns
+// nil if the function wrote none, eg when its image was built before
+// functions had handlers.
+func parseFunctionOutput(message string) (*functionOutput, error) {
+	if strings.TrimSpace(message) == "" {
+		return nil, nil
+	}
+
+	output := &functionOutput{}
+	if err := json.Unmarshal([]byte(message), output); err != nil {
+		return nil, fmt.Errorf("Invalid function result, results are limited to %d bytes: %v", runtimes.MaxResultSize, err)
+	}
+	if len(output.Result) == 0 {
+		output.Result = json.RawMessage("null")
+	}
+	return output, nil
+}
+
+var (
+	// Time given to the pod of an execution to start, on top of the
+	// max runtime of the function
+	PodStartTimeout = 2 * time.Minute
+
+	// Reason of the executions whose function wrote a result that
+	// could not be decoded, eg because it was truncated
+	ReasonInvalidResult = "InvalidResult"
+)
+
+// executionStore holds the executions in flight on this server. It is
+// safe for concurrent use by the handlers and the goroutines waiting
+// for jobs to complete. Finished executions are persisted through the
+// DAL and dropped from the store.
+type executionStore struct {
+	sync.RWMutex
+	executions map[string]*execution
+}
+
+func newExecutionStore() *executionStore {
+	return &executionStore{
+		executions: make(map[string]*execution),
+	}
+}
+
+func (s *executionStore) add(e *execution) {
+	s.Lock()
+	defer s.Unlock()
+	s.executions[e.ID] = e
+}
+
+// get returns a copy of the execution, so the caller can read it
+// without holding the lock.
+func (s *executionStore) get(id string) (execution, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	e, ok := s.executions[id]
+	if !ok {
+		return execution{}, false
+	}
+	return *e, true
+}
+
+func (s *executionStore) remove(id string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.executions, id)
+}
+
+// getExecution looks for an execution in flight first, then in the DAL.
+func getExecution(a *appContext, id string) (*execution, error) {
+	if e, ok := a.executions.get(id); ok {
+		return &e, nil
+	}
+
+	record, err := a.dal.GetExecution(id)
+	if err != nil {
+		return nil, err
+	}
+	return executionFromRecord(record), nil
+}
+
+// waitForExecution blocks until the job of the execution completes,
+// records the final state through the DAL and returns it.
+//
+// The log is read for failed executions as well, since it usually
+// tells why the function failed.
+func waitForExecution(a *appContext, e *execution) *execution {
+	result, err := a.k.WaitForPodComplete(e.jobName, e.namespace, e.timeout)
+	if result == nil {
+		log.Printf("Execution %s failed: %v", e.ID, err)
+		return finishExecution(a, e, nil, "", err)
+	}
+	if err != nil {
+		log.Printf("Execution %s failed: %v", e.ID, err)
+	}
+
+	funcLog, logErr := a.k.GetFunctionLog(e.jobName, e.namespace, nil)
+	if logErr != nil {
+		log.Printf("Failed to get log of execution %s: %v", e.ID, logErr)
+		if err == nil {
+			err = logErr
+		}
+	}
+	log.Printf("Function Log:\n %s", string(funcLog))
+
+	return finishExecution(a, e, result, string(funcLog), err)
+}
+
+// finishExecution records the final state of an execution, given the
+// result of its pod if it ran. It has failed if `err` is not nil.
+func finishExecution(a *appContext, e *execution, pod *kexec.PodResult, funcLog string, err error) *execution {
+	finished := time.Now()
+	result := *e
+	result.Phase = dal.ExecutionSucceeded
+	result.ExitCode = -1
+	result.Finished = &finished
+	result.Duration = finished.Sub(e.Created).String()
+	result.Log = funcLog
+
+	var output string
+	if pod != nil {
+		result.ExitCode = int(pod.ExitCode)
+		output = pod.Message
+
+		parsed, parseErr := parseFunctionOutput(output)
+		if parseErr != nil {
+			log.Printf("Execution %s: %v", e.ID, parseErr)
+			output = ""
+			if err == nil {
+				err = &kexec.PodError{Reason: ReasonInvalidResult, Message: parseErr.Error(), ExitCode: pod.ExitCode}
+			}
+		} else if parsed != nil {
+			result.Result = parsed.Result
+			result.FunctionError = parsed.Error
+		}
+	}
+
+	if err != nil {
+		result.Phase = dal.ExecutionFailed
+		result.Error = err.Error()
+		result.Reason = kexec.ReasonFailed
+		if podErr, ok := err.(*kexec.PodError); ok {
+			result.Reason = podErr.Reason
+		}
+	}
+
+	record := &dal.FunctionExecution{
+		UUID:     e.ID,
+		Status:   result.Phase,
+		ExitCode: result.ExitCode,
+		Duration: finished.Sub(e.Created),
+		Log:      funcLog,
+		Result:   output,
+		Finished: finished,
+	}
+	if err := a.dal.UpdateExecution(record); err != nil {
+		// Keep the execution in memory, so it can still be queried
+		// until the server restarts.
+		log.Printf("Failed to update execution %s in DB: %v", e.ID, err)
+		a.executions.add(&result)
+		return &result
+	}
+
+	a.executions.remove(e.ID)
+
+	// The job can be garbage collected, now that the execution is in
+	// DB. Jobs of executions that timed out are deleted already. Those
+	// of other failed executions are deleted now: a job runs a new pod
+	// when its pod fails, which would run the function again.
+	if result.Reason == kexec.ReasonTimeout {
+		return &result
+	}
+	if result.Phase == dal.ExecutionFailed {
+		err := a.k.DeleteJobIfExists(e.jobName, e.namespace)
+		if err == nil {
+			return &result
+		}
+		log.Printf("Failed to delete job %s of execution %s: %v", e.jobName, e.ID, err)
+	}
+	if err := a.k.MarkLogsPersisted(e.jobName, e.namespace, result.Phase == dal.ExecutionSucceeded); err != nil {
+		log.Printf("Failed to mark job %s of execution %s: %v", e.jobName, e.ID, err)
+	}
+	return &result
+}
+
+// executionTimeout is how long to wait for an execution of a function
+// with the given limits: the configured timeout, shortened to the max
+// runtime of the function plus some time to start its pod.
+func executionTimeout(a *appContext, limits *kexec.ResourceLimits) time.Duration {
+	timeout := kexec.DefaultWaitTimeout
+	if a.conf.ExecutionTimeout > 0 {
+		timeout = time.Duration(a.conf.ExecutionTimeout) * time.Second
+	}
+
+	if limits != nil && limits.MaxRuntime > 0 {
+		runtime := time.Duration(limits.MaxRuntime)*time.Second + PodStartTimeout
+		if runtime < timeout {
+			timeout = runtime
+		}
+	}
+	return timeout
+}
+
+// failureStatus maps the failure of an execution to a HTTP status code
+// and a message for the client.
+func failureStatus(e *execution) (int, string) {
+	switch e.Reason {
+	case kexec.ReasonTimeout:
+		return http.StatusGatewayTimeout, "Function did not complete in time"
+	case kexec.ReasonDeadlineExceeded:
+		return http.StatusGatewayTimeout, "Function exceeded its max runtime"
+	case kexec.ReasonPodDeleted:
+		return http.StatusServiceUnavailable, "Function pod was deleted before completing"
+	case kexec.ReasonImagePull:
+		return http.StatusBadGateway, "Function image could not be pulled"
+	case kexec.ReasonUnschedulable:
+		return http.StatusServiceUnavailable, "No resources available to run the function"
+	case kexec.ReasonOOMKilled:
+		return http.StatusInternalServerError, "Function ran out of memory"
+	case ReasonInvalidResult:
+		return http.StatusBadGateway, "Function returned an invalid result"
+	case kexec.ReasonCrashLoop, kexec.ReasonFailed:
+		return http.StatusInternalServerError, fmt.Sprintf("Function failed with exit code %d", e.ExitCode)
+	}
+	return http.StatusInternalServerError, MessageCallFunctionFailed
+}
+
+// refreshExecution looks at the pods of an unfinished execution to tell
+// whether it is still pending or already running.
+func refreshExecution(a *appContext, e *execution) {
+	if e.finished() || e.jobName == "" {
+		return
+	}
+
+	podlist, err := a.k.GetFunctionPods(e.jobName, e.namespace)
+	if err != nil {
+		log.Printf("Failed to get pods of execution %s: %v", e.ID, err)
+		return
+	}
+
+	for _, pod := range podlist.Items {
+		if pod.Status.StartTime != nil {
+			started := pod.Status.StartTime.Time
+			e.Started = &started
+		}
+		if pod.Status.Phase != v1.PodPending {
+			e.Phase = dal.ExecutionRunning
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFunctionOutput(t *testing.T) {
+	tests := []struct {
+		message   string
+		nilOutput bool
+		invalid   bool
+		result    string
+		errorType string // of the function error, "" if none
+	}{
+		{message: "", nilOutput: true},
+		{message: " \n", nilOutput: true},
+		{message: `{"result": {"sum": 3}}`, result: `{"sum": 3}`},
+		{message: `{"result": "ok"}`, result: `"ok"`},
+		{message: `{"result": null}`, result: `null`},
+		{message: `{}`, result: `null`},
+		{
+			message:   `{"result": null, "error": {"type": "ValueError", "message": "bad input", "traceback": "..."}}`,
+			result:    `null`,
+			errorType: "ValueError",
+		},
+		{
+			message:   `{"error": {"type": "Error", "message": "failed"}}`,
+			result:    `null`,
+			errorType: "Error",
+		},
+
+		// Truncated by the 4096 bytes limit of termination messages
+		{message: `{"result": "` + strings.Repeat("a", 4090), invalid: true},
+		{message: `{"result": {"sum": `, invalid: true},
+		{message: `hello`, invalid: true},
+	}
+
+	for _, test := range tests {
+		output, err := parseFunctionOutput(test.message)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%.40q: got %+v, want an error", test.message, output)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%.40q: unexpected error %v", test.message, err)
+			continue
+		}
+		if test.nilOutput {
+			if output != nil {
+				t.Errorf("%.40q: got %+v, want no output", test.message, output)
+			}
+			continue
+		}
+
+		if string(output.Result) != test.result {
+			t.Errorf("%.40q: result %s, want %s", test.message, output.Result, test.result)
+		}
+		switch {
+		case test.errorType == "" && output.Error != nil:
+			t.Errorf("%.40q: unexpected function error %+v", test.message, output.Error)
+		case test.errorType != "" && (output.Error == nil || output.Error.Type != test.errorType):
+			t.Errorf("%.40q: function error %+v, want type %s", test.message, output.Error, test.errorType)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/xuant/go-kexec/dal"
+)
+
+var (
+	// Used when MaxParallelism is not configured
+	DefaultMaxParallelism = 10
+
+	// Used when MaxFanOutItems is not configured
+	DefaultMaxFanOutItems = 1000
+
+	// Used when MaxSyncFanOutItems is not configured
+	DefaultMaxSyncFanOutItems = 100
+)
+
+// fanOutResult is the result of one input of a fan-out call.
+type fanOutResult struct {
+	Index         int             `json:"index"`
+	ID            string          `json:"id,omitempty"`
+	Phase         string          `json:"phase"`
+	ExitCode      int             `json:"exitCode"`
+	Result        json.RawMessage `json:"result,omitempty"`
+	FunctionError *functionError  `json:"functionError,omitempty"`
+	Log           string          `json:"log,omitempty"`
+	Error         string          `json:"error,omitempty"`
+	Reason        string          `json:"reason,omitempty"`
+}
+
+// parseFanOutParams splits a JSON array into the parameters of every
+// execution of a fan-out call.
+func parseFanOutParams(body []byte) ([]string, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(body, &items); err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, errors.New("Empty parameter array")
+	}
+
+	params := make([]string, len(items))
+	for i, item := range items {
+		params[i] = string(item)
+	}
+	return params, nil
+}
+
+// checkFanOutSize fails with a 400 if a fan-out call has more than the
+// configured maximum of parameters, or if it has more than can be waited
+// for and is not asynchronous.
+func checkFanOutSize(a *appContext, items int, async bool) error {
+	max := a.conf.MaxFanOutItems
+	if max <= 0 {
+		max = DefaultMaxFanOutItems
+	}
+	if items > max {
+		err := fmt.Errorf("%d fan-out parameters, the maximum is %d", items, max)
+		return StatusError{http.StatusBadRequest, err, MessageFanOutTooLarge}
+	}
+
+	maxSync := a.conf.MaxSyncFanOutItems
+	if maxSync <= 0 {
+		maxSync = DefaultMaxSyncFanOutItems
+	}
+	if !async && items > maxSync {
+		err := fmt.Errorf("%d fan-out parameters, the maximum without async is %d", items, maxSync)
+		return StatusError{http.StatusBadRequest, err, MessageFanOutNotAsync}
+	}
+	return nil
+}
+
+// fanOutParallelism caps the requested parallelism to the configured
+// maximum. A request of 0 or less asks for the maximum.
+func fanOutParallelism(a *appContext, requested int) int {
+	max := a.conf.MaxParallelism
+	if max <= 0 {
+		max = DefaultMaxParallelism
+	}
+	if requested <= 0 || requested > max {
+		return max
+	}
+	return requested
+}
+
+// callFunctionFanOut calls a function once per element of `params`, as
+// the execution of the same index in `ids`, with at most `parallelism`
+// executions running at the same time, and waits for all of them.
+// Results are returned in input order.
+//
+// Every element runs as its own job: batch/v1 jobs have no completion
+// index that would let each pod of a single job pick its own input.
+func callFunctionFanOut(a *appContext, userName, functionName string, version int, ids, params []string, parallelism int) []*fanOutResult {
+	results := make([]*fanOutResult, len(params))
+	slots := make(chan struct{}, parallelism)
+
+	var wg sync.WaitGroup
+	for i, p := range params {
+		wg.Add(1)
+		slots <- struct{}{}
+
+		go func(i int, p string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			result := &fanOutResult{Index: i, ID: ids[i]}
+			results[i] = result
+
+			exe, err := startExecution(a, ids[i], userName, functionName, version, p)
+			if err != nil {
+				log.Printf("Failed to call function %s for input #%d: %v", functionName, i, err)
+				result.Phase = dal.ExecutionFailed
+				result.Error = err.Error()
+				return
+			}
+
+			finished := waitForExecution(a, exe)
+			result.ID = finished.ID
+			result.Phase = finished.Phase
+			result.ExitCode = finished.ExitCode
+			result.Result = finished.Result
+			result.FunctionError = finished.FunctionError
+			result.Log = finished.Log
+			result.Error = finished.Error
+			result.Reason = finished.Reason
+		}(i, p)
+	}
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/xuant/go-kexec/dal"
+)
+
+func TestParseFanOutParams(t *testing.T) {
+	tests := []struct {
+		body   string
+		params []string // nil if invalid
+	}{
+		{`[1, "two", {"three": 3}, [4], null]`, []string{`1`, `"two"`, `{"three": 3}`, `[4]`, `null`}},
+		{`[{}]`, []string{`{}`}},
+		{`[]`, nil},
+		{`{"a": 1}`, nil},
+		{`"a"`, nil},
+		{`[1, 2`, nil},
+		{``, nil},
+	}
+
+	for _, test := range tests {
+		params, err := parseFanOutParams([]byte(test.body))
+		if test.params == nil {
+			if err == nil {
+				t.Errorf("%q: got %q, want an error", test.body, params)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.body, err)
+			continue
+		}
+		if !reflect.DeepEqual(params, test.params) {
+			t.Errorf("%q: got %q, want %q", test.body, params, test.params)
+		}
+	}
+}
+
+func TestCheckFanOutSize(t *testing.T) {
+	defaults := &appConfig{}
+	small := &appConfig{MaxFanOutItems: 5, MaxSyncFanOutItems: 2}
+
+	tests := []struct {
+		conf    *appConfig
+		items   int
+		async   bool
+		message string // of the error, "" if none
+	}{
+		{defaults, DefaultMaxSyncFanOutItems, false, ""},
+		{defaults, DefaultMaxSyncFanOutItems + 1, false, MessageFanOutNotAsync},
+		{defaults, DefaultMaxSyncFanOutItems + 1, true, ""},
+		{defaults, DefaultMaxFanOutItems, true, ""},
+		{defaults, DefaultMaxFanOutItems + 1, true, MessageFanOutTooLarge},
+		{defaults, DefaultMaxFanOutItems + 1, false, MessageFanOutTooLarge},
+		{small, 2, false, ""},
+		{small, 3, false, MessageFanOutNotAsync},
+		{small, 5, true, ""},
+		{small, 6, true, MessageFanOutTooLarge},
+	}
+
+	for _, test := range tests {
+		a := &appContext{conf: test.conf}
+		err := checkFanOutSize(a, test.items, test.async)
+		if test.message == "" {
+			if err != nil {
+				t.Errorf("%d items (async %v, config %+v): unexpected error %v", test.items, test.async, *test.conf, err)
+			}
+			continue
+		}
+
+		e, ok := err.(Error)
+		if !ok || e.Status() != http.StatusBadRequest || e.Message() != test.message {
+			t.Errorf("%d items (async %v, config %+v): got %v, want a 400 %q", test.items, test.async, *test.conf, err, test.message)
+		}
+	}
+}
+
+func TestFanOutParallelism(t *testing.T) {
+	tests := []struct {
+		max       int
+		requested int
+		want      int
+	}{
+		{0, 0, DefaultMaxParallelism},
+		{0, 3, 3},
+		{0, DefaultMaxParallelism + 1, DefaultMaxParallelism},
+		{4, -1, 4},
+		{4, 2, 2},
+		{4, 5, 4},
+	}
+
+	for _, test := range tests {
+		a := &appContext{conf: &appConfig{MaxParallelism: test.max}}
+		if got := fanOutParallelism(a, test.requested); got != test.want {
+			t.Errorf("Max %d, requested %d: got %d, want %d", test.max, test.requested, got, test.want)
+		}
+	}
+}
+
+// Executions failing to start are reported at the index of their input.
+// The function does not exist, so no job is created.
+func TestCallFunctionFanOutOrder(t *testing.T) {
+	a := newTestContext(t)
+
+	params := make([]string, 7)
+	ids := make([]string, len(params))
+	for i := range params {
+		params[i] = fmt.Sprint(i)
+		ids[i] = fmt.Sprintf("exe-%d", i)
+	}
+
+	results := callFunctionFanOut(a, "alice", "missing", 0, ids, params, 3)
+	if len(results) != len(params) {
+		t.Fatalf("Got %d results, want %d", len(results), len(params))
+	}
+	for i, result := range results {
+		if result.Index != i || result.ID != ids[i] {
+			t.Errorf("Result #%d is for input #%d, execution %s", i, result.Index, result.ID)
+		}
+		if result.Phase != dal.ExecutionFailed || result.Error == "" {
+			t.Errorf("Result #%d: phase %s, error %q, want a failure", i, result.Phase, result.Error)
+		}
+	}
+}
@@ -7,11 +7,16 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/securecookie"
+	"github.com/xuant/go-kexec/builder"
 	"github.com/xuant/go-kexec/dal"
 	"github.com/xuant/go-kexec/docker"
 	"github.com/xuant/go-kexec/kexec"
+	"github.com/xuant/go-kexec/runtimes"
 )
 
 var argConfigFile = flag.String("config", "", "Config file")
@@ -28,55 +33,109 @@ func main() {
 		log.Fatalf("Cannot load config file %s: %v\n", *argConfigFile, err)
 	}
 
-	// cookie handling
-	cookieHandler := securecookie.New(
-		securecookie.GenerateRandomKey(64),
-		securecookie.GenerateRandomKey(32),
-	)
+	// data access layer, of the backend named in config. The
+	// environment overrides the config.
+	if err := conf.DB.LoadEnv(); err != nil {
+		log.Fatalf("Cannot load DB settings: %v\n", err)
+	}
+	dal, err := dal.Open(&conf.DB)
+	if err != nil {
+		log.Fatalf("Cannot open DB: %v\n", err)
+	}
 
-	// docker handler for creating function and pushing function image
-	// to docker registry
-	d := docker.NewDocker(
-		// http headers
-		map[string]string{"User-Agent": "engin-api-cli-1.0"},
-		// docker host
-		"unix:///var/run/docker.sock",
-		// docker api version
-		"v1.22",
-		// http client
-		nil,
-	)
+	// go-kexec -config=<file> migrate [-to=<version>] [-dry-run]
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrate(dal, flag.Args()[1:]); err != nil {
+			log.Fatalf("Cannot migrate DB: %v\n", err)
+		}
+		return
+	}
+	if err := migrateOnStartup(dal, &conf.DB); err != nil {
+		log.Fatalf("Cannot migrate DB: %v\n", err)
+	}
 
 	// kubernetes handler for calling function and pulling function
 	// execution logs
 	k, err := kexec.NewKexec(&kexec.KexecConfig{
-		KubeConfig: os.Getenv("HOME") + "/.kube/config",
+		KubeConfig:        os.Getenv("HOME") + "/.kube/config",
+		NamespaceTemplate: conf.Namespace,
 	})
 
 	if err != nil {
 		panic(err)
 	}
 
-	// data access layer. Default MySQL
-	//
-	// TODO: dal should be pluggable
-	dal, err := dal.NewMySQL(&dal.DalConfig{
-		DBHost:   "100.73.145.91",
-		Username: "kexec",
-		Password: "password",
+	// go-kexec -config=<file> migrate-namespaces [-dry-run]
+	if flag.Arg(0) == "migrate-namespaces" {
+		if err := runMigrateNamespaces(dal, k, flag.Args()[1:]); err != nil {
+			log.Fatalf("Cannot migrate namespaces: %v\n", err)
+		}
+		return
+	}
 
-		DBName: "kexec",
+	// runtimes functions can be written for
+	rts, err := runtimes.Load(conf.RuntimesDir)
+	if err != nil {
+		log.Fatalf("Cannot load runtimes from %s: %v\n", conf.RuntimesDir, err)
+	}
 
-		UsersTable:      "users",
-		FunctionsTable:  "functions",
-		ExecutionsTable: "executions",
-	})
+	// cookie handling
+	cookieHandler := securecookie.New(
+		securecookie.GenerateRandomKey(64),
+		securecookie.GenerateRandomKey(32),
+	)
 
+	// credentials of the docker registry
+	registryAuth := &conf.RegistryAuth
+	if conf.RegistryAuthFile != "" {
+		registryAuth, err = docker.LoadRegistryAuth(conf.RegistryAuthFile, conf.DockerRegistry)
+		if err != nil {
+			log.Fatalf("Cannot load registry credentials: %v\n", err)
+		}
+	}
+
+	// user namespaces are reconciled again when the config is
+	// reloaded
+	go reloadNamespaceTemplate(k, *argConfigFile)
+
+	// builder creating function images and pushing them to the docker
+	// registry, with a local docker daemon or in the cluster
+	b, err := builder.New(conf.Builder, k, registryAuth)
 	if err != nil {
-		panic(err)
+		log.Fatalf("Cannot create builder: %v\n", err)
 	}
 
-	context := &appContext{d: d, k: k, dal: dal, cookieHandler: cookieHandler, conf: &conf}
+	// reaper deleting finished jobs and their pods
+	var reaper *kexec.Reaper
+	if conf.Reaper.Interval > 0 {
+		reaper = k.NewReaper(kexec.ReaperConfig{
+			SucceededRetention: time.Duration(conf.Reaper.SucceededRetention) * time.Second,
+			FailedRetention:    time.Duration(conf.Reaper.FailedRetention) * time.Second,
+			Interval:           time.Duration(conf.Reaper.Interval) * time.Second,
+			MaxAge:             time.Duration(conf.Reaper.MaxAge) * time.Second,
+		})
+		reaper.Start()
+	}
+
+	context := &appContext{
+		k:             k,
+		dal:           dal,
+		cookieHandler: cookieHandler,
+		conf:          &conf,
+		executions:    newExecutionStore(),
+		reaper:        reaper,
+		runtimes:      rts,
+		builder:       b,
+		registryAuth:  registryAuth,
+		functionLocks: newFunctionLocks(),
+	}
+
+	// queue running the builds of functions, with the builds a
+	// previous run left unfinished
+	context.builds = newBuildQueue(context, conf.Builds)
+	if err := context.builds.recover(); err != nil {
+		log.Fatalf("Cannot recover unfinished builds: %v\n", err)
+	}
 
 	router := NewRouter(context)
 
@@ -84,3 +143,26 @@ func main() {
 
 	panic(http.ListenAndServe(":8080", nil))
 }
+
+// reloadNamespaceTemplate reads the namespace template from the config
+// file again every time the server gets a SIGHUP.
+func reloadNamespaceTemplate(k *kexec.Kexec, configPath string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		configFile, err := ioutil.ReadFile(configPath)
+		if err != nil {
+			log.Printf("Cannot read config file %s: %v", configPath, err)
+			continue
+		}
+		var conf appConfig
+		if err := json.Unmarshal(configFile, &conf); err != nil {
+			log.Printf("Cannot load config file %s: %v", configPath, err)
+			continue
+		}
+
+		k.SetNamespaceTemplate(conf.Namespace)
+		log.Printf("Reloaded the namespace template from %s", configPath)
+	}
+}
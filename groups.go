@@ -0,0 +1,281 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/xuant/go-kexec/dal"
+	"github.com/xuant/go-kexec/naming"
+	"gopkg.in/ldap.v2"
+)
+
+const (
+	// Used when the LDAP config leaves them unset
+	DefaultLDAPGroupFilter    = "(member=%s)"
+	DefaultLDAPGroupAttribute = "cn"
+)
+
+// groupInfo is a group as served by the groups endpoints.
+type groupInfo struct {
+	Name      string    `json:"name"`
+	Namespace string    `json:"namespace"`
+	Created   time.Time `json:"created"`
+	Members   []string  `json:"members,omitempty"`
+}
+
+func groupInfoFromRecord(group *dal.Group) *groupInfo {
+	info := &groupInfo{
+		Name:      group.Name,
+		Namespace: naming.Namespace(dal.GroupOwner(group.Name)),
+		Created:   group.Created,
+	}
+	for _, user := range group.Users {
+		info.Members = append(info.Members, user.Name)
+	}
+	return info
+}
+
+// isAdmin tells whether a user is one of the admins of the config.
+func isAdmin(a *appContext, userName string) bool {
+	for _, admin := range a.conf.Admins {
+		if admin == userName {
+			return true
+		}
+	}
+	return false
+}
+
+// getAdminName returns the logged in user, who must be an admin.
+func getAdminName(a *appContext, request *http.Request) (string, error) {
+	userName := getUserName(a, request)
+	if userName == "" {
+		err := errors.New("Not logged in")
+		return "", StatusError{http.StatusUnauthorized, err, MessageUnauthorized}
+	}
+	if !isAdmin(a, userName) {
+		err := fmt.Errorf("%s is not an admin", userName)
+		return "", StatusError{http.StatusForbidden, err, MessageAdminOnly}
+	}
+	return userName, nil
+}
+
+// checkGroupMember fails unless a user is a member of a group.
+func checkGroupMember(a *appContext, groupName, userName string) error {
+	member, err := a.dal.IsGroupMember(groupName, userName)
+	if err == sql.ErrNoRows {
+		return StatusError{http.StatusNotFound, err, MessageGroupNotFound}
+	}
+	if err != nil {
+		return err
+	}
+	if !member {
+		err := fmt.Errorf("%s is not a member of group %s", userName, groupName)
+		return StatusError{http.StatusForbidden, err, MessageNotGroupMember}
+	}
+	return nil
+}
+
+// functionOwner returns the account owning the functions a request is
+// about: the account of the group given by the form value `group`, of
+// which the logged in user `userName` must be a member, or the user.
+func functionOwner(a *appContext, request *http.Request, userName string) (string, error) {
+	groupName := request.FormValue("group")
+	if groupName == "" {
+		return userName, nil
+	}
+	if err := checkGroupMember(a, groupName, userName); err != nil {
+		return "", err
+	}
+	return dal.GroupOwner(groupName), nil
+}
+
+// getGroup gets a group with its members, failing with a 404 if it does
+// not exist.
+func getGroup(a *appContext, groupName string) (*dal.Group, error) {
+	group, err := a.dal.GetGroup(groupName)
+	if err == sql.ErrNoRows {
+		return nil, StatusError{http.StatusNotFound, err, MessageGroupNotFound}
+	}
+	return group, err
+}
+
+func writeGroup(response http.ResponseWriter, group *dal.Group) error {
+	response.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(response).Encode(groupInfoFromRecord(group))
+}
+
+// ListGroupsHandler lists the groups of the logged in user, or all the
+// groups for admins.
+func ListGroupsHandler(a *appContext, response http.ResponseWriter, request *http.Request) error {
+	userName := getUserName(a, request)
+	if userName == "" {
+		err := errors.New("Not logged in")
+		return StatusError{http.StatusUnauthorized, err, MessageUnauthorized}
+	}
+
+	member := userName
+	if isAdmin(a, userName) {
+		member = ""
+	}
+	groups, err := a.dal.ListGroups(member)
+	if err != nil {
+		return err
+	}
+
+	list := make([]*groupInfo, 0, len(groups))
+	for _, group := range groups {
+		list = append(list, groupInfoFromRecord(group))
+	}
+	response.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(response).Encode(list)
+}
+
+// CreateGroupHandler creates the group given by the form value `name`.
+// Only admins create groups.
+func CreateGroupHandler(a *appContext, response http.ResponseWriter, request *http.Request) error {
+	if _, err := getAdminName(a, request); err != nil {
+		return err
+	}
+
+	groupName := request.FormValue("name")
+	if err := naming.ValidateGroupName(groupName); err != nil {
+		return StatusError{http.StatusBadRequest, err, err.Error()}
+	}
+
+	_, rowCnt, err := a.dal.PutGroupIfNotExisted(groupName)
+	if err != nil {
+		return err
+	}
+	if rowCnt == 0 {
+		err := fmt.Errorf("Group %s already exists", groupName)
+		return StatusError{http.StatusConflict, err, MessageGroupExists}
+	}
+	log.Printf("Created group %s", groupName)
+
+	group, err := getGroup(a, groupName)
+	if err != nil {
+		return err
+	}
+	response.Header().Set("Location", "/groups/"+groupName)
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusCreated)
+	return json.NewEncoder(response).Encode(groupInfoFromRecord(group))
+}
+
+// GetGroupHandler reports a group and its members to its members and
+// the admins.
+func GetGroupHandler(a *appContext, response http.ResponseWriter, request *http.Request) error {
+	userName := getUserName(a, request)
+	if userName == "" {
+		err := errors.New("Not logged in")
+		return StatusError{http.StatusUnauthorized, err, MessageUnauthorized}
+	}
+	groupName := mux.Vars(request)["group"]
+
+	if !isAdmin(a, userName) {
+		if err := checkGroupMember(a, groupName, userName); err != nil {
+			return err
+		}
+	}
+
+	group, err := getGroup(a, groupName)
+	if err != nil {
+		return err
+	}
+	return writeGroup(response, group)
+}
+
+// AddGroupMemberHandler makes a user a member of a group. The user
+// need not have logged in yet. Only admins manage members.
+func AddGroupMemberHandler(a *appContext, response http.ResponseWriter, request *http.Request) error {
+	if _, err := getAdminName(a, request); err != nil {
+		return err
+	}
+	vars := mux.Vars(request)
+	groupName, userName := vars["group"], vars["user"]
+
+	if _, ok := dal.OwnerGroup(userName); ok {
+		err := fmt.Errorf("%s is the account of a group", userName)
+		return StatusError{http.StatusBadRequest, err, MessageInvalidMember}
+	}
+	if _, err := getGroup(a, groupName); err != nil {
+		return err
+	}
+
+	if _, _, err := putUserIfNotExistedInDB(a, groupName, userName); err != nil {
+		return err
+	}
+	log.Printf("Added %s to group %s", userName, groupName)
+
+	group, err := getGroup(a, groupName)
+	if err != nil {
+		return err
+	}
+	return writeGroup(response, group)
+}
+
+// RemoveGroupMemberHandler removes a user from a group. Removing a user
+// who is not a member succeeds. Only admins manage members.
+func RemoveGroupMemberHandler(a *appContext, response http.ResponseWriter, request *http.Request) error {
+	if _, err := getAdminName(a, request); err != nil {
+		return err
+	}
+	vars := mux.Vars(request)
+	groupName, userName := vars["group"], vars["user"]
+
+	if _, err := getGroup(a, groupName); err != nil {
+		return err
+	}
+
+	removed, err := a.dal.RemoveGroupMember(groupName, userName)
+	if err != nil {
+		return err
+	}
+	if removed > 0 {
+		log.Printf("Removed %s from group %s", userName, groupName)
+	}
+
+	group, err := getGroup(a, groupName)
+	if err != nil {
+		return err
+	}
+	return writeGroup(response, group)
+}
+
+// searchUserGroups searches the groups of a user in LDAP, on a
+// connection bound as the user. Groups whose name is not a valid group
+// name are skipped.
+func searchUserGroups(a *appContext, l *ldap.Conn, userDn string) ([]string, error) {
+	cfg := a.conf.LDAPcfg
+	filter, attribute := cfg.LDAPGroupFilter, cfg.LDAPGroupAttribute
+	if filter == "" {
+		filter = DefaultLDAPGroupFilter
+	}
+	if attribute == "" {
+		attribute = DefaultLDAPGroupAttribute
+	}
+
+	result, err := l.Search(ldap.NewSearchRequest(
+		cfg.LDAPGroupBaseDn, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		fmt.Sprintf(filter, ldap.EscapeFilter(userDn)), []string{attribute}, nil))
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]string, 0, len(result.Entries))
+	for _, entry := range result.Entries {
+		name := entry.GetAttributeValue(attribute)
+		if err := naming.ValidateGroupName(name); err != nil {
+			log.Printf("Skipped LDAP group %s: %v", entry.DN, err)
+			continue
+		}
+		groups = append(groups, name)
+	}
+	return groups, nil
+}
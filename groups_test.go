@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/xuant/go-kexec/dal"
+)
+
+func TestCheckGroupMember(t *testing.T) {
+	a := newTestContext(t)
+
+	tests := []struct {
+		groupName string
+		userName  string
+		status    int
+		message   string
+	}{
+		{"team", "bob", http.StatusOK, ""},
+		{"team", "alice", http.StatusForbidden, MessageNotGroupMember},
+		{"team", "carol", http.StatusForbidden, MessageNotGroupMember},
+		{"missing", "bob", http.StatusNotFound, MessageGroupNotFound},
+	}
+
+	for _, test := range tests {
+		err := checkGroupMember(a, test.groupName, test.userName)
+		if status := statusOf(err); status != test.status {
+			t.Errorf("%s in %s: status %d, want %d (%v)", test.userName, test.groupName, status, test.status, err)
+			continue
+		}
+		if e, ok := err.(Error); ok && e.Message() != test.message {
+			t.Errorf("%s in %s: message %q, want %q", test.userName, test.groupName, e.Message(), test.message)
+		}
+	}
+}
+
+func TestFunctionOwner(t *testing.T) {
+	a := newTestContext(t)
+
+	tests := []struct {
+		target   string
+		userName string
+		owner    string
+		status   int
+	}{
+		{"/functions", "alice", "alice", http.StatusOK},
+		{"/functions?group=", "bob", "bob", http.StatusOK},
+		{"/functions?group=team", "bob", dal.GroupOwner("team"), http.StatusOK},
+		{"/functions?group=team", "alice", "", http.StatusForbidden},
+		{"/functions?group=missing", "bob", "", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		request := newTestRequest(a, "GET", test.target, test.userName, nil)
+		owner, err := functionOwner(a, request, test.userName)
+		if status := statusOf(err); status != test.status {
+			t.Errorf("%s by %s: status %d, want %d (%v)", test.target, test.userName, status, test.status, err)
+			continue
+		}
+		if owner != test.owner {
+			t.Errorf("%s by %s: owner %q, want %q", test.target, test.userName, owner, test.owner)
+		}
+	}
+}
@@ -2,20 +2,29 @@ package main
 
 import (
 	"crypto/tls"
+	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/wayn3h0/go-uuid"
+	"github.com/xuant/go-kexec/bundle"
 	"github.com/xuant/go-kexec/dal"
 	"github.com/xuant/go-kexec/docker"
 	"github.com/xuant/go-kexec/html"
+	"github.com/xuant/go-kexec/kexec"
+	"github.com/xuant/go-kexec/naming"
+	"github.com/xuant/go-kexec/runtimes"
 	"gopkg.in/ldap.v2"
 )
 
@@ -23,13 +32,72 @@ var (
 	MessageCreateFunctionFailed = "Failed to create function"
 
 	MessageCallFunctionFailed = "Failed to call function"
+
+	MessageExecutionNotFound = "Execution not found"
+
+	MessageInvalidFilter = "Invalid execution filter"
+
+	MessageReaperDisabled = "Reaper is disabled"
+
+	MessageLogNotFound = "Log not found, the pods of the execution may be gone"
+
+	MessageInvalidLimits = "Invalid resource limits"
+
+	MessageInvalidFanOut = "Fan-out calls need a JSON array of parameters and a numeric parallelism"
+
+	MessageFanOutTooLarge = "Too many parameters for a fan-out call"
+
+	MessageFanOutNotAsync = "Fan-out calls with that many parameters must be asynchronous, add async=true"
+
+	MessageUnauthorized = "Please log in first"
+
+	MessageInvalidPackage = "Invalid function package"
+
+	MessageBuildNotFound = "Build not found"
+
+	MessageFunctionNotFound = "Function not found"
+
+	MessageVersionNotFound = "Function version not found"
+
+	MessageInvalidVersion = "Invalid function version, use function@N with N a version number"
+
+	MessageInvalidImage = "Invalid image reference"
+
+	MessageDeleteFunctionFailed = "Failed to delete function"
+
+	MessageRegistryNotAllowed = "Images of this registry are not allowed"
+
+	MessageFunctionExists = "Function already exists, submit it as an update to change it"
+
+	MessageGroupNotFound = "Group not found"
+
+	MessageGroupExists = "Group already exists"
+
+	MessageNotGroupMember = "You are not a member of this group"
+
+	MessageAdminOnly = "Only admins can do this"
+
+	MessageInvalidMember = "Invalid group member"
+)
+
+var (
+	// Room for the form fields of a function creation, on top of the
+	// max size of its package
+	MaxFormOverhead int64 = 1 << 20
+
+	// Bytes of a form kept in memory, the rest goes to temporary files
+	MaxFormMemory int64 = 32 << 20
+
+	// Name of the default registry in AllowedRegistries, for images
+	// without registry host
+	DefaultRegistry = "docker.io"
 )
 
 func IndexPageHandler(a *appContext, response http.ResponseWriter, request *http.Request) error {
 	userName := getUserName(a, request)
 	if userName != "" {
 		//Already logged in, show internal page
-		fmt.Fprintf(response, html.InternalPage, userName)
+		fmt.Fprintf(response, html.InternalPage, userName, "", "", "")
 	} else {
 		fmt.Fprintf(response, html.IndexPage)
 	}
@@ -42,7 +110,7 @@ func LoginHandler(a *appContext, response http.ResponseWriter, request *http.Req
 	redirectTarget := "/"
 	if name != "" && pass != "" {
 		// ... check credentials
-		ok, err := checkCredentials(a, name, pass)
+		ok, groups, err := checkCredentials(a, name, pass)
 		if !ok {
 			errMsg := err.Error()
 			// Check if it is a LDAP specific error
@@ -77,6 +145,15 @@ func LoginHandler(a *appContext, response http.ResponseWriter, request *http.Req
 			log.Printf("User %s already in DB.", name)
 		}
 
+		// Groups are synced again on the next login if it fails
+		if groups != nil {
+			if err := a.dal.SyncUserGroups(name, groups); err != nil {
+				log.Printf("Failed to sync the groups of %s: %v", name, err)
+			} else {
+				log.Printf("Synced the groups of %s: %v", name, groups)
+			}
+		}
+
 		setSession(a, name, response)
 		redirectTarget = "/internal"
 	}
@@ -97,7 +174,7 @@ func InternalPageHandler(a *appContext, response http.ResponseWriter, request *h
 	if userName != "" {
 		functions, err := getUserFunctions(a, namespace, userName, -1)
 		if err != nil {
-			fmt.Fprintf(response, html.InternalPage, userName, err)
+			fmt.Fprintf(response, html.InternalPage, userName, err, "", "")
 			return nil
 		}
 
@@ -128,23 +205,83 @@ func CreateFunctionHandler(a *appContext, response http.ResponseWriter, request
 		// Read function code from the form
 		// Before the function can be created, several steps needs to be
 		// executed.
+		//   1. Unpack the function package, if any, into a build context
 		//   2. Create the execution file for the function
 		//   3. Write the function code to the execution file
-		//   4. Build the function (ie build docker image)
+		//   4. Queue the build of the function (ie build docker image)
+		packageLimits := a.conf.Packages.WithDefaults(bundle.DefaultLimits)
+		request.Body = http.MaxBytesReader(response, request.Body, packageLimits.MaxSize+MaxFormOverhead)
+		if err := request.ParseMultipartForm(MaxFormMemory); err != nil && err != http.ErrNotMultipart {
+			return StatusError{http.StatusBadRequest, err, MessageInvalidPackage + ": " + err.Error()}
+		}
+
+		// Members of a group create and update the functions of the
+		// group, under its account
+		owner, err := functionOwner(a, request, userName)
+		if err != nil {
+			return err
+		}
+
 		functionName := request.FormValue("functionName")
 		runtime := request.FormValue("runtime")
 		code := request.FormValue("codeTextarea")
 
+		// Functions can also run an image built elsewhere, eg by the
+		// CI of a team, instead of code
+		imageRef := request.FormValue("image")
+
+		// The code can come in a package, along with helper modules
+		// and dependency manifests
+		pkg, _, err := request.FormFile("package")
+		if err != nil && err != http.ErrMissingFile {
+			return StatusError{http.StatusBadRequest, err, MessageInvalidPackage + ": " + err.Error()}
+		}
+		if pkg != nil {
+			defer pkg.Close()
+		}
+
 		// Check if function name is empty;
 		// check if runtime template is chosen;
 		// check if the input code is empty.
-		if functionName == "" || runtime == "" || code == "" {
+		if functionName == "" || (imageRef == "" && (runtime == "" || (code == "" && pkg == nil))) {
 			err := errors.New("Something's wrong with FunctionName/Runtime/Code.")
 			return StatusError{http.StatusFound, err, MessageCreateFunctionFailed}
 		}
 
-		newCode := formatCode(code, functionName)
-		log.Printf("Code uploaded:\n%s", newCode)
+		// Check the function name, which is used in the names of
+		// the function image and jobs
+		if err := naming.ValidateFunctionName(functionName); err != nil {
+			return StatusError{http.StatusBadRequest, err, err.Error()}
+		}
+
+		// Check the resource limits before spending time on the build
+		limits, err := functionLimitsFromForm(a, request)
+		if err != nil {
+			return StatusError{http.StatusBadRequest, err, MessageInvalidLimits + ": " + err.Error()}
+		}
+
+		// Submitting an existing function updates it, only when asked to
+		update := request.FormValue("update") == "true"
+		_, err = a.dal.GetFunction(owner, functionName)
+		switch {
+		case err == nil && !update:
+			return StatusError{http.StatusConflict, errFunctionExists, MessageFunctionExists}
+		case err == sql.ErrNoRows && update:
+			return StatusError{http.StatusNotFound, err, MessageFunctionNotFound}
+		case err != nil && err != sql.ErrNoRows:
+			return StatusError{http.StatusFound, err, MessageCreateFunctionFailed}
+		}
+
+		if imageRef != "" {
+			return registerFunctionImage(a, response, owner, functionName, imageRef, limits, update)
+		}
+
+		// Check the runtime
+		rt, ok := a.runtimes.Get(runtime)
+		if !ok {
+			err := fmt.Errorf("Runtime %s invalid or not supported yet.", runtime)
+			return StatusError{http.StatusBadRequest, err, err.Error()}
+		}
 		log.Printf("Start creating function \"%s\" with runtime \"%s\"", functionName, runtime)
 
 		// Create a time based uuid as part of the context directory name
@@ -156,7 +293,7 @@ func CreateFunctionHandler(a *appContext, response http.ResponseWriter, request
 		}
 
 		uuidStr := uuid.String()
-		userCtx := userName + "-" + uuidStr
+		userCtx := owner + "-" + uuidStr
 
 		// Create the execution file for the function
 		ctxDir := filepath.Join(docker.IBContext, userCtx)
@@ -165,47 +302,88 @@ func CreateFunctionHandler(a *appContext, response http.ResponseWriter, request
 			return StatusError{http.StatusFound, err, MessageCreateFunctionFailed}
 		}
 
-		exeFileName := filepath.Join(ctxDir, docker.ExecutionFile)
-		exeFile, err := os.Create(exeFileName)
+		// The context is removed by its build once submitted, and here
+		// if anything fails before
+		submitted := false
+		defer func() {
+			if !submitted {
+				os.RemoveAll(ctxDir)
+			}
+		}()
 
+		if pkg != nil {
+			if code, err = unpackFunctionPackage(pkg, ctxDir, rt, code, packageLimits); err != nil {
+				return StatusError{http.StatusBadRequest, err, MessageInvalidPackage + ": " + err.Error()}
+			}
+		}
+
+		newCode, err := rt.WrapCode(code, functionName)
 		if err != nil {
 			return StatusError{http.StatusFound, err, MessageCreateFunctionFailed}
 		}
-		defer exeFile.Close()
+		log.Printf("Code uploaded:\n%s", newCode)
 
-		// Write the function into the execution file
-		if _, err = exeFile.WriteString(newCode); err != nil {
+		exeFileName := filepath.Join(ctxDir, rt.FileName)
+		exeFile, err := os.Create(exeFileName)
+
+		if err != nil {
 			return StatusError{http.StatusFound, err, MessageCreateFunctionFailed}
 		}
 
-		// Build funtion
-		if err = a.d.BuildFunction(a.conf.DockerRegistry, userName, functionName, runtime, ctxDir); err != nil {
-			log.Println("Build function failed")
+		// Write the function into the execution file, and close it
+		// before the context is hashed and built
+		_, err = exeFile.WriteString(newCode)
+		if closeErr := exeFile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
 			return StatusError{http.StatusFound, err, MessageCreateFunctionFailed}
 		}
 
-		// Register function to configured docker registry
-		if err = docker.RegisterFunction(a.conf.DockerRegistry, userName, functionName); err != nil {
-			log.Println("Register function failed")
+		// Every build is a new version of the function, its image
+		// tagged by the hash of its sources
+		hash, err := sourceHash(ctxDir, rt.Name)
+		if err != nil {
 			return StatusError{http.StatusFound, err, MessageCreateFunctionFailed}
 		}
+		image := versionImage(a, owner, functionName, hash)
 
-		// Put function into db
-		if err = putUserFunction(a, userName, functionName, newCode, -1); err != nil {
-			log.Println("Failed to put function into DB")
+		// Build funtion and push it to configured docker registry in
+		// the background. The function is created once its build is
+		// ready.
+		build, err := a.builds.submit(owner, functionName, &buildSpec{
+			Runtime:    rt.Name,
+			ContextDir: ctxDir,
+			Image:      image,
+			CodeHash:   hash,
+			Content:    newCode,
+			Limits:     limits,
+			Update:     update,
+		})
+		if build != nil {
+			response.Header().Set("X-Build-Id", strconv.FormatInt(build.ID, 10))
+		}
+		if err == errBuildQueueFull {
+			return StatusError{http.StatusServiceUnavailable, err, MessageCreateFunctionFailed + ": " + err.Error()}
+		}
+		if err != nil {
 			return StatusError{http.StatusFound, err, MessageCreateFunctionFailed}
 		}
+		submitted = true
 
-		// If all the above operation succeeded, the function is created
-		// successfully.
-		fmt.Fprintf(response, html.FunctionCreatedPage)
+		response.Header().Set("Location", buildPath(build))
+		response.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(response, html.FunctionSubmittedPage, build.ID, buildLogPath(build), buildPath(build))
 	}
 	return nil
 }
 
 func CallHandler(a *appContext, response http.ResponseWriter, request *http.Request) error {
 	userName := getUserName(a, request)
-	functionName := getFunctionName(request)
+	functionName, version, err := parseFunctionVersion(getFunctionName(request))
+	if err != nil {
+		return StatusError{http.StatusBadRequest, err, MessageInvalidVersion}
+	}
 	params := request.FormValue("params")
 
 	if userName == "" || functionName == "" {
@@ -214,9 +392,11 @@ func CallHandler(a *appContext, response http.ResponseWriter, request *http.Requ
 		http.Redirect(response, request, "/", http.StatusFound)
 
 	} else {
-		if _, _, err := callFunction(a, userName, functionName, params); err != nil {
-			return StatusError{http.StatusFound, err, MessageCallFunctionFailed}
+		exe, err := callFunction(a, userName, functionName, version, params)
+		if err != nil {
+			return callFunctionError(err)
 		}
+		go waitForExecution(a, exe)
 
 		fmt.Fprintf(response, html.FunctionCalledPage)
 	}
@@ -226,7 +406,33 @@ func CallHandler(a *appContext, response http.ResponseWriter, request *http.Requ
 func CallFunctionHandler(a *appContext, response http.ResponseWriter, request *http.Request) error {
 	vars := mux.Vars(request)
 	userName := vars["username"]
-	functionName := vars["function"]
+
+	// Functions of groups are only called through the group route,
+	// which checks membership
+	if _, ok := dal.OwnerGroup(userName); ok {
+		err := fmt.Errorf("%s is the account of a group", userName)
+		return StatusError{http.StatusNotFound, err, MessageFunctionNotFound}
+	}
+
+	// Only the members of a group call its functions
+	if groupName, ok := vars["group"]; ok {
+		caller := getUserName(a, request)
+		if caller == "" {
+			err := errors.New("Not logged in")
+			return StatusError{http.StatusUnauthorized, err, MessageUnauthorized}
+		}
+		if err := checkGroupMember(a, groupName, caller); err != nil {
+			return err
+		}
+		userName = dal.GroupOwner(groupName)
+	}
+
+	// `function@version` calls a given version of the function,
+	// `function` its default version
+	functionName, version, err := parseFunctionVersion(vars["function"])
+	if err != nil {
+		return StatusError{http.StatusBadRequest, err, MessageInvalidVersion}
+	}
 
 	// Get function parameters from request body
 	params, err := ioutil.ReadAll(request.Body)
@@ -240,57 +446,673 @@ func CallFunctionHandler(a *appContext, response http.ResponseWriter, request *h
 		log.Println("Calling function", functionName, "with parameters", paramsStr)
 	}
 
+	// For a fan-out call, the body is an array of parameters. The
+	// function is called once per element.
+	query := request.URL.Query()
+	if query.Get("fanout") == "true" {
+		items, err := parseFanOutParams(params)
+		if err != nil {
+			return StatusError{http.StatusBadRequest, err, MessageInvalidFanOut}
+		}
+
+		requested := 0
+		if p := query.Get("parallelism"); p != "" {
+			if requested, err = strconv.Atoi(p); err != nil {
+				return StatusError{http.StatusBadRequest, err, MessageInvalidFanOut}
+			}
+		}
+
+		async := query.Get("async") == "true"
+		if err := checkFanOutSize(a, len(items), async); err != nil {
+			return err
+		}
+
+		ids := make([]string, len(items))
+		for i := range ids {
+			if ids[i], err = newExecutionID(); err != nil {
+				return StatusError{http.StatusInternalServerError, err, MessageCallFunctionFailed}
+			}
+		}
+		parallelism := fanOutParallelism(a, requested)
+
+		// Like a single asynchronous call, return the execution ids
+		// right away. Executions waiting for a slot are not found until
+		// they start.
+		if async {
+			go callFunctionFanOut(a, userName, functionName, version, ids, items, parallelism)
+
+			executions := make([]map[string]interface{}, len(ids))
+			for i, id := range ids {
+				executions[i] = map[string]interface{}{
+					"index":  i,
+					"id":     id,
+					"status": "/executions/" + id,
+				}
+			}
+			response.Header().Set("Content-Type", "application/json")
+			response.WriteHeader(http.StatusAccepted)
+			return json.NewEncoder(response).Encode(executions)
+		}
+
+		results := callFunctionFanOut(a, userName, functionName, version, ids, items, parallelism)
+
+		response.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(response).Encode(results)
+	}
+
 	// Call function. This will create a job in OpenShift
-	jobName, nsName, err := callFunction(a, userName, functionName, paramsStr)
+	exe, err := callFunction(a, userName, functionName, version, paramsStr)
 	if err != nil {
-		return StatusError{http.StatusFound, err, MessageCallFunctionFailed}
+		return callFunctionError(err)
 	}
 
-	// Wait for job to complete
-	if err := a.k.WaitForPodComplete(jobName, nsName); err != nil {
-		return StatusError{http.StatusFound, err, MessageCallFunctionFailed}
+	// For an asynchronous call, return the execution id right away
+	// and let the client poll the executions endpoint.
+	if query.Get("async") == "true" {
+		go waitForExecution(a, exe)
+
+		response.Header().Set("Content-Type", "application/json")
+		response.WriteHeader(http.StatusAccepted)
+		return json.NewEncoder(response).Encode(map[string]string{
+			"id":     exe.ID,
+			"status": "/executions/" + exe.ID,
+		})
 	}
 
-	funcLog, err := a.k.GetFunctionLog(jobName, nsName)
+	// Stream the function output while it runs. The execution is
+	// still tracked in the background.
+	if wantsStream(request) {
+		go waitForExecution(a, exe)
+
+		r, err := a.k.StreamFunctionLog(exe.jobName, exe.namespace, query.Get("timestamps") == "true", StreamStartTimeout)
+		if err != nil {
+			return StatusError{http.StatusFound, err, MessageCallFunctionFailed}
+		}
+		return streamLog(response, request, exe.ID, r)
+	}
+
+	// Wait for job to complete and answer with what the handler of
+	// the function returned. The log of the execution can be read from
+	// the executions endpoint.
+	response.Header().Set("X-Execution-Id", exe.ID)
+	result := waitForExecution(a, exe)
+	if result.FunctionError != nil {
+		response.Header().Set("Content-Type", "application/json")
+		response.WriteHeader(http.StatusInternalServerError)
+		return json.NewEncoder(response).Encode(map[string]*functionError{
+			"error": result.FunctionError,
+		})
+	}
+	if result.Phase == dal.ExecutionFailed {
+		code, msg := failureStatus(result)
+		return StatusError{code, errors.New(result.Error), msg}
+	}
+
+	// Functions built before handlers existed only have a log
+	if result.Result == nil {
+		fmt.Fprint(response, result.Log)
+		return nil
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	_, err = response.Write(result.Result)
+	return err
+}
+
+// GetExecutionHandler reports the phase, the timestamps and, once the
+// function finished, the log of an execution.
+//
+// The log covers all the pods of the execution. The query parameter
+// `pod` selects the log of a single pod and `attempt=last` the log of
+// the last attempt; these are read from the cluster, as long as the
+// pods of the execution are still there.
+func GetExecutionHandler(a *appContext, response http.ResponseWriter, request *http.Request) error {
+	exe, err := getUserExecution(a, request)
 	if err != nil {
-		return StatusError{http.StatusFound, err, MessageCallFunctionFailed}
+		return err
+	}
+	refreshExecution(a, exe)
+
+	query := request.URL.Query()
+	if exe.finished() && (query.Get("pod") != "" || query.Get("attempt") == "last") {
+		opts := &kexec.LogOptions{
+			PodName:     query.Get("pod"),
+			LastAttempt: query.Get("attempt") == "last",
+		}
+		funcLog, err := a.k.GetFunctionLog(exe.jobName, exe.namespace, opts)
+		if err != nil {
+			return StatusError{http.StatusNotFound, err, MessageLogNotFound}
+		}
+		exe.Log = string(funcLog)
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(response).Encode(exe)
+}
+
+// GetExecutionLogsHandler streams the log of an execution of the logged
+// in user, as it is written if the function is still running. With
+// `timestamps=true`, every line is prefixed by its timestamp; this needs
+// the pods of the execution to still be there. See streamLog for the
+// formats.
+func GetExecutionLogsHandler(a *appContext, response http.ResponseWriter, request *http.Request) error {
+	exe, err := getUserExecution(a, request)
+	if err != nil {
+		return err
+	}
+
+	storedLog := ioutil.NopCloser(strings.NewReader(exe.Log))
+	timestamps := request.URL.Query().Get("timestamps") == "true"
+	if exe.finished() && !timestamps {
+		return streamLog(response, request, exe.ID, storedLog)
+	}
+
+	r, err := a.k.StreamFunctionLog(exe.jobName, exe.namespace, timestamps, StreamStartTimeout)
+	if err != nil {
+		if exe.finished() {
+			return streamLog(response, request, exe.ID, storedLog)
+		}
+		return StatusError{http.StatusNotFound, err, MessageLogNotFound}
+	}
+	return streamLog(response, request, exe.ID, r)
+}
+
+// ReaperStatsHandler reports how many jobs and pods the reaper deleted.
+func ReaperStatsHandler(a *appContext, response http.ResponseWriter, request *http.Request) error {
+	if a.reaper == nil {
+		err := errors.New("Reaper disabled")
+		return StatusError{http.StatusNotFound, err, MessageReaperDisabled}
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(response).Encode(a.reaper.Stats())
+}
+
+// ListRuntimesHandler lists the runtimes functions can be written for.
+func ListRuntimesHandler(a *appContext, response http.ResponseWriter, request *http.Request) error {
+	type runtimeInfo struct {
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+		BaseImage   string `json:"baseImage"`
+		EditorMode  string `json:"editorMode"`
+		FileName    string `json:"fileName"`
+	}
+
+	list := make([]runtimeInfo, 0)
+	for _, rt := range a.runtimes.List() {
+		list = append(list, runtimeInfo{
+			Name:        rt.Name,
+			DisplayName: rt.DisplayName,
+			BaseImage:   rt.BaseImage,
+			EditorMode:  rt.EditorMode,
+			FileName:    rt.FileName,
+		})
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(response).Encode(list)
+}
+
+// ListExecutionsHandler lists the executions of a function owned by the
+// logged in user, or by the group `group` the user is a member of. The
+// executions can be filtered by status and creation time (RFC3339) with
+// the query parameters `status`, `since`, `until` and `limit`.
+func ListExecutionsHandler(a *appContext, response http.ResponseWriter, request *http.Request) error {
+	userName := getUserName(a, request)
+	if userName == "" {
+		err := errors.New("Not logged in")
+		return StatusError{http.StatusUnauthorized, err, MessageUnauthorized}
+	}
+
+	// Functions of a group are those of its account
+	owner, err := functionOwner(a, request, userName)
+	if err != nil {
+		return err
+	}
+
+	functionName := mux.Vars(request)["function"]
+
+	query := request.URL.Query()
+	filter := &dal.ExecutionFilter{
+		Status: query.Get("status"),
+	}
+
+	if since := query.Get("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return StatusError{http.StatusBadRequest, err, MessageInvalidFilter}
+		}
+	}
+	if until := query.Get("until"); until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return StatusError{http.StatusBadRequest, err, MessageInvalidFilter}
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil {
+			return StatusError{http.StatusBadRequest, err, MessageInvalidFilter}
+		}
+	}
+
+	records, err := a.dal.ListExecutionsOfFunction(owner, functionName, filter)
+	if err != nil {
+		return err
+	}
+
+	executions := make([]*execution, 0, len(records))
+	for _, record := range records {
+		executions = append(executions, executionFromRecord(record))
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(response).Encode(executions)
+}
+
+// GetBuildHandler reports the status of a build of one of the functions
+// of the logged in user and, once it is ready, the version it made.
+func GetBuildHandler(a *appContext, response http.ResponseWriter, request *http.Request) error {
+	build, err := getUserBuild(a, request)
+	if err != nil {
+		return err
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(response).Encode(buildInfoFromRecord(build))
+}
+
+// GetBuildLogHandler returns the log of a build of one of the functions
+// of the logged in user, failed builds included.
+func GetBuildLogHandler(a *appContext, response http.ResponseWriter, request *http.Request) error {
+	build, err := getUserBuild(a, request)
+	if err != nil {
+		return err
+	}
+
+	response.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	response.Header().Set("X-Build-Status", build.Status)
+	_, err = io.WriteString(response, build.Log)
+	return err
+}
+
+// ListVersionsHandler lists the versions of a function of the logged in
+// user, latest first, along with its default version.
+func ListVersionsHandler(a *appContext, response http.ResponseWriter, request *http.Request) error {
+	userName := getUserName(a, request)
+	if userName == "" {
+		err := errors.New("Not logged in")
+		return StatusError{http.StatusUnauthorized, err, MessageUnauthorized}
+	}
+
+	// Functions of a group are those of its account
+	owner, err := functionOwner(a, request, userName)
+	if err != nil {
+		return err
+	}
+
+	functionName := mux.Vars(request)["function"]
+
+	function, err := a.dal.GetFunction(owner, functionName)
+	if err == sql.ErrNoRows {
+		return StatusError{http.StatusNotFound, err, MessageFunctionNotFound}
+	}
+	if err != nil {
+		return err
+	}
+
+	versions, err := a.dal.ListVersions(owner, functionName)
+	if err != nil {
+		return err
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(response).Encode(versionList{
+		Function:       functionName,
+		DefaultVersion: function.DefaultVersion,
+		Versions:       versionsFromRecords(versions),
+	})
+}
+
+// DeleteFunctionHandler deletes a function of the logged in user, with
+// its executions, versions, builds, jobs and images. Deleting a function
+// again, or one that does not exist, succeeds without deleting anything.
+func DeleteFunctionHandler(a *appContext, response http.ResponseWriter, request *http.Request) error {
+	userName := getUserName(a, request)
+	if userName == "" {
+		err := errors.New("Not logged in")
+		return StatusError{http.StatusUnauthorized, err, MessageUnauthorized}
+	}
+
+	// Functions of a group are those of its account
+	owner, err := functionOwner(a, request, userName)
+	if err != nil {
+		return err
+	}
+
+	d, err := deleteFunction(a, owner, mux.Vars(request)["function"])
+	if err != nil {
+		return StatusError{http.StatusInternalServerError, err, MessageDeleteFunctionFailed + ": " + err.Error()}
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(response).Encode(d)
+}
+
+// RollbackFunctionHandler makes another version the default version
+// of a function of the logged in user. The version is given by the
+// form value `version`; without it, the function goes back to the
+// version before its current default version. The image of the version
+// is not rebuilt.
+func RollbackFunctionHandler(a *appContext, response http.ResponseWriter, request *http.Request) error {
+	userName := getUserName(a, request)
+	if userName == "" {
+		err := errors.New("Not logged in")
+		return StatusError{http.StatusUnauthorized, err, MessageUnauthorized}
 	}
-	log.Printf("Function Log:\n %s", string(funcLog))
 
-	// Write to response
-	fmt.Fprintf(response, string(funcLog))
+	// Functions of a group are those of its account
+	owner, err := functionOwner(a, request, userName)
+	if err != nil {
+		return err
+	}
+
+	functionName := mux.Vars(request)["function"]
+
+	// The function cannot be deleted, or get a new version, meanwhile
+	unlock := a.functionLocks.lock(owner, functionName)
+	defer unlock()
+
+	function, err := a.dal.GetFunction(owner, functionName)
+	if err == sql.ErrNoRows {
+		return StatusError{http.StatusNotFound, err, MessageFunctionNotFound}
+	}
+	if err != nil {
+		return err
+	}
+
+	// Version 0 is the content of a function from before versions
+	target := -1
+	if v := request.FormValue("version"); v != "" {
+		if target, err = strconv.Atoi(v); err != nil || target < 0 {
+			err := fmt.Errorf("Invalid version %q", v)
+			return StatusError{http.StatusBadRequest, err, MessageInvalidVersion}
+		}
+	} else {
+		versions, err := a.dal.ListVersions(owner, functionName)
+		if err != nil {
+			return err
+		}
+		for _, v := range versions {
+			if v.Version < function.DefaultVersion {
+				target = v.Version
+				break
+			}
+		}
+		if target < 0 {
+			err := fmt.Errorf("Function %s has no version before version %d", functionName, function.DefaultVersion)
+			return StatusError{http.StatusNotFound, err, MessageVersionNotFound}
+		}
+	}
+
+	version, err := getVersion(a, owner, functionName, target)
+	if err == errVersionNotFound {
+		return StatusError{http.StatusNotFound, err, MessageVersionNotFound}
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := a.dal.SetDefaultVersion(owner, functionName, version.Version); err != nil {
+		return err
+	}
+	log.Printf("Function %s of %s rolled back from version %d to version %d",
+		functionName, owner, function.DefaultVersion, version.Version)
+
+	response.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(response).Encode(versionList{
+		Function:       functionName,
+		DefaultVersion: version.Version,
+		Versions:       versionsFromRecords([]*dal.Version{version}),
+	})
+}
+
+// callFunctionError maps the errors of callFunction to responses.
+func callFunctionError(err error) error {
+	switch err {
+	case sql.ErrNoRows:
+		return StatusError{http.StatusNotFound, err, MessageFunctionNotFound}
+	case errVersionNotFound:
+		return StatusError{http.StatusNotFound, err, MessageVersionNotFound}
+	}
+	return StatusError{http.StatusFound, err, MessageCallFunctionFailed}
+}
+
+// registerFunctionImage creates a function running an existing image,
+// without building anything. The image must be in one of the allowed
+// registries. It gets its parameters in SERVERLESS_PARAMS like any
+// function.
+func registerFunctionImage(a *appContext, response http.ResponseWriter, userName, functionName, imageRef string, limits kexec.ResourceLimits, update bool) error {
+	ref, err := docker.ParseImageReference(imageRef)
+	if err != nil {
+		return StatusError{http.StatusBadRequest, err, MessageInvalidImage + ": " + err.Error()}
+	}
+	if !registryAllowed(a, ref.Registry) {
+		err := fmt.Errorf("Registry of image %s is not allowed", imageRef)
+		return StatusError{http.StatusForbidden, err, MessageRegistryNotAllowed}
+	}
+	log.Printf("Registering function \"%s\" with image %s", functionName, imageRef)
+
+	version := &dal.Version{
+		FunctionName: functionName,
+		Image:        imageRef,
+		ImageDigest:  ref.Digest,
+	}
+	unlock := a.functionLocks.lock(userName, functionName)
+	err = putFunctionVersion(a, userName, version, limits, update)
+	unlock()
+	if err == errFunctionExists {
+		return StatusError{http.StatusConflict, err, MessageFunctionExists}
+	}
+	if err != nil {
+		return StatusError{http.StatusFound, err, MessageCreateFunctionFailed}
+	}
+
+	response.Header().Set("X-Function-Version", strconv.Itoa(version.Version))
+	if update {
+		response.WriteHeader(http.StatusOK)
+	} else {
+		response.WriteHeader(http.StatusCreated)
+	}
+	fmt.Fprintf(response, html.FunctionRegisteredPage, version.Version)
 	return nil
 }
 
-func callFunction(a *appContext, userName, functionName, params string) (string, string, error) {
-	// create a uuid for each function call. This uuid can be
-	// seen as the execution id for the function (notice there
-	// are multiple executions for a single function)
-	uuid, err := uuid.NewTimeBased()
+// registryAllowed tells whether functions may run images of a registry,
+// "" being the default registry. The registry functions are pushed to
+// is always allowed.
+func registryAllowed(a *appContext, registry string) bool {
+	if registry == a.conf.DockerRegistry {
+		return true
+	}
+	for _, allowed := range a.conf.AllowedRegistries {
+		if allowed == registry || (registry == "" && allowed == DefaultRegistry) {
+			return true
+		}
+	}
+	return false
+}
+
+// getUserExecution gets the execution `id` of the request, which must be
+// an execution of a function of the logged in user, or of one of the
+// groups of the user.
+func getUserExecution(a *appContext, request *http.Request) (*execution, error) {
+	userName := getUserName(a, request)
+	if userName == "" {
+		err := errors.New("Not logged in")
+		return nil, StatusError{http.StatusUnauthorized, err, MessageUnauthorized}
+	}
+	id := mux.Vars(request)["id"]
+
+	exe, err := getExecution(a, id)
+	if err == sql.ErrNoRows {
+		return nil, StatusError{http.StatusNotFound, err, MessageExecutionNotFound}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Executions of other users are not found either
+	owner := exe.UserName
+	if groupName, ok := dal.OwnerGroup(owner); ok {
+		member, err := a.dal.IsGroupMember(groupName, userName)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		if member {
+			owner = userName
+		}
+	}
+	if owner != userName {
+		err := fmt.Errorf("Execution %s is not an execution of %s", id, userName)
+		return nil, StatusError{http.StatusNotFound, err, MessageExecutionNotFound}
+	}
+	return exe, nil
+}
 
+// getUserBuild gets the build `id` of the request, which must be a build
+// of the logged in user, or of the group `group` of the request, and of
+// the function `function` if the route has one.
+func getUserBuild(a *appContext, request *http.Request) (*dal.Build, error) {
+	userName := getUserName(a, request)
+	if userName == "" {
+		err := errors.New("Not logged in")
+		return nil, StatusError{http.StatusUnauthorized, err, MessageUnauthorized}
+	}
+	owner, err := functionOwner(a, request, userName)
+	if err != nil {
+		return nil, err
+	}
+	vars := mux.Vars(request)
+
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		return nil, StatusError{http.StatusNotFound, err, MessageBuildNotFound}
+	}
+
+	build, err := a.dal.GetBuild(id)
+	if err == sql.ErrNoRows {
+		return nil, StatusError{http.StatusNotFound, err, MessageBuildNotFound}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Builds of other users are not found either
+	functionName, ok := vars["function"]
+	if build.UserName != owner || (ok && build.FunctionName != functionName) {
+		err := fmt.Errorf("Build %d is not a build of %s/%s", id, owner, functionName)
+		return nil, StatusError{http.StatusNotFound, err, MessageBuildNotFound}
+	}
+	return build, nil
+}
+
+// callFunction runs a version of a function, or its default version if
+// `version` is 0.
+func callFunction(a *appContext, userName, functionName string, version int, params string) (*execution, error) {
+	uuidStr, err := newExecutionID()
 	if err != nil {
 		log.Println("Failed to create uuid for function call.")
-		return "", "", err
+		return nil, err
+	}
+	return startExecution(a, uuidStr, userName, functionName, version, params)
+}
+
+// newExecutionID creates a uuid for a function call. This uuid can be
+// seen as the execution id for the function (notice there are multiple
+// executions for a single function)
+func newExecutionID() (string, error) {
+	id, err := uuid.NewTimeBased()
+	if err != nil {
+		return "", err
 	}
+	return id.String(), nil
+}
 
-	uuidStr := uuid.String() // uuidStr needed when fetching log
+// startExecution runs a function like callFunction, as the execution
+// `uuidStr`.
+func startExecution(a *appContext, uuidStr, userName, functionName string, version int, params string) (*execution, error) {
+	function, err := a.dal.GetFunction(userName, functionName)
+	if err != nil {
+		log.Println("Failed to get function from DB", functionName)
+		return nil, err
+	}
+
+	if version == 0 {
+		version = function.DefaultVersion
+	}
+	var functionVersion *dal.Version
+	if version > 0 {
+		if functionVersion, err = getVersion(a, userName, functionName, version); err != nil {
+			log.Printf("Failed to get version %d of function %s from DB", version, functionName)
+			return nil, err
+		}
+	}
 
 	// Create a namespace for the user and run the job
 	// in that namespace
-	nsName := strings.Replace(userName, "_", "-", -1) + "-serverless"
-	if _, err := a.k.CreateUserNamespaceIfNotExist(nsName); err != nil {
+	nsName := naming.Namespace(userName)
+	if err := a.k.EnsureUserNamespace(nsName); err != nil {
 		log.Println("Failed to get/create user namespace", nsName)
-		return "", "", err
+		return nil, err
+	}
+	jobName := naming.JobName(functionName, uuidStr)
+	image := runImage(a, userName, functionName, functionVersion)
+	labels := map[string]string{
+		kexec.LabelFunction:  naming.Label(functionName),
+		kexec.LabelExecution: uuidStr,
+	}
+
+	exe := &execution{
+		ID:           uuidStr,
+		UserName:     userName,
+		FunctionName: functionName,
+		Version:      version,
+		Phase:        dal.ExecutionPending,
+		Created:      time.Now(),
+		jobName:      jobName,
+		namespace:    nsName,
+	}
+
+	// Record the execution before submitting the job, so every job
+	// has its execution in DB.
+	record := &dal.FunctionExecution{
+		UUID:      uuidStr,
+		JobName:   jobName,
+		Namespace: nsName,
+		Params:    params,
+		Status:    dal.ExecutionPending,
+		Version:   version,
+		Timestamp: exe.Created,
+	}
+	if _, _, err = a.dal.PutExecution(userName, functionName, record); err != nil {
+		log.Println("Failed to put execution into DB", uuidStr)
+		return nil, err
+	}
+
+	limits := functionLimits(a, function)
+	exe.timeout = executionTimeout(a, limits)
+
+	env := map[string]string{
+		runtimes.ExecutionIDEnv: uuidStr,
+		runtimes.FunctionEnv:    functionName,
 	}
-	jobName := functionName + "-" + uuidStr
-	image := a.conf.DockerRegistry + "/" + userName + "/" + functionName
-	labels := make(map[string]string)
 
-	if err = a.k.CreateFunctionJob(jobName, image, params, nsName, labels); err != nil {
+	if err = a.k.CreateFunctionJob(jobName, image, params, nsName, labels, env, limits); err != nil {
 		log.Println("Failed to call function", functionName)
-		return "", "", err
+		finishExecution(a, exe, nil, "", err)
+		return nil, err
 	}
-	return jobName, nsName, nil
+	a.executions.add(exe)
+
+	return exe, nil
 }
 
 func setSession(a *appContext, userName string, response http.ResponseWriter) {
@@ -339,15 +1161,92 @@ func getUserFunctions(a *appContext, namespace, username string, userId int64) (
 	return a.dal.ListFunctionsOfUser(namespace, username, userId)
 }
 
-func putUserFunction(a *appContext, username, funcName, funcContent string, userId int64) error {
-	_, _, err := a.dal.PutFunctionIfNotExisted(username, funcName, funcContent, -1)
-	return err
+// unpackFunctionPackage unpacks a function package into a build
+// context and returns the code of the function handler: `code` if it
+// is set, the handler file of the package otherwise. The handler file
+// is taken out of the context, since the runtime wraps the code into
+// its own execution file.
+func unpackFunctionPackage(pkg io.Reader, ctxDir string, rt *runtimes.Runtime, code string, limits bundle.Limits) (string, error) {
+	n, err := bundle.Unpack(pkg, ctxDir, limits)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Unpacked %d files into %s", n, ctxDir)
+
+	for _, name := range rt.ReservedFiles() {
+		if _, err := os.Stat(filepath.Join(ctxDir, name)); err == nil {
+			return "", fmt.Errorf("%s is reserved by the %s runtime", name, rt.Name)
+		}
+	}
+
+	if code != "" {
+		return code, nil
+	}
+	if rt.HandlerFile == "" {
+		return "", fmt.Errorf("The %s runtime needs the code of the handler", rt.Name)
+	}
+
+	handlerFile := filepath.Join(ctxDir, rt.HandlerFile)
+	content, err := ioutil.ReadFile(handlerFile)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("%s not found in package", rt.HandlerFile)
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(content), os.Remove(handlerFile)
 }
 
-func checkCredentials(a *appContext, name string, pass string) (bool, error) {
+// functionLimitsFromForm reads the resource limits of a function from
+// the create form. The limits are returned as given, after checking
+// them, completed with the cluster defaults, against the cluster
+// maximums.
+func functionLimitsFromForm(a *appContext, request *http.Request) (kexec.ResourceLimits, error) {
+	limits := kexec.ResourceLimits{
+		CPURequest:    request.FormValue("cpuRequest"),
+		CPULimit:      request.FormValue("cpuLimit"),
+		MemoryRequest: request.FormValue("memoryRequest"),
+		MemoryLimit:   request.FormValue("memoryLimit"),
+	}
+
+	if maxRuntime := request.FormValue("maxRuntime"); maxRuntime != "" {
+		seconds, err := strconv.ParseInt(maxRuntime, 10, 64)
+		if err != nil {
+			return limits, fmt.Errorf("Invalid max runtime %q", maxRuntime)
+		}
+		limits.MaxRuntime = seconds
+	}
+
+	if err := limits.WithDefaults(a.conf.Limits.Default).Validate(a.conf.Limits.Max); err != nil {
+		return limits, err
+	}
+	return limits, nil
+}
+
+// functionLimits returns the resource limits to run a function with:
+// its own limits completed with the cluster defaults.
+func functionLimits(a *appContext, function *dal.Function) *kexec.ResourceLimits {
+	limits := kexec.ResourceLimits{
+		CPURequest:    function.CPURequest,
+		CPULimit:      function.CPULimit,
+		MemoryRequest: function.MemoryRequest,
+		MemoryLimit:   function.MemoryLimit,
+		MaxRuntime:    function.MaxRuntime,
+	}.WithDefaults(a.conf.Limits.Default)
+	return &limits
+}
+
+// checkCredentials binds to LDAP as a user. When groups are synced from
+// LDAP, it returns the groups of the user as well.
+func checkCredentials(a *appContext, name string, pass string) (bool, []string, error) {
 	var l *ldap.Conn
 	var err error
 
+	// Accounts of groups cannot log in
+	if _, ok := dal.OwnerGroup(name); ok {
+		return false, nil, fmt.Errorf("Invalid user name %s", name)
+	}
+
 	servers := a.conf.LDAPcfg.LDAPServer
 	port := a.conf.LDAPcfg.LDAPPort
 	retries := a.conf.LDAPcfg.LDAPRetries
@@ -373,7 +1272,7 @@ func checkCredentials(a *appContext, name string, pass string) (bool, error) {
 
 	if err != nil {
 		log.Println(err)
-		return false, err
+		return false, nil, err
 	}
 	defer l.Close()
 
@@ -381,16 +1280,17 @@ func checkCredentials(a *appContext, name string, pass string) (bool, error) {
 	err = l.Bind(username, pass)
 	if err != nil {
 		log.Println(err)
-		return false, err
+		return false, nil, err
 	}
 	log.Printf("Bound user %s\n", name)
-	return true, nil
-}
 
-// Add imports and the remaining code
-func formatCode(code, functionName string) string {
-	return fmt.Sprintf("import json\nimport os\n\n"+
-		"%s\n\n"+
-		"params = os.environ[\"SERVERLESS_PARAMS\"]\n"+
-		"%s(json.loads(params))\n", code, functionName)
+	if !a.conf.LDAPcfg.LDAPSyncGroups {
+		return true, nil, nil
+	}
+	groups, err := searchUserGroups(a, l, username)
+	if err != nil {
+		log.Printf("Failed to search the groups of %s: %v", name, err)
+		return true, nil, nil
+	}
+	return true, groups, nil
 }
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/securecookie"
+	"github.com/xuant/go-kexec/dal"
+)
+
+// newTestContext returns an app context with no cluster, on an
+// in-memory DAL holding the users alice and bob, the group team of which
+// bob is a member, and a function hello of alice and of team.
+func newTestContext(t *testing.T) *appContext {
+	d := dal.NewMemory()
+	if _, _, err := d.PutUserIfNotExisted("", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := d.PutGroupIfNotExisted("team"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := d.PutUserIfNotExisted("team", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	for _, owner := range []string{"alice", dal.GroupOwner("team")} {
+		function := &dal.Function{UserID: -1, Name: "hello", Content: "print('hello')"}
+		if _, _, err := d.PutFunctionIfNotExisted(owner, function); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &appContext{
+		dal: d,
+		cookieHandler: securecookie.New(
+			securecookie.GenerateRandomKey(64),
+			securecookie.GenerateRandomKey(32),
+		),
+		conf:          &appConfig{},
+		executions:    newExecutionStore(),
+		functionLocks: newFunctionLocks(),
+	}
+}
+
+// newTestRequest returns a request of the logged in user `userName`, or
+// of no one if it is empty.
+func newTestRequest(a *appContext, method, target, userName string, body io.Reader) *http.Request {
+	request := httptest.NewRequest(method, target, body)
+	if userName != "" {
+		recorder := httptest.NewRecorder()
+		setSession(a, userName, recorder)
+		for _, cookie := range recorder.Result().Cookies() {
+			request.AddCookie(cookie)
+		}
+	}
+	return request
+}
+
+// serveTestRoute serves a request with a handler of the routes `paths`,
+// which gives the handler the vars of the route.
+func serveTestRoute(a *appContext, request *http.Request, h appRouteHandler, paths ...string) error {
+	var err error
+	router := mux.NewRouter()
+	for _, path := range paths {
+		router.HandleFunc(path, func(response http.ResponseWriter, request *http.Request) {
+			err = h(a, response, request)
+		})
+	}
+	router.ServeHTTP(httptest.NewRecorder(), request)
+	return err
+}
+
+// statusOf returns the HTTP status of the error of a handler.
+func statusOf(err error) int {
+	switch e := err.(type) {
+	case nil:
+		return http.StatusOK
+	case Error:
+		return e.Status()
+	}
+	return http.StatusInternalServerError
+}
+
+func TestGetUserExecution(t *testing.T) {
+	a := newTestContext(t)
+
+	for _, e := range []struct{ owner, uuid string }{
+		{"alice", "alice-exe"},
+		{dal.GroupOwner("team"), "team-exe"},
+	} {
+		record := &dal.FunctionExecution{UUID: e.uuid, Status: dal.ExecutionSucceeded}
+		if _, _, err := a.dal.PutExecution(e.owner, "hello", record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// In flight, ie not recorded yet
+	a.executions.add(&execution{ID: "running-exe", UserName: "alice", FunctionName: "hello", Phase: dal.ExecutionRunning})
+
+	tests := []struct {
+		userName string
+		id       string
+		status   int
+	}{
+		{"alice", "alice-exe", http.StatusOK},
+		{"alice", "running-exe", http.StatusOK},
+		{"bob", "team-exe", http.StatusOK},
+		{"bob", "alice-exe", http.StatusNotFound},
+		{"bob", "running-exe", http.StatusNotFound},
+		{"alice", "team-exe", http.StatusNotFound},
+		{"alice", "missing-exe", http.StatusNotFound},
+		{"", "alice-exe", http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		var exe *execution
+		request := newTestRequest(a, "GET", "/executions/"+test.id, test.userName, nil)
+		err := serveTestRoute(a, request, func(a *appContext, response http.ResponseWriter, request *http.Request) error {
+			var err error
+			exe, err = getUserExecution(a, request)
+			return err
+		}, "/executions/{id}")
+
+		if status := statusOf(err); status != test.status {
+			t.Errorf("Execution %s of %q: status %d, want %d (%v)", test.id, test.userName, status, test.status, err)
+			continue
+		}
+		if err == nil && exe.ID != test.id {
+			t.Errorf("Execution %s of %q: got execution %s", test.id, test.userName, exe.ID)
+		}
+	}
+}
+
+func TestCallFunctionHandlerRefusals(t *testing.T) {
+	a := newTestContext(t)
+	paths := []string{"/call/groups/{group}/{function}", "/call/{username}/{function}"}
+
+	tests := []struct {
+		target   string
+		userName string
+		status   int
+	}{
+		// Group functions are only called through the group route
+		{"/call/" + dal.GroupOwner("team") + "/hello", "bob", http.StatusNotFound},
+		{"/call/" + dal.GroupOwner("team") + "/hello", "", http.StatusNotFound},
+
+		{"/call/groups/team/hello", "alice", http.StatusForbidden},
+		{"/call/groups/team/hello", "", http.StatusUnauthorized},
+		{"/call/groups/missing/hello", "bob", http.StatusNotFound},
+		{"/call/groups/team/hello@latest", "bob", http.StatusBadRequest},
+		{"/call/alice/hello@0", "alice", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		request := newTestRequest(a, "POST", test.target, test.userName, strings.NewReader(""))
+		err := serveTestRoute(a, request, CallFunctionHandler, paths...)
+		if status := statusOf(err); status != test.status {
+			t.Errorf("%s by %q: status %d, want %d (%v)", test.target, test.userName, status, test.status, err)
+		}
+	}
+}
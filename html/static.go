@@ -160,16 +160,22 @@ const InternalPage = `<!DOCTYPE html>
       </div>
 
       <div id="Apple" class="tabcontent">
-        <div id="editor_div">def foo():
+        <div id="editor_div">def handler(event, context):
     print("Go-kexec is awesome.")
-
-foo()</div>
+    return {"event": event}</div>
 
         <form id="codeForm" action="/create" method="post" enctype="multipart/form-data">
           <input type="text" name="functionName" value="default_function">
-          <select name="runtime">
+          <input type="text" name="group" placeholder="Group (optional)">
+          <select id="runtime" name="runtime" onchange="setEditorMode()">
             <option value="python27">Python2.7</option>
           </select>
+          <input type="text" name="cpuLimit" placeholder="CPU limit (eg 500m)">
+          <input type="text" name="memoryLimit" placeholder="Memory limit (eg 256Mi)">
+          <input type="text" name="maxRuntime" placeholder="Max runtime (seconds)">
+          <input type="file" name="package" accept=".zip,.tar,.tar.gz,.tgz" title="Helper modules and dependency manifests">
+          <input type="text" name="image" placeholder="Existing image (instead of code)">
+          <label><input type="checkbox" name="update" value="true"> Update existing function</label>
           <button type="button" onclick="myFunction()">Submit</button>
           <hr>
           <p class="codeuploaded">Code Uploaded:</p>
@@ -218,6 +224,33 @@ foo()</div>
     var editor = ace.edit("editor_div");
     editor.setTheme("ace/theme/monokai");
     editor.getSession().setMode("ace/mode/python");
+
+    // Fill the runtime list from the server
+    var editorModes = {};
+    var runtimeRequest = new XMLHttpRequest();
+    runtimeRequest.onload = function() {
+      var select = document.getElementById("runtime");
+      var runtimes = JSON.parse(runtimeRequest.responseText);
+      select.innerHTML = "";
+      for (var i = 0; i < runtimes.length; i++) {
+        var option = document.createElement("option");
+        option.value = runtimes[i].name;
+        option.text = runtimes[i].displayName;
+        option.selected = runtimes[i].name == "python27";
+        select.appendChild(option);
+        editorModes[runtimes[i].name] = runtimes[i].editorMode;
+      }
+      setEditorMode();
+    };
+    runtimeRequest.open("GET", "/runtimes");
+    runtimeRequest.send();
+
+    function setEditorMode() {
+      var mode = editorModes[document.getElementById("runtime").value];
+      if (mode) {
+        editor.getSession().setMode("ace/mode/" + mode);
+      }
+    }
   
     function myFunction() {
     var textarea = document.getElementById("myTextarea");
@@ -237,11 +270,45 @@ const FunctionFailedErrorPage = `
 %s
 `
 
-const FunctionCreatedPage = `
-<h1>Function created successfully.<h1>
+const FunctionRegisteredPage = `
+<h1>Function registered successfully, version %d.<h1>
 <button type="button" onclick="history.go(-1);">Back</button>
 `
 
+const FunctionSubmittedPage = `
+<h1 id="buildTitle">Building function (build %d)...<h1>
+<p id="buildStatus">queued</p>
+<p id="buildError"></p>
+<a href="%s">Build log</a>
+<button type="button" onclick="history.go(-1);">Back</button>
+<script type="text/javascript">
+  // Poll the build until it is ready or failed
+  function pollBuild() {
+    var request = new XMLHttpRequest();
+    request.onload = function() {
+      if (request.status != 200) {
+        document.getElementById("buildError").textContent = request.responseText;
+        return;
+      }
+      var build = JSON.parse(request.responseText);
+      document.getElementById("buildStatus").textContent = build.status;
+      if (build.status == "ready") {
+        document.getElementById("buildTitle").textContent =
+          "Function created successfully, version " + build.version + ".";
+      } else if (build.status == "failed") {
+        document.getElementById("buildTitle").textContent = "Function build failed.";
+        document.getElementById("buildError").textContent = build.error;
+      } else {
+        setTimeout(pollBuild, 2000);
+      }
+    };
+    request.open("GET", "%s");
+    request.send();
+  }
+  pollBuild();
+</script>
+`
+
 const FunctionCalledPage = `
 <h1>Function called successfully.<h1>
 <button type="button" onclick="history.go(-1);">Back</button>
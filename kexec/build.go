@@ -0,0 +1,154 @@
+/*
+Copyright 2016 Xuan Tang. All rights reserved.
+Use of this source code is governed by a license
+that can be found in the LICENSE file.
+*/
+
+package kexec
+
+import (
+	unversioned "k8s.io/client-go/1.4/pkg/api/unversioned"
+	v1 "k8s.io/client-go/1.4/pkg/api/v1"
+	batchv1 "k8s.io/client-go/1.4/pkg/apis/batch/v1"
+)
+
+var (
+	// Labels put on build jobs, holding the name of the function built
+	// and of the account owning it
+	LabelBuild     = "go-kexec/build"
+	LabelBuildUser = "go-kexec/build-user"
+
+	// Where build jobs mount the build context and the docker client
+	// config holding the registry credentials
+	BuildContextMountPath = "/workspace"
+	DockerConfigMountPath = "/kaniko/.docker"
+
+	// Key of the docker config in secrets of type
+	// kubernetes.io/dockerconfigjson
+	DockerConfigSecretKey = ".dockerconfigjson"
+)
+
+// BuildJob describes a job building the image of a function in the
+// cluster, with a daemonless builder.
+type BuildJob struct {
+	// Image of the builder, and its arguments
+	Image  string
+	Args   []string
+	Labels map[string]string
+
+	// Persistent volume claim holding the build contexts, and the path
+	// of the context of the build in the volume
+	ContextClaim string
+	ContextPath  string
+
+	// Secret of type kubernetes.io/dockerconfigjson holding the
+	// credentials of the registry. Optional.
+	RegistrySecret string
+
+	// Compute resources and deadline of the build. Optional.
+	Limits *ResourceLimits
+}
+
+// CreateBuildJob creates a job running a build. The builder writes
+// what it reports, eg the digest of the pushed image, to its
+// termination message.
+func (k *Kexec) CreateBuildJob(jobName, namespace string, build *BuildJob) error {
+	template, err := createBuildJobTemplate(jobName, namespace, build)
+	if err != nil {
+		return err
+	}
+
+	_, err = k.Clientset.Batch().Jobs(namespace).Create(template)
+	return err
+}
+
+// DeleteJob deletes a job and its pods.
+func (k *Kexec) DeleteJob(jobName, namespace string) error {
+	_, err := k.deleteJob(jobName, namespace)
+	return err
+}
+
+func createBuildJobTemplate(jobName, namespace string, build *BuildJob) (*batchv1.Job, error) {
+	container := v1.Container{
+		Name:  jobName,
+		Image: build.Image,
+		Args:  build.Args,
+		VolumeMounts: []v1.VolumeMount{
+			v1.VolumeMount{
+				Name:      "context",
+				MountPath: BuildContextMountPath,
+				SubPath:   build.ContextPath,
+			},
+		},
+		TerminationMessagePath: TerminationMessagePath,
+	}
+	volumes := []v1.Volume{
+		v1.Volume{
+			Name: "context",
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+					ClaimName: build.ContextClaim,
+				},
+			},
+		},
+	}
+
+	if build.RegistrySecret != "" {
+		container.VolumeMounts = append(container.VolumeMounts, v1.VolumeMount{
+			Name:      "docker-config",
+			MountPath: DockerConfigMountPath,
+			ReadOnly:  true,
+		})
+		volumes = append(volumes, v1.Volume{
+			Name: "docker-config",
+			VolumeSource: v1.VolumeSource{
+				Secret: &v1.SecretVolumeSource{
+					SecretName: build.RegistrySecret,
+					Items: []v1.KeyToPath{
+						v1.KeyToPath{Key: DockerConfigSecretKey, Path: "config.json"},
+					},
+				},
+			},
+		})
+	}
+
+	if build.Limits != nil {
+		resources, err := build.Limits.resourceRequirements()
+		if err != nil {
+			return nil, err
+		}
+		container.Resources = resources
+	}
+
+	job := &batchv1.Job{
+		TypeMeta: unversioned.TypeMeta{
+			Kind:       "Job",
+			APIVersion: "batch/v1",
+		},
+		ObjectMeta: v1.ObjectMeta{
+			Name:      jobName,
+			Namespace: namespace,
+			Labels:    build.Labels,
+		},
+		Spec: batchv1.JobSpec{
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: v1.ObjectMeta{
+					Name: jobName,
+				},
+				Spec: v1.PodSpec{
+					Containers:    []v1.Container{container},
+					Volumes:       volumes,
+					RestartPolicy: v1.RestartPolicyNever,
+				},
+			},
+		},
+	}
+
+	if build.Limits != nil && build.Limits.MaxRuntime > 0 {
+		deadline := build.Limits.MaxRuntime
+		job.Spec.ActiveDeadlineSeconds = &deadline
+		job.Spec.Template.Spec.ActiveDeadlineSeconds = &deadline
+	}
+
+	return job, nil
+}
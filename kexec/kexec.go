@@ -7,14 +7,15 @@ that can be found in the LICENSE file.
 package kexec
 
 import (
-	"errors"
+	"bytes"
 	"fmt"
 	"io/ioutil"
-	"log"
-	"time"
+	"sort"
+	"sync"
 
 	"k8s.io/client-go/1.4/kubernetes"
 	"k8s.io/client-go/1.4/pkg/api"
+	apierrors "k8s.io/client-go/1.4/pkg/api/errors"
 	unversioned "k8s.io/client-go/1.4/pkg/api/unversioned"
 	v1 "k8s.io/client-go/1.4/pkg/api/v1"
 	batchv1 "k8s.io/client-go/1.4/pkg/apis/batch/v1"
@@ -24,14 +25,31 @@ import (
 
 var (
 	JobEnvParams = "SERVERLESS_PARAMS"
+
+	// Environment variable holding the path of the file functions
+	// write their result to. The file is the termination message of
+	// the container, so kubernetes keeps it in the pod status, up to
+	// 4096 bytes.
+	JobEnvResultFile       = "SERVERLESS_RESULT_FILE"
+	TerminationMessagePath = "/dev/termination-log"
 )
 
 type KexecConfig struct {
 	KubeConfig string
+
+	// What user namespaces are provisioned with
+	NamespaceTemplate NamespaceTemplate
 }
 
 type Kexec struct {
 	Clientset *kubernetes.Clientset
+
+	// what user namespaces are provisioned with, the number of times
+	// it was set, and the user namespaces used so far
+	mu                 sync.Mutex
+	namespaceTemplate  NamespaceTemplate
+	templateGeneration int
+	namespaces         map[string]*userNamespace
 }
 
 // NewKexec creates a new Kexec instance which contains all the methods
@@ -52,13 +70,20 @@ func NewKexec(c *KexecConfig) (*Kexec, error) {
 	}
 
 	return &Kexec{
-		Clientset: clientset,
+		Clientset:          clientset,
+		namespaceTemplate:  c.NamespaceTemplate,
+		templateGeneration: 1,
+		namespaces:         make(map[string]*userNamespace),
 	}, nil
 }
 
 // CallFunction will create a Job template and then create the Job
 // instance against the specified kubernetes/openshift cluster.
-func (k *Kexec) CreateFunctionJob(jobname, image, params, namespace string, labels map[string]string) error {
+//
+// `env` holds extra environment variables of the function container.
+// `limits` sets the compute resources and the deadline of the job.
+// It can be nil, in which case the job runs unbounded.
+func (k *Kexec) CreateFunctionJob(jobname, image, params, namespace string, labels, env map[string]string, limits *ResourceLimits) error {
 	/*
 		uuid, err := uuid.NewTimeBased()
 		if err != nil {
@@ -67,9 +92,12 @@ func (k *Kexec) CreateFunctionJob(jobname, image, params, namespace string, labe
 		jobname := function + "-" + uuid.String()
 		fmt.Println(jobname)
 	*/
-	template := createJobTemplate(image, jobname, params, namespace, labels)
+	template, err := createJobTemplate(image, jobname, params, namespace, labels, env, limits)
+	if err != nil {
+		return err
+	}
 
-	_, err := k.Clientset.Batch().Jobs(namespace).Create(template)
+	_, err = k.Clientset.Batch().Jobs(namespace).Create(template)
 	if err != nil {
 		return err
 	}
@@ -77,14 +105,24 @@ func (k *Kexec) CreateFunctionJob(jobname, image, params, namespace string, labe
 	return nil
 }
 
-// GetFunctionLog gets the log information for a pod execution.
-// This function doesn't consider multiple pods for one execution;
-// if there are multiple pods for this function execution, it will
-// only return one of them.
+// LogOptions selects the pods whose logs are returned by
+// GetFunctionLog. A nil LogOptions selects all the pods.
+type LogOptions struct {
+	// Only return the log of the pod with this name
+	PodName string
+
+	// Only return the log of the last attempt, ie the pod which
+	// started last
+	LastAttempt bool
+}
+
+// GetFunctionLog gets the log information for a function execution.
 //
-// TODO: Logs should be return in full if there are multiple pods
-//       for one function execution.
-func (k *Kexec) GetFunctionLog(jobName, namespace string) ([]byte, error) {
+// A job may run several pods for one execution, eg when a failed pod
+// is retried. The pods are ordered by start time and numbered from 1
+// as attempts. If more than one pod is selected, the log of each pod
+// is preceded by a header line with the pod name and attempt number.
+func (k *Kexec) GetFunctionLog(jobName, namespace string, opts *LogOptions) ([]byte, error) {
 
 	podlist, err := k.getFunctionPods(jobName, namespace)
 	if err != nil {
@@ -95,8 +133,48 @@ func (k *Kexec) GetFunctionLog(jobName, namespace string) ([]byte, error) {
 		return nil, fmt.Errorf("No pod found for job %s.", jobName)
 	}
 
-	// Only return the log of the first pod
-	podName := podlist.Items[0].Name
+	pods := podsByStartTime(podlist.Items)
+	sort.Sort(pods)
+
+	attempts := make([]int, 0, len(pods))
+	for i, pod := range pods {
+		if opts != nil && opts.PodName != "" && pod.Name != opts.PodName {
+			continue
+		}
+		attempts = append(attempts, i+1)
+	}
+	if opts != nil && opts.LastAttempt && len(attempts) > 0 {
+		attempts = attempts[len(attempts)-1:]
+	}
+
+	if len(attempts) < 1 {
+		return nil, fmt.Errorf("No pod %s found for job %s.", opts.PodName, jobName)
+	}
+
+	if len(attempts) == 1 {
+		return k.getPodLog(pods[attempts[0]-1].Name, namespace)
+	}
+
+	var buf bytes.Buffer
+	for _, attempt := range attempts {
+		podName := pods[attempt-1].Name
+		podLog, err := k.getPodLog(podName, namespace)
+		if err != nil {
+			return nil, err
+		}
+
+		fmt.Fprintf(&buf, "==> pod %s (attempt %d) <==\n", podName, attempt)
+		buf.Write(podLog)
+		if len(podLog) > 0 && podLog[len(podLog)-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// private function to read the whole log of a pod
+func (k *Kexec) getPodLog(podName, namespace string) ([]byte, error) {
 	opts := &v1.PodLogOptions{
 		Follow:     true,
 		Timestamps: false,
@@ -113,12 +191,33 @@ func (k *Kexec) GetFunctionLog(jobName, namespace string) ([]byte, error) {
 	return ioutil.ReadAll(response)
 }
 
+// podsByStartTime sorts pods by start time. Pods which have not started
+// yet are sorted last, by creation time.
+type podsByStartTime []v1.Pod
+
+func (p podsByStartTime) Len() int      { return len(p) }
+func (p podsByStartTime) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p podsByStartTime) Less(i, j int) bool {
+	si, sj := p[i].Status.StartTime, p[j].Status.StartTime
+	switch {
+	case si != nil && sj != nil:
+		return si.Time.Before(sj.Time)
+	case si != nil:
+		return true
+	case sj != nil:
+		return false
+	}
+	return p[i].CreationTimestamp.Time.Before(p[j].CreationTimestamp.Time)
+}
+
 // public fuction to get pod(s) that ran a specific function execution.
 func (k *Kexec) GetFunctionPods(jobName, namespace string) (*v1.PodList, error) {
 	return k.getFunctionPods(jobName, namespace)
 }
 
-func (k *Kexec) WaitForPodComplete(jobName, namespace string) error {
+// private function to help get the exact pod(s) that ran a specific
+// function execution.
+func (k *Kexec) getFunctionPods(jobName, namespace string) (*v1.PodList, error) {
 	// Create job label selector
 	jobLabelSelector := labels.SelectorFromSet(labels.Set{
 		"job-name": jobName,
@@ -126,64 +225,57 @@ func (k *Kexec) WaitForPodComplete(jobName, namespace string) error {
 
 	// List pods according to `jobLabelSelector`
 	listOptions := api.ListOptions{
-		Watch:         true,
 		LabelSelector: jobLabelSelector,
 	}
 
-	var status v1.PodStatus
-	w, err := k.Clientset.Core().Pods(namespace).Watch(listOptions)
-	if err != nil {
-		return err
-	}
-	func() {
-		for {
-			select {
-			case events, ok := <-w.ResultChan():
-				if !ok {
-					return
-				}
-				resp := events.Object.(*v1.Pod)
-				log.Println("Pod status:", resp.Status.Phase)
-				status = resp.Status
-				if resp.Status.Phase != v1.PodPending &&
-					resp.Status.Phase != v1.PodRunning {
-					w.Stop()
-				}
-				if resp.Status.Phase == v1.PodUnknown {
-					err = errors.New(resp.Status.Reason)
-				}
-			case <-time.After(60 * time.Second):
-				err = errors.New("Timeout to wait for job completes")
-				w.Stop()
-			}
-		}
-	}()
-	return err
+	return k.Clientset.Core().Pods(namespace).List(listOptions)
+}
+
+// DeleteFunctionJobs deletes the jobs of a function in a namespace,
+// finished or running, along with their pods. `function` is the value
+// of LabelFunction on the jobs. It returns the number of jobs deleted.
+func (k *Kexec) DeleteFunctionJobs(function, namespace string) (int, error) {
+	return k.deleteJobs(labels.Set{LabelFunction: function}, namespace)
+}
+
+// DeleteBuildJobs deletes the build jobs of a function of a user in a
+// namespace, finished or running, along with their pods. `user` and
+// `function` are the values of LabelBuildUser and LabelBuild on the jobs.
+// It returns the number of jobs deleted.
+func (k *Kexec) DeleteBuildJobs(user, function, namespace string) (int, error) {
+	return k.deleteJobs(labels.Set{LabelBuildUser: user, LabelBuild: function}, namespace)
 }
 
-// public function to create a namespace if it does not exist
-func (k *Kexec) CreateUserNamespaceIfNotExist(namespace string) (*v1.Namespace, error) {
-	if ns, err := k.Clientset.Core().Namespaces().Get(namespace); err == nil {
-		log.Println("Namespace", namespace, "already exists!")
-		return ns, nil
+// DeleteJobIfExists deletes a job and its pods, like DeleteJob, but
+// deleting a job which does not exist is not an error.
+func (k *Kexec) DeleteJobIfExists(jobName, namespace string) error {
+	if _, err := k.deleteJob(jobName, namespace); err != nil && !apierrors.IsNotFound(err) {
+		return err
 	}
-	return k.createNamespace(namespace)
+	return nil
 }
 
-// private function to help get the exact pod(s) that ran a specific
-// function execution.
-func (k *Kexec) getFunctionPods(jobName, namespace string) (*v1.PodList, error) {
-	// Create job label selector
-	jobLabelSelector := labels.SelectorFromSet(labels.Set{
-		"job-name": jobName,
-	})
+// deleteJobs deletes the jobs having a set of labels in a namespace,
+// along with their pods.
+func (k *Kexec) deleteJobs(set labels.Set, namespace string) (int, error) {
+	selector := labels.SelectorFromSet(set)
 
-	// List pods according to `jobLabelSelector`
-	listOptions := api.ListOptions{
-		LabelSelector: jobLabelSelector,
+	joblist, err := k.Clientset.Batch().Jobs(namespace).List(api.ListOptions{
+		LabelSelector: selector,
+	})
+	if err != nil {
+		return 0, err
 	}
 
-	return k.Clientset.Core().Pods(namespace).List(listOptions)
+	deleted := 0
+	for _, job := range joblist.Items {
+		// The reaper may have deleted it in the meantime
+		if _, err := k.deleteJob(job.Name, namespace); err != nil && !apierrors.IsNotFound(err) {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
 }
 
 // private function to create a namespace
@@ -207,12 +299,13 @@ func (k *Kexec) createNamespace(namespace string) (*v1.Namespace, error) {
 // create a Job instance against the specified kubernetes/openshift
 // cluster.
 //
-// For now, user only provide image, jobname, namespace and labels.
-// Other features like parallelism, etc., cannot be specified.
-//
-// TODO: 1. make parallelism configurable
-func createJobTemplate(image, jobname, params, namespace string, labels map[string]string) *batchv1.Job {
-	return &batchv1.Job{
+// User provides image, jobname, namespace, labels, environment and
+// resource limits.
+// Every job runs a single pod: parallel executions of a function are
+// run as a set of jobs, one per input, since batch/v1 jobs have no
+// completion index to hand each pod its own input.
+func createJobTemplate(image, jobname, params, namespace string, labels, env map[string]string, limits *ResourceLimits) (*batchv1.Job, error) {
+	job := &batchv1.Job{
 		TypeMeta: unversioned.TypeMeta{
 			Kind:       "Job",
 			APIVersion: "batch/v1",
@@ -230,14 +323,10 @@ func createJobTemplate(image, jobname, params, namespace string, labels map[stri
 				Spec: v1.PodSpec{
 					Containers: []v1.Container{
 						v1.Container{
-							Name:  jobname,
-							Image: image,
-							Env: []v1.EnvVar{
-								v1.EnvVar{
-									Name:  JobEnvParams,
-									Value: params,
-								},
-							},
+							Name:                   jobname,
+							Image:                  image,
+							Env:                    jobEnv(params, env),
+							TerminationMessagePath: TerminationMessagePath,
 						},
 					},
 					RestartPolicy: v1.RestartPolicyNever,
@@ -245,4 +334,43 @@ func createJobTemplate(image, jobname, params, namespace string, labels map[stri
 			},
 		},
 	}
+
+	if limits == nil {
+		return job, nil
+	}
+
+	resources, err := limits.resourceRequirements()
+	if err != nil {
+		return nil, err
+	}
+	job.Spec.Template.Spec.Containers[0].Resources = resources
+
+	// The deadline is set on both the job and its pods: the job one
+	// covers retries, the pod one stops the running container.
+	if limits.MaxRuntime > 0 {
+		deadline := limits.MaxRuntime
+		job.Spec.ActiveDeadlineSeconds = &deadline
+		job.Spec.Template.Spec.ActiveDeadlineSeconds = &deadline
+	}
+
+	return job, nil
+}
+
+// jobEnv returns the environment of a function container, sorted by
+// name so job templates are deterministic.
+func jobEnv(params string, env map[string]string) []v1.EnvVar {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	vars := []v1.EnvVar{
+		v1.EnvVar{Name: JobEnvParams, Value: params},
+		v1.EnvVar{Name: JobEnvResultFile, Value: TerminationMessagePath},
+	}
+	for _, name := range names {
+		vars = append(vars, v1.EnvVar{Name: name, Value: env[name]})
+	}
+	return vars
 }
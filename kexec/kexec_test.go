@@ -1,12 +1,26 @@
 package kexec
 
 import (
+	"flag"
 	"log"
+	"os"
 	"testing"
 	"time"
 )
 
+// Calls a function on the cluster of ./fakekubeconfig before running the
+// tests, which need no cluster
+var cluster = flag.Bool("cluster", false, "call a function on the cluster of ./fakekubeconfig")
+
 func TestMain(m *testing.M) {
+	flag.Parse()
+	if *cluster {
+		callGorilla()
+	}
+	os.Exit(m.Run())
+}
+
+func callGorilla() {
 	c := &KexecConfig{
 		KubeConfig: "./fakekubeconfig",
 	}
@@ -23,11 +37,13 @@ func TestMain(m *testing.M) {
 
 	labels := make(map[string]string)
 
-	k.CallFunction(jobName, image, "default", labels)
+	if err := k.CreateFunctionJob(jobName, image, "", "default", labels, nil, nil); err != nil {
+		panic(err)
+	}
 
 	time.Sleep(30 * time.Second)
 
-	funcLog, err := k.GetFunctionLog(funcName, uuid, "default")
+	funcLog, err := k.GetFunctionLog(jobName, "default", nil)
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,324 @@
+/*
+Copyright 2016 Xuan Tang. All rights reserved.
+Use of this source code is governed by a license
+that can be found in the LICENSE file.
+*/
+
+package kexec
+
+import (
+	"log"
+	"sync"
+
+	"k8s.io/client-go/1.4/pkg/api"
+	apierrors "k8s.io/client-go/1.4/pkg/api/errors"
+	"k8s.io/client-go/1.4/pkg/api/resource"
+	unversioned "k8s.io/client-go/1.4/pkg/api/unversioned"
+	v1 "k8s.io/client-go/1.4/pkg/api/v1"
+	"k8s.io/client-go/1.4/pkg/apis/extensions/v1beta1"
+)
+
+var (
+	// Names of the objects user namespaces are provisioned with
+	ResourceQuotaName = "go-kexec-quota"
+	LimitRangeName    = "go-kexec-limits"
+	NetworkPolicyName = "go-kexec-deny-ingress"
+
+	// Deprecated namespace annotation isolating the pods of a
+	// namespace, which NetworkPolicyName replaces. It is removed from
+	// the namespaces that still have it.
+	AnnotationNetworkPolicy = "net.beta.kubernetes.io/network-policy"
+)
+
+// NamespaceTemplate describes what user namespaces are provisioned
+// with. Resources are given by name (eg "pods", "requests.cpu",
+// "memory") with quantities in the kubernetes notation. Empty lists
+// are not applied.
+type NamespaceTemplate struct {
+	// Hard limits of the namespace resource quota
+	ResourceQuota map[string]string
+
+	// Defaults and bounds for the containers of the namespace
+	LimitRange LimitRangeTemplate
+
+	// Deny ingress traffic to the pods of the namespace, with a
+	// network policy selecting all of them and allowing nothing
+	DefaultDenyIngress bool
+}
+
+type LimitRangeTemplate struct {
+	Default        map[string]string
+	DefaultRequest map[string]string
+	Max            map[string]string
+}
+
+// userNamespace is the state of a user namespace: the generation of
+// the template it was last reconciled with, 0 if none. Its lock is
+// held while it is reconciled.
+type userNamespace struct {
+	sync.Mutex
+	generation int
+}
+
+// EnsureUserNamespace creates a user namespace if it does not exist,
+// and reconciles it with the namespace template: its network policy,
+// resource quota and limit range are created, updated or deleted to
+// match the template.
+//
+// A namespace is reconciled the first time it is used by this Kexec,
+// and again after the template changes; other calls return right away.
+// Different namespaces are reconciled concurrently.
+func (k *Kexec) EnsureUserNamespace(namespace string) error {
+	k.mu.Lock()
+	ns, ok := k.namespaces[namespace]
+	if !ok {
+		ns = &userNamespace{}
+		k.namespaces[namespace] = ns
+	}
+	tmpl, generation := k.namespaceTemplate, k.templateGeneration
+	k.mu.Unlock()
+
+	ns.Lock()
+	defer ns.Unlock()
+
+	if ns.generation == generation {
+		return nil
+	}
+
+	if err := k.reconcileNamespace(namespace); err != nil {
+		return err
+	}
+	if err := k.reconcileNetworkPolicy(namespace, &tmpl); err != nil {
+		return err
+	}
+	if err := k.reconcileResourceQuota(namespace, &tmpl); err != nil {
+		return err
+	}
+	if err := k.reconcileLimitRange(namespace, &tmpl); err != nil {
+		return err
+	}
+
+	ns.generation = generation
+	return nil
+}
+
+// SetNamespaceTemplate changes what user namespaces are provisioned
+// with. Namespaces are reconciled with the new template the next time
+// they are used.
+func (k *Kexec) SetNamespaceTemplate(tmpl NamespaceTemplate) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.namespaceTemplate = tmpl
+	k.templateGeneration++
+}
+
+// DeleteNamespace deletes a namespace and everything in it. Deleting a
+// namespace which does not exist is not an error.
+func (k *Kexec) DeleteNamespace(namespace string) error {
+	k.mu.Lock()
+	delete(k.namespaces, namespace)
+	k.mu.Unlock()
+
+	err := k.Clientset.Core().Namespaces().Delete(namespace, &api.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// reconcileNamespace creates a namespace if it does not exist, and
+// removes the deprecated network policy annotation from it.
+func (k *Kexec) reconcileNamespace(namespace string) error {
+	ns, err := k.Clientset.Core().Namespaces().Get(namespace)
+	if apierrors.IsNotFound(err) {
+		log.Println("Creating namespace", namespace)
+		ns, err = k.createNamespace(namespace)
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, ok := ns.Annotations[AnnotationNetworkPolicy]; !ok {
+		return nil
+	}
+	delete(ns.Annotations, AnnotationNetworkPolicy)
+	_, err = k.Clientset.Core().Namespaces().Update(ns)
+	return err
+}
+
+// reconcileNetworkPolicy creates or deletes the network policy denying
+// ingress traffic. The typed client has no network policies, so they go
+// through the REST client of the extensions group.
+func (k *Kexec) reconcileNetworkPolicy(namespace string, tmpl *NamespaceTemplate) error {
+	client := k.Clientset.Extensions().GetRESTClient()
+
+	policy := &v1beta1.NetworkPolicy{}
+	err := client.Get().
+		Namespace(namespace).
+		Resource("networkpolicies").
+		Name(NetworkPolicyName).
+		Do().
+		Into(policy)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+
+	switch {
+	case tmpl.DefaultDenyIngress && !exists:
+		// An empty pod selector selects all the pods of the namespace,
+		// and no ingress rule allows any traffic to them
+		return client.Post().
+			Namespace(namespace).
+			Resource("networkpolicies").
+			Body(&v1beta1.NetworkPolicy{
+				TypeMeta: unversioned.TypeMeta{
+					Kind:       "NetworkPolicy",
+					APIVersion: "extensions/v1beta1",
+				},
+				ObjectMeta: v1.ObjectMeta{
+					Name:      NetworkPolicyName,
+					Namespace: namespace,
+				},
+			}).
+			Do().
+			Error()
+	case !tmpl.DefaultDenyIngress && exists:
+		return client.Delete().
+			Namespace(namespace).
+			Resource("networkpolicies").
+			Name(NetworkPolicyName).
+			Do().
+			Error()
+	}
+	return nil
+}
+
+func (k *Kexec) reconcileResourceQuota(namespace string, tmpl *NamespaceTemplate) error {
+	hard, err := resourceList(tmpl.ResourceQuota)
+	if err != nil {
+		return err
+	}
+
+	quotas := k.Clientset.Core().ResourceQuotas(namespace)
+	quota, err := quotas.Get(ResourceQuotaName)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+
+	switch {
+	case len(hard) == 0 && exists:
+		return quotas.Delete(ResourceQuotaName, nil)
+	case len(hard) == 0:
+		return nil
+	case !exists:
+		_, err = quotas.Create(&v1.ResourceQuota{
+			TypeMeta: unversioned.TypeMeta{
+				Kind:       "ResourceQuota",
+				APIVersion: "v1",
+			},
+			ObjectMeta: v1.ObjectMeta{
+				Name:      ResourceQuotaName,
+				Namespace: namespace,
+			},
+			Spec: v1.ResourceQuotaSpec{
+				Hard: hard,
+			},
+		})
+		return err
+	case !equalResourceLists(quota.Spec.Hard, hard):
+		quota.Spec.Hard = hard
+		_, err = quotas.Update(quota)
+		return err
+	}
+	return nil
+}
+
+func (k *Kexec) reconcileLimitRange(namespace string, tmpl *NamespaceTemplate) error {
+	limits := tmpl.LimitRange
+
+	item := v1.LimitRangeItem{
+		Type: v1.LimitTypeContainer,
+	}
+	var err error
+	if item.Default, err = resourceList(limits.Default); err != nil {
+		return err
+	}
+	if item.DefaultRequest, err = resourceList(limits.DefaultRequest); err != nil {
+		return err
+	}
+	if item.Max, err = resourceList(limits.Max); err != nil {
+		return err
+	}
+	empty := len(item.Default) == 0 && len(item.DefaultRequest) == 0 && len(item.Max) == 0
+
+	limitRanges := k.Clientset.Core().LimitRanges(namespace)
+	limitRange, err := limitRanges.Get(LimitRangeName)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+
+	switch {
+	case empty && exists:
+		return limitRanges.Delete(LimitRangeName, nil)
+	case empty:
+		return nil
+	case !exists:
+		_, err = limitRanges.Create(&v1.LimitRange{
+			TypeMeta: unversioned.TypeMeta{
+				Kind:       "LimitRange",
+				APIVersion: "v1",
+			},
+			ObjectMeta: v1.ObjectMeta{
+				Name:      LimitRangeName,
+				Namespace: namespace,
+			},
+			Spec: v1.LimitRangeSpec{
+				Limits: []v1.LimitRangeItem{item},
+			},
+		})
+		return err
+	}
+
+	if len(limitRange.Spec.Limits) == 1 {
+		current := limitRange.Spec.Limits[0]
+		if current.Type == item.Type &&
+			equalResourceLists(current.Default, item.Default) &&
+			equalResourceLists(current.DefaultRequest, item.DefaultRequest) &&
+			equalResourceLists(current.Max, item.Max) {
+			return nil
+		}
+	}
+	limitRange.Spec.Limits = []v1.LimitRangeItem{item}
+	_, err = limitRanges.Update(limitRange)
+	return err
+}
+
+// resourceList parses the quantities of a template.
+func resourceList(quantities map[string]string) (v1.ResourceList, error) {
+	list := v1.ResourceList{}
+	for name, value := range quantities {
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, err
+		}
+		list[v1.ResourceName(name)] = quantity
+	}
+	return list, nil
+}
+
+func equalResourceLists(a, b v1.ResourceList) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, quantity := range a {
+		other, ok := b[name]
+		if !ok || quantity.Cmp(other) != 0 {
+			return false
+		}
+	}
+	return true
+}
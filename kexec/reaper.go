@@ -0,0 +1,283 @@
+/*
+Copyright 2016 Xuan Tang. All rights reserved.
+Use of this source code is governed by a license
+that can be found in the LICENSE file.
+*/
+
+package kexec
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/1.4/pkg/api"
+	apierrors "k8s.io/client-go/1.4/pkg/api/errors"
+	v1 "k8s.io/client-go/1.4/pkg/api/v1"
+	batchv1 "k8s.io/client-go/1.4/pkg/apis/batch/v1"
+	"k8s.io/client-go/1.4/pkg/labels"
+)
+
+// Labels put on function jobs. The reaper only deletes jobs labelled
+// with LabelLogsPersisted, ie jobs whose execution is recorded.
+var (
+	LabelFunction      = "go-kexec/function"
+	LabelExecution     = "go-kexec/execution"
+	LabelLogsPersisted = "go-kexec/logs-persisted"
+	LabelStatus        = "go-kexec/status"
+
+	// Annotation holding the time (RFC3339) the logs were persisted
+	AnnotationPersistedAt = "go-kexec/persisted-at"
+
+	// Values of LabelStatus
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// Times MarkLogsPersisted, or a wait stopping a failed job, updates a
+// job whose status the job controller updated in the meantime
+var MarkRetries = 5
+
+// MarkLogsPersisted labels a finished job as safe to delete: its logs
+// and final status are recorded elsewhere.
+func (k *Kexec) MarkLogsPersisted(jobName, namespace string, succeeded bool) error {
+	status := StatusFailed
+	if succeeded {
+		status = StatusSucceeded
+	}
+
+	var err error
+	for i := 0; i < MarkRetries; i++ {
+		var job *batchv1.Job
+		job, err = k.Clientset.Batch().Jobs(namespace).Get(jobName)
+		if err != nil {
+			return err
+		}
+
+		if job.Labels == nil {
+			job.Labels = make(map[string]string)
+		}
+		job.Labels[LabelLogsPersisted] = "true"
+		job.Labels[LabelStatus] = status
+
+		if job.Annotations == nil {
+			job.Annotations = make(map[string]string)
+		}
+		job.Annotations[AnnotationPersistedAt] = time.Now().UTC().Format(time.RFC3339)
+
+		_, err = k.Clientset.Batch().Jobs(namespace).Update(job)
+		if !apierrors.IsConflict(err) {
+			return err
+		}
+	}
+	return err
+}
+
+type ReaperConfig struct {
+	// How long jobs are kept after their logs are persisted,
+	// depending on their status
+	SucceededRetention time.Duration
+	FailedRetention    time.Duration
+
+	// Interval between two runs of the reaper
+	Interval time.Duration
+
+	// How long finished function jobs are kept when they never got
+	// labelled, eg because their server stopped before recording them.
+	// 0 keeps them.
+	MaxAge time.Duration
+}
+
+// ReaperStats counts the objects deleted by a reaper since it started.
+type ReaperStats struct {
+	Runs        int64
+	JobsDeleted int64
+	PodsDeleted int64
+	LastRun     time.Time
+}
+
+// Reaper periodically deletes finished function jobs and their pods
+// once they are older than the configured retention.
+//
+// Only jobs labelled by MarkLogsPersisted are considered, so the reaper
+// is safe to run while functions are being called.
+type Reaper struct {
+	k      *Kexec
+	config ReaperConfig
+
+	mu    sync.Mutex
+	stats ReaperStats
+	stop  chan struct{}
+}
+
+// NewReaper creates a reaper for the jobs of the cluster. Call Start to
+// run it in the background.
+func (k *Kexec) NewReaper(config ReaperConfig) *Reaper {
+	return &Reaper{
+		k:      k,
+		config: config,
+		stop:   make(chan struct{}),
+	}
+}
+
+// Start runs the reaper every `Interval`, which must be positive, until
+// Stop is called.
+func (r *Reaper) Start() {
+	go func() {
+		ticker := time.NewTicker(r.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if _, _, err := r.Reap(); err != nil {
+					log.Printf("Reaper failed: %v", err)
+				}
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (r *Reaper) Stop() {
+	close(r.stop)
+}
+
+// Stats returns the objects deleted so far.
+func (r *Reaper) Stats() ReaperStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
+
+// Reap deletes, in all namespaces, the persisted jobs older than their
+// retention and the finished unlabelled ones older than the max age,
+// along with their pods. It returns the number of jobs and pods deleted.
+func (r *Reaper) Reap() (int, int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	selector := labels.SelectorFromSet(labels.Set{
+		LabelLogsPersisted: "true",
+	})
+
+	joblist, err := r.k.Clientset.Batch().Jobs(api.NamespaceAll).List(api.ListOptions{
+		LabelSelector: selector,
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	jobs, pods := 0, 0
+	now := time.Now()
+	for _, job := range joblist.Items {
+		persistedAt, err := time.Parse(time.RFC3339, job.Annotations[AnnotationPersistedAt])
+		if err != nil {
+			log.Printf("Job %s/%s has no valid persisted time: %v", job.Namespace, job.Name, err)
+			continue
+		}
+
+		retention := r.config.FailedRetention
+		if job.Labels[LabelStatus] == StatusSucceeded {
+			retention = r.config.SucceededRetention
+		}
+		if now.Sub(persistedAt) < retention {
+			continue
+		}
+
+		deleted, err := r.k.deleteJob(job.Name, job.Namespace)
+		pods += deleted
+		if err != nil {
+			log.Printf("Failed to delete job %s/%s: %v", job.Namespace, job.Name, err)
+			continue
+		}
+		jobs++
+	}
+
+	if r.config.MaxAge > 0 {
+		swept, sweptPods, err := r.sweep(now)
+		jobs += swept
+		pods += sweptPods
+		if err != nil {
+			log.Printf("Failed to sweep unlabelled jobs: %v", err)
+		}
+	}
+
+	r.stats.Runs++
+	r.stats.JobsDeleted += int64(jobs)
+	r.stats.PodsDeleted += int64(pods)
+	r.stats.LastRun = now
+
+	if jobs > 0 {
+		log.Printf("Reaper deleted %d jobs and %d pods", jobs, pods)
+	}
+	return jobs, pods, nil
+}
+
+// sweep deletes the function jobs never labelled by MarkLogsPersisted
+// that finished and are older than the max age. It returns the number
+// of jobs and pods deleted.
+func (r *Reaper) sweep(now time.Time) (int, int, error) {
+	selector, err := labels.Parse(LabelExecution + ",!" + LabelLogsPersisted)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	joblist, err := r.k.Clientset.Batch().Jobs(api.NamespaceAll).List(api.ListOptions{
+		LabelSelector: selector,
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	jobs, pods := 0, 0
+	for _, job := range joblist.Items {
+		if !jobFinished(&job) || now.Sub(job.CreationTimestamp.Time) < r.config.MaxAge {
+			continue
+		}
+
+		deleted, err := r.k.deleteJob(job.Name, job.Namespace)
+		pods += deleted
+		if err != nil {
+			log.Printf("Failed to delete job %s/%s: %v", job.Namespace, job.Name, err)
+			continue
+		}
+		log.Printf("Reaper deleted unlabelled job %s/%s", job.Namespace, job.Name)
+		jobs++
+	}
+	return jobs, pods, nil
+}
+
+// jobFinished tells whether a job completed or failed.
+func jobFinished(job *batchv1.Job) bool {
+	for _, condition := range job.Status.Conditions {
+		if (condition.Type == batchv1.JobComplete || condition.Type == batchv1.JobFailed) &&
+			condition.Status == v1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteJob deletes a job, then its pods. Jobs do not delete their pods
+// themselves. It returns the number of pods deleted.
+func (k *Kexec) deleteJob(jobName, namespace string) (int, error) {
+	if err := k.Clientset.Batch().Jobs(namespace).Delete(jobName, &api.DeleteOptions{}); err != nil {
+		return 0, err
+	}
+
+	podlist, err := k.getFunctionPods(jobName, namespace)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, pod := range podlist.Items {
+		if err := k.Clientset.Core().Pods(namespace).Delete(pod.Name, &api.DeleteOptions{}); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2016 Xuan Tang. All rights reserved.
+Use of this source code is governed by a license
+that can be found in the LICENSE file.
+*/
+
+package kexec
+
+import (
+	"fmt"
+
+	"k8s.io/client-go/1.4/pkg/api/resource"
+	v1 "k8s.io/client-go/1.4/pkg/api/v1"
+)
+
+// ResourceLimits are the compute resources and the deadline of a
+// function job. Quantities use the kubernetes notation (eg "500m",
+// "128Mi"); empty quantities are left unset.
+type ResourceLimits struct {
+	CPURequest    string
+	CPULimit      string
+	MemoryRequest string
+	MemoryLimit   string
+
+	// Max runtime of the job in seconds. 0 means no deadline.
+	MaxRuntime int64
+}
+
+// WithDefaults returns a copy of the limits in which every unset value
+// is taken from `defaults`.
+func (l ResourceLimits) WithDefaults(defaults ResourceLimits) ResourceLimits {
+	if l.CPURequest == "" {
+		l.CPURequest = defaults.CPURequest
+	}
+	if l.CPULimit == "" {
+		l.CPULimit = defaults.CPULimit
+	}
+	if l.MemoryRequest == "" {
+		l.MemoryRequest = defaults.MemoryRequest
+	}
+	if l.MemoryLimit == "" {
+		l.MemoryLimit = defaults.MemoryLimit
+	}
+	if l.MaxRuntime == 0 {
+		l.MaxRuntime = defaults.MaxRuntime
+	}
+	return l
+}
+
+// Validate checks that all quantities can be parsed, that requests do
+// not exceed limits, and that nothing exceeds `max`. Unset values in
+// `max` are not enforced.
+func (l ResourceLimits) Validate(max ResourceLimits) error {
+	pairs := []struct {
+		name           string
+		value, ceiling string
+	}{
+		{"CPU request", l.CPURequest, l.CPULimit},
+		{"memory request", l.MemoryRequest, l.MemoryLimit},
+		{"CPU request", l.CPURequest, max.CPULimit},
+		{"CPU limit", l.CPULimit, max.CPULimit},
+		{"memory request", l.MemoryRequest, max.MemoryLimit},
+		{"memory limit", l.MemoryLimit, max.MemoryLimit},
+	}
+
+	for _, p := range pairs {
+		if p.value == "" {
+			continue
+		}
+		value, err := resource.ParseQuantity(p.value)
+		if err != nil {
+			return fmt.Errorf("Invalid %s %q: %v", p.name, p.value, err)
+		}
+		if p.ceiling == "" {
+			continue
+		}
+		ceiling, err := resource.ParseQuantity(p.ceiling)
+		if err != nil {
+			return fmt.Errorf("Invalid limit %q: %v", p.ceiling, err)
+		}
+		if value.Cmp(ceiling) > 0 {
+			return fmt.Errorf("The %s %s exceeds %s", p.name, p.value, p.ceiling)
+		}
+	}
+
+	if l.MaxRuntime < 0 {
+		return fmt.Errorf("Invalid max runtime %d", l.MaxRuntime)
+	}
+	if max.MaxRuntime > 0 && (l.MaxRuntime == 0 || l.MaxRuntime > max.MaxRuntime) {
+		return fmt.Errorf("The max runtime must be between 1 and %d seconds", max.MaxRuntime)
+	}
+
+	return nil
+}
+
+// resourceRequirements converts the limits into the resource
+// requirements of a container.
+func (l ResourceLimits) resourceRequirements() (v1.ResourceRequirements, error) {
+	requirements := v1.ResourceRequirements{
+		Requests: v1.ResourceList{},
+		Limits:   v1.ResourceList{},
+	}
+
+	quantities := []struct {
+		list  v1.ResourceList
+		name  v1.ResourceName
+		value string
+	}{
+		{requirements.Requests, v1.ResourceCPU, l.CPURequest},
+		{requirements.Limits, v1.ResourceCPU, l.CPULimit},
+		{requirements.Requests, v1.ResourceMemory, l.MemoryRequest},
+		{requirements.Limits, v1.ResourceMemory, l.MemoryLimit},
+	}
+
+	for _, q := range quantities {
+		if q.value == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(q.value)
+		if err != nil {
+			return requirements, err
+		}
+		q.list[q.name] = quantity
+	}
+
+	return requirements, nil
+}
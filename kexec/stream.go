@@ -0,0 +1,150 @@
+/*
+Copyright 2016 Xuan Tang. All rights reserved.
+Use of this source code is governed by a license
+that can be found in the LICENSE file.
+*/
+
+package kexec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	v1 "k8s.io/client-go/1.4/pkg/api/v1"
+)
+
+var (
+	// Interval between two checks of the pods of a job while waiting
+	// for one of them to start
+	PollInterval = time.Second
+)
+
+// StreamFunctionLog streams the log of a function execution while the
+// function runs. It waits up to `timeout` for the first pod of the job
+// to start, then follows its log. Pods the job runs after it, eg
+// retries, are followed next, each preceded by a header line with the
+// pod name and attempt number as in GetFunctionLog.
+//
+// The caller must close the returned reader. Closing it stops the
+// stream.
+func (k *Kexec) StreamFunctionLog(jobName, namespace string, timestamps bool, timeout time.Duration) (io.ReadCloser, error) {
+	pod, err := k.waitForPodStarted(jobName, namespace, "", timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	r, w := io.Pipe()
+	go func() {
+		streamed := make(map[string]bool)
+		for attempt := 1; pod != nil; attempt++ {
+			streamed[pod.Name] = true
+			if attempt > 1 {
+				fmt.Fprintf(w, "==> pod %s (attempt %d) <==\n", pod.Name, attempt)
+			}
+
+			if err := k.copyPodLog(w, pod.Name, namespace, timestamps); err != nil {
+				w.CloseWithError(err)
+				return
+			}
+
+			next, err := k.nextPod(jobName, namespace, streamed, timeout)
+			if err != nil {
+				w.CloseWithError(err)
+				return
+			}
+			pod = next
+		}
+		w.Close()
+	}()
+
+	return r, nil
+}
+
+// StreamFirstPodLog streams the log of the first pod of a job, like
+// StreamFunctionLog, but ends when that pod terminates instead of
+// following the pods the job runs after it.
+func (k *Kexec) StreamFirstPodLog(jobName, namespace string, timeout time.Duration) (io.ReadCloser, error) {
+	pod, err := k.waitForPodStarted(jobName, namespace, "", timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(k.copyPodLog(w, pod.Name, namespace, false))
+	}()
+
+	return r, nil
+}
+
+// copyPodLog follows the log of a pod until the pod terminates.
+func (k *Kexec) copyPodLog(w io.Writer, podName, namespace string, timestamps bool) error {
+	opts := &v1.PodLogOptions{
+		Follow:     true,
+		Timestamps: timestamps,
+	}
+
+	response, err := k.Clientset.Core().Pods(namespace).GetLogs(podName, opts).Stream()
+	if err != nil {
+		return err
+	}
+	defer response.Close()
+
+	_, err = io.Copy(w, response)
+	return err
+}
+
+// nextPod returns the first pod of a job that has not been streamed yet,
+// once it started. It returns nil if there is no such pod.
+func (k *Kexec) nextPod(jobName, namespace string, streamed map[string]bool, timeout time.Duration) (*v1.Pod, error) {
+	podlist, err := k.getFunctionPods(jobName, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	pods := podsByStartTime(podlist.Items)
+	sort.Sort(pods)
+
+	for _, pod := range pods {
+		if !streamed[pod.Name] {
+			return k.waitForPodStarted(jobName, namespace, pod.Name, timeout)
+		}
+	}
+	return nil, nil
+}
+
+// waitForPodStarted polls the pods of a job until the pod `podName`, or
+// the first pod of the job if `podName` is empty, leaves the pending
+// phase.
+func (k *Kexec) waitForPodStarted(jobName, namespace, podName string, timeout time.Duration) (*v1.Pod, error) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		podlist, err := k.getFunctionPods(jobName, namespace)
+		if err != nil {
+			return nil, err
+		}
+
+		pods := podsByStartTime(podlist.Items)
+		sort.Sort(pods)
+
+		for i := range pods {
+			pod := &pods[i]
+			if podName != "" && pod.Name != podName {
+				continue
+			}
+			if pod.Status.Phase != v1.PodPending {
+				return pod, nil
+			}
+			break
+		}
+
+		if time.Now().After(deadline) {
+			return nil, errors.New("Timeout to wait for job to start")
+		}
+		time.Sleep(PollInterval)
+	}
+}
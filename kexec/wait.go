@@ -0,0 +1,251 @@
+/*
+Copyright 2016 Xuan Tang. All rights reserved.
+Use of this source code is governed by a license
+that can be found in the LICENSE file.
+*/
+
+package kexec
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"k8s.io/client-go/1.4/pkg/api"
+	apierrors "k8s.io/client-go/1.4/pkg/api/errors"
+	v1 "k8s.io/client-go/1.4/pkg/api/v1"
+	batchv1 "k8s.io/client-go/1.4/pkg/apis/batch/v1"
+	"k8s.io/client-go/1.4/pkg/labels"
+	"k8s.io/client-go/1.4/pkg/watch"
+)
+
+var (
+	// Used by WaitForPodComplete when no timeout is given
+	DefaultWaitTimeout = time.Hour
+)
+
+// Reasons for a function execution to fail, as reported by PodError.
+const (
+	ReasonTimeout          = "Timeout"
+	ReasonDeadlineExceeded = "DeadlineExceeded"
+	ReasonImagePull        = "ImagePullFailed"
+	ReasonCrashLoop        = "CrashLoopBackOff"
+	ReasonUnschedulable    = "Unschedulable"
+	ReasonOOMKilled        = "OOMKilled"
+	ReasonFailed           = "Failed"
+	ReasonPodDeleted       = "PodDeleted"
+	ReasonUnknown          = "Unknown"
+)
+
+// Reasons of a waiting container that will not start by itself.
+var terminalWaitingReasons = map[string]string{
+	"ErrImagePull":      ReasonImagePull,
+	"ImagePullBackOff":  ReasonImagePull,
+	"InvalidImageName":  ReasonImagePull,
+	"ErrImageNeverPull": ReasonImagePull,
+	"CrashLoopBackOff":  ReasonCrashLoop,
+}
+
+// PodResult is the outcome of the pod which ran a function execution.
+type PodResult struct {
+	PodName  string
+	Phase    v1.PodPhase
+	ExitCode int32
+
+	// Termination message of the function container
+	Message string
+}
+
+// PodError is returned by WaitForPodComplete when a function execution
+// did not succeed. Reason is one of the Reason* constants.
+type PodError struct {
+	Reason   string
+	Message  string
+	ExitCode int32
+}
+
+func (e *PodError) Error() string {
+	switch e.Reason {
+	case ReasonFailed:
+		return fmt.Sprintf("Function failed with exit code %d", e.ExitCode)
+	case ReasonTimeout:
+		return "Timeout to wait for job completes"
+	}
+	if e.Message != "" {
+		return e.Reason + ": " + e.Message
+	}
+	return e.Reason
+}
+
+// WaitForPodComplete waits up to `timeout` for a pod of the job to
+// succeed or fail, and returns its result.
+//
+// Besides failed pods, pods that cannot start (image pull errors,
+// unschedulable pods, crash loops) and pods deleted before completing
+// end the wait with a PodError. If the watch is closed by the server,
+// it is resumed from the last resource version seen.
+//
+// A job starts a new pod when its pod fails, so the job of a failed
+// execution is stopped: it starts no more pods, and its failed pods are
+// kept for their logs. When the timeout is reached, the job is deleted,
+// so it does not keep running with no one waiting for it.
+func (k *Kexec) WaitForPodComplete(jobName, namespace string, timeout time.Duration) (*PodResult, error) {
+	if timeout <= 0 {
+		timeout = DefaultWaitTimeout
+	}
+	deadline := time.After(timeout)
+
+	// Create job label selector
+	jobLabelSelector := labels.SelectorFromSet(labels.Set{
+		"job-name": jobName,
+	})
+
+	resourceVersion := ""
+	for {
+		// Watch pods according to `jobLabelSelector`
+		listOptions := api.ListOptions{
+			Watch:           true,
+			LabelSelector:   jobLabelSelector,
+			ResourceVersion: resourceVersion,
+		}
+
+		w, err := k.Clientset.Core().Pods(namespace).Watch(listOptions)
+		if err != nil {
+			return nil, err
+		}
+
+		result, done, err := watchPods(w, deadline, &resourceVersion)
+		w.Stop()
+		if e, ok := err.(*PodError); ok {
+			k.endFailedJob(jobName, namespace, e)
+		}
+		if done {
+			return result, err
+		}
+		log.Printf("Watch of job %s closed, resuming from version %q", jobName, resourceVersion)
+	}
+}
+
+// endFailedJob deletes the job of a wait which timed out, and stops the
+// job of a failed execution.
+func (k *Kexec) endFailedJob(jobName, namespace string, e *PodError) {
+	var err error
+	if e.Reason == ReasonTimeout {
+		_, err = k.deleteJob(jobName, namespace)
+	} else {
+		err = k.stopJob(jobName, namespace)
+	}
+	if err != nil {
+		log.Printf("Failed to end job %s/%s: %v", namespace, jobName, err)
+	}
+}
+
+// stopJob sets the parallelism of a job to 0, so the job controller
+// deletes its running pods and starts no new one. Finished pods are kept.
+func (k *Kexec) stopJob(jobName, namespace string) error {
+	jobs := k.Clientset.Batch().Jobs(namespace)
+
+	var err error
+	for i := 0; i < MarkRetries; i++ {
+		var job *batchv1.Job
+		job, err = jobs.Get(jobName)
+		if err != nil {
+			return err
+		}
+
+		stopped := int32(0)
+		job.Spec.Parallelism = &stopped
+		_, err = jobs.Update(job)
+		if !apierrors.IsConflict(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// watchPods reads the events of a pod watch until a pod completes, the
+// deadline is reached or the watch is closed. In the latter case `done`
+// is false, and the watch can be resumed from `resourceVersion`.
+func watchPods(w watch.Interface, deadline <-chan time.Time, resourceVersion *string) (result *PodResult, done bool, err error) {
+	for {
+		select {
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return nil, false, nil
+			}
+
+			pod, ok := event.Object.(*v1.Pod)
+			if !ok {
+				// Most likely the resource version is too old.
+				// Start over from the current state.
+				log.Printf("Unexpected watch event %s: %v", event.Type, event.Object)
+				*resourceVersion = ""
+				return nil, false, nil
+			}
+			*resourceVersion = pod.ResourceVersion
+
+			log.Println("Pod status:", pod.Status.Phase)
+			if result, done, err = podOutcome(pod); done {
+				return result, true, err
+			}
+			if event.Type == watch.Deleted {
+				msg := fmt.Sprintf("Pod %s was deleted before completing", pod.Name)
+				return result, true, &PodError{Reason: ReasonPodDeleted, Message: msg, ExitCode: -1}
+			}
+		case <-deadline:
+			return nil, true, &PodError{Reason: ReasonTimeout}
+		}
+	}
+}
+
+// podOutcome tells whether a pod is done, successfully or not.
+func podOutcome(pod *v1.Pod) (*PodResult, bool, error) {
+	result := &PodResult{
+		PodName:  pod.Name,
+		Phase:    pod.Status.Phase,
+		ExitCode: -1,
+	}
+
+	var terminated *v1.ContainerStateTerminated
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Terminated != nil {
+			terminated = status.State.Terminated
+		}
+		if waiting := status.State.Waiting; waiting != nil {
+			if reason, ok := terminalWaitingReasons[waiting.Reason]; ok {
+				return result, true, &PodError{Reason: reason, Message: waiting.Message, ExitCode: -1}
+			}
+		}
+	}
+	if terminated != nil {
+		result.ExitCode = terminated.ExitCode
+		result.Message = terminated.Message
+	}
+
+	switch pod.Status.Phase {
+	case v1.PodSucceeded:
+		return result, true, nil
+
+	case v1.PodFailed:
+		podErr := &PodError{Reason: ReasonFailed, Message: pod.Status.Message, ExitCode: result.ExitCode}
+		if pod.Status.Reason == ReasonDeadlineExceeded {
+			podErr.Reason = ReasonDeadlineExceeded
+		} else if terminated != nil && terminated.Reason == ReasonOOMKilled {
+			podErr.Reason = ReasonOOMKilled
+		}
+		return result, true, podErr
+
+	case v1.PodUnknown:
+		return result, true, &PodError{Reason: ReasonUnknown, Message: pod.Status.Reason, ExitCode: -1}
+
+	case v1.PodPending:
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == v1.PodScheduled && condition.Status == v1.ConditionFalse &&
+				condition.Reason == ReasonUnschedulable {
+				return result, true, &PodError{Reason: ReasonUnschedulable, Message: condition.Message, ExitCode: -1}
+			}
+		}
+	}
+
+	return result, false, nil
+}
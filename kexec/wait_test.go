@@ -0,0 +1,248 @@
+package kexec
+
+import (
+	"testing"
+	"time"
+
+	unversioned "k8s.io/client-go/1.4/pkg/api/unversioned"
+	v1 "k8s.io/client-go/1.4/pkg/api/v1"
+	"k8s.io/client-go/1.4/pkg/watch"
+)
+
+// newPod returns a pod of a function job in a phase, with its container
+// in a state.
+func newPod(resourceVersion string, phase v1.PodPhase, state v1.ContainerState) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			Name:            "gorilla-1234",
+			ResourceVersion: resourceVersion,
+		},
+		Status: v1.PodStatus{
+			Phase: phase,
+			ContainerStatuses: []v1.ContainerStatus{
+				{Name: "gorilla", State: state},
+			},
+		},
+	}
+}
+
+func terminated(exitCode int32, reason, message string) v1.ContainerState {
+	return v1.ContainerState{
+		Terminated: &v1.ContainerStateTerminated{ExitCode: exitCode, Reason: reason, Message: message},
+	}
+}
+
+func waiting(reason string) v1.ContainerState {
+	return v1.ContainerState{
+		Waiting: &v1.ContainerStateWaiting{Reason: reason, Message: reason + " message"},
+	}
+}
+
+func running() v1.ContainerState {
+	return v1.ContainerState{Running: &v1.ContainerStateRunning{}}
+}
+
+func TestPodOutcome(t *testing.T) {
+	deadlineExceeded := newPod("1", v1.PodFailed, running())
+	deadlineExceeded.Status.Reason = "DeadlineExceeded"
+
+	unschedulable := newPod("1", v1.PodPending, v1.ContainerState{})
+	unschedulable.Status.Conditions = []v1.PodCondition{
+		{Type: v1.PodScheduled, Status: v1.ConditionFalse, Reason: "Unschedulable", Message: "no nodes"},
+	}
+
+	scheduling := newPod("1", v1.PodPending, v1.ContainerState{})
+	scheduling.Status.Conditions = []v1.PodCondition{
+		{Type: v1.PodScheduled, Status: v1.ConditionFalse},
+	}
+
+	tests := []struct {
+		name     string
+		pod      *v1.Pod
+		done     bool
+		reason   string // of the PodError, "" if none
+		exitCode int32
+		message  string // of the result
+	}{
+		{"succeeded", newPod("1", v1.PodSucceeded, terminated(0, "Completed", "result")), true, "", 0, "result"},
+		{"failed", newPod("1", v1.PodFailed, terminated(3, "Error", "")), true, ReasonFailed, 3, ""},
+		{"deadline exceeded", deadlineExceeded, true, ReasonDeadlineExceeded, -1, ""},
+		{"oom killed", newPod("1", v1.PodFailed, terminated(137, "OOMKilled", "")), true, ReasonOOMKilled, 137, ""},
+		{"unschedulable", unschedulable, true, ReasonUnschedulable, -1, ""},
+		{"image pull back off", newPod("1", v1.PodPending, waiting("ImagePullBackOff")), true, ReasonImagePull, -1, ""},
+		{"image pull error", newPod("1", v1.PodPending, waiting("ErrImagePull")), true, ReasonImagePull, -1, ""},
+		{"crash loop", newPod("1", v1.PodRunning, waiting("CrashLoopBackOff")), true, ReasonCrashLoop, -1, ""},
+		{"unknown", newPod("1", v1.PodUnknown, v1.ContainerState{}), true, ReasonUnknown, -1, ""},
+		{"scheduling", scheduling, false, "", -1, ""},
+		{"creating", newPod("1", v1.PodPending, waiting("ContainerCreating")), false, "", -1, ""},
+		{"running", newPod("1", v1.PodRunning, running()), false, "", -1, ""},
+	}
+
+	for _, test := range tests {
+		result, done, err := podOutcome(test.pod)
+		if done != test.done {
+			t.Errorf("%s: done = %v, want %v", test.name, done, test.done)
+		}
+		if result.PodName != test.pod.Name || result.Phase != test.pod.Status.Phase {
+			t.Errorf("%s: result is for pod %s in phase %s", test.name, result.PodName, result.Phase)
+		}
+		if result.ExitCode != test.exitCode {
+			t.Errorf("%s: exit code = %d, want %d", test.name, result.ExitCode, test.exitCode)
+		}
+		if result.Message != test.message {
+			t.Errorf("%s: message = %q, want %q", test.name, result.Message, test.message)
+		}
+
+		if test.reason == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", test.name, err)
+			}
+			continue
+		}
+		podErr, ok := err.(*PodError)
+		if !ok {
+			t.Errorf("%s: error = %v, want a PodError", test.name, err)
+			continue
+		}
+		if podErr.Reason != test.reason {
+			t.Errorf("%s: reason = %s, want %s", test.name, podErr.Reason, test.reason)
+		}
+		if podErr.ExitCode != test.exitCode {
+			t.Errorf("%s: error exit code = %d, want %d", test.name, podErr.ExitCode, test.exitCode)
+		}
+	}
+}
+
+func TestWatchPods(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// Sent on the watch before it is closed, unless open is set
+		events func(w *watch.FakeWatcher)
+		open   bool
+
+		done            bool
+		reason          string // of the PodError, "" if none
+		resourceVersion string // once the watch ends
+	}{
+		{
+			name: "succeeded",
+			events: func(w *watch.FakeWatcher) {
+				w.Add(newPod("1", v1.PodPending, waiting("ContainerCreating")))
+				w.Modify(newPod("2", v1.PodRunning, running()))
+				w.Modify(newPod("3", v1.PodSucceeded, terminated(0, "Completed", "")))
+			},
+			done:            true,
+			resourceVersion: "3",
+		},
+		{
+			name: "failed",
+			events: func(w *watch.FakeWatcher) {
+				w.Add(newPod("1", v1.PodRunning, running()))
+				w.Modify(newPod("2", v1.PodFailed, terminated(1, "Error", "")))
+			},
+			done:            true,
+			reason:          ReasonFailed,
+			resourceVersion: "2",
+		},
+		{
+			name: "image pull back off",
+			events: func(w *watch.FakeWatcher) {
+				w.Add(newPod("1", v1.PodPending, waiting("ContainerCreating")))
+				w.Modify(newPod("2", v1.PodPending, waiting("ImagePullBackOff")))
+			},
+			done:            true,
+			reason:          ReasonImagePull,
+			resourceVersion: "2",
+		},
+		{
+			// Resumed from the last version seen
+			name: "closed",
+			events: func(w *watch.FakeWatcher) {
+				w.Add(newPod("1", v1.PodPending, waiting("ContainerCreating")))
+				w.Modify(newPod("2", v1.PodRunning, running()))
+			},
+			resourceVersion: "2",
+		},
+		{
+			// Resumed from the current state
+			name: "expired",
+			events: func(w *watch.FakeWatcher) {
+				w.Add(newPod("1", v1.PodRunning, running()))
+				w.Error(&unversioned.Status{Status: unversioned.StatusFailure, Reason: unversioned.StatusReasonExpired})
+			},
+			resourceVersion: "",
+		},
+		{
+			name: "deleted",
+			events: func(w *watch.FakeWatcher) {
+				w.Add(newPod("1", v1.PodRunning, running()))
+				w.Delete(newPod("2", v1.PodRunning, running()))
+			},
+			done:            true,
+			reason:          ReasonPodDeleted,
+			resourceVersion: "2",
+		},
+		{
+			// A pod deleted once done keeps its outcome
+			name: "deleted once succeeded",
+			events: func(w *watch.FakeWatcher) {
+				w.Delete(newPod("2", v1.PodSucceeded, terminated(0, "Completed", "")))
+			},
+			done:            true,
+			resourceVersion: "2",
+		},
+		{
+			name: "timeout",
+			events: func(w *watch.FakeWatcher) {
+				w.Add(newPod("1", v1.PodRunning, running()))
+			},
+			open:            true,
+			done:            true,
+			reason:          ReasonTimeout,
+			resourceVersion: "1",
+		},
+	}
+
+	for _, test := range tests {
+		w := watch.NewFakeWithChanSize(10)
+		test.events(w)
+		if !test.open {
+			w.Stop()
+		}
+
+		// Only reached once the events are read, as the deadline is
+		// picked at random when an event is pending too
+		deadline := make(chan time.Time, 1)
+		if test.open {
+			go func() {
+				for len(w.ResultChan()) > 0 {
+					time.Sleep(time.Millisecond)
+				}
+				deadline <- time.Now()
+			}()
+		}
+
+		resourceVersion := "0"
+		result, done, err := watchPods(w, deadline, &resourceVersion)
+		if done != test.done {
+			t.Errorf("%s: done = %v, want %v", test.name, done, test.done)
+		}
+		if resourceVersion != test.resourceVersion {
+			t.Errorf("%s: resource version = %q, want %q", test.name, resourceVersion, test.resourceVersion)
+		}
+		if test.done && test.reason != ReasonTimeout && result == nil {
+			t.Errorf("%s: no result", test.name)
+		}
+
+		if test.reason == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", test.name, err)
+			}
+			continue
+		}
+		if podErr, ok := err.(*PodError); !ok || podErr.Reason != test.reason {
+			t.Errorf("%s: error = %v, want a PodError with reason %s", test.name, err, test.reason)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/xuant/go-kexec/dal"
+	"github.com/xuant/go-kexec/kexec"
+	"github.com/xuant/go-kexec/naming"
+)
+
+// runMigrate runs the migrate command. It brings the schema of the DB to
+// the latest version, or the version given with -to, which can be older
+// than the current one. With -dry-run, it lists the migrations it would
+// run instead.
+func runMigrate(d dal.DAL, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	to := flags.Int("to", -1, "Schema version to migrate to. Default the latest")
+	dryRun := flags.Bool("dry-run", false, "List the pending migrations without running them")
+	flags.Parse(args)
+
+	m, ok := d.(dal.Migrator)
+	if !ok {
+		fmt.Println("DB has no schema to migrate")
+		return nil
+	}
+
+	current, err := m.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	target := *to
+	if target < 0 {
+		target = m.LatestVersion()
+	}
+
+	if *dryRun {
+		steps, err := m.PlanMigration(target)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Schema version %d, %d migrations pending to version %d\n", current, len(steps), target)
+		for _, step := range steps {
+			fmt.Println(step)
+		}
+		return nil
+	}
+
+	steps, err := m.Migrate(target)
+	for _, step := range steps {
+		fmt.Println("Ran", step)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Schema version %d\n", target)
+	return nil
+}
+
+// migrateOnStartup brings the schema of the DB to the latest version
+// when the server starts, unless migrations are manual, in which case
+// the schema must already be at the latest version.
+func migrateOnStartup(d dal.DAL, config *dal.DalConfig) error {
+	m, ok := d.(dal.Migrator)
+	if !ok {
+		return nil
+	}
+	if config.ManualMigrations {
+		return dal.CheckSchema(m)
+	}
+
+	steps, err := m.Migrate(m.LatestVersion())
+	for _, step := range steps {
+		log.Printf("Ran migration %s", step)
+	}
+	return err
+}
+
+// runMigrateNamespaces runs the migrate-namespaces command. Functions of
+// users with '_' in their name used to run in the namespace named after
+// the user name with '_' replaced by '-', which can be the namespace of
+// another user. The jobs of their executions are deleted from these
+// legacy namespaces, and the legacy namespaces no user runs functions in
+// anymore are deleted. With -dry-run, it lists what it would delete
+// instead.
+func runMigrateNamespaces(d dal.DAL, k *kexec.Kexec, args []string) error {
+	flags := flag.NewFlagSet("migrate-namespaces", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "List the jobs and namespaces to delete without deleting them")
+	flags.Parse(args)
+
+	users, err := d.ListUsers()
+	if err != nil {
+		return err
+	}
+
+	// Users running functions in each namespace now
+	owners := make(map[string]string)
+	for _, user := range users {
+		owners[naming.Namespace(user)] = user
+	}
+
+	deleted := make(map[string]bool)
+	for _, user := range users {
+		legacy := naming.LegacyNamespace(user)
+		if legacy == "" || deleted[legacy] {
+			continue
+		}
+
+		if owner, ok := owners[legacy]; ok {
+			jobs, err := legacyJobs(d, user, legacy)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Deleting %d jobs of user %s from namespace %s of user %s\n", len(jobs), user, legacy, owner)
+			if *dryRun {
+				continue
+			}
+			for _, job := range jobs {
+				if err := k.DeleteJobIfExists(job, legacy); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		fmt.Printf("Deleting namespace %s of user %s\n", legacy, user)
+		deleted[legacy] = true
+		if *dryRun {
+			continue
+		}
+		if err := k.DeleteNamespace(legacy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// legacyJobs lists the jobs of the executions of a user in a legacy
+// namespace. Executions recorded before their job and namespace were
+// record neither: they ran in the legacy namespace, as <function>-<uuid>.
+func legacyJobs(d dal.DAL, userName, namespace string) ([]string, error) {
+	functions, err := d.ListFunctionsOfUser(namespace, userName, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []string
+	for _, function := range functions {
+		executions, err := d.ListExecutionsOfFunction(userName, function.Name, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, exe := range executions {
+			switch {
+			case exe.Namespace == "":
+				jobs = append(jobs, function.Name+"-"+exe.UUID)
+			case exe.Namespace == namespace:
+				jobs = append(jobs, exe.JobName)
+			}
+		}
+	}
+	return jobs, nil
+}
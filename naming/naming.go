@@ -0,0 +1,143 @@
+/*
+Copyright 2016 Xuan Tang. All rights reserved.
+Use of this source code is governed by a license
+that can be found in the LICENSE file.
+*/
+
+// Package naming maps user and function names to the names of the
+// kubernetes objects and docker images created for them.
+//
+// Kubernetes names (namespaces, jobs, labels) must be DNS-1123 labels:
+// at most 63 lower case alphanumeric characters or '-', starting and
+// ending with an alphanumeric character. Image repositories have
+// similar rules. User names come from LDAP and function names from
+// users, so they are sanitized. The mapping is deterministic, and a
+// name which had to be changed gets a 32-bit hash of the original name
+// appended, so two different names are unlikely to map to the same
+// object, although it cannot be ruled out.
+//
+// Valid names are kept as they are, so the namespaces and images of
+// most existing users and functions do not change. A valid name which
+// looks like a sanitized one, ie ends with '-' and 8 hex digits, is
+// sanitized too, so it cannot take the name of another one.
+package naming
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strings"
+)
+
+const (
+	// Max length of a DNS-1123 label
+	MaxLength = 63
+
+	// Suffix of user namespaces
+	NamespaceSuffix = "-serverless"
+
+	// Length of the execution uuid in job names
+	uuidLength = 36
+
+	// Length of the hash appended to sanitized names, with its '-'
+	hashLength = 9
+)
+
+var (
+	labelRegexp        = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+	repoPathRegexp     = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|[-]*)[a-z0-9]+)*$`)
+	functionNameRegexp = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+	groupNameRegexp    = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+	invalidRegexp      = regexp.MustCompile(`[^a-z0-9]+`)
+	hashedRegexp       = regexp.MustCompile(`(^|-)[0-9a-f]{8}$`)
+)
+
+// Label maps a name to a DNS-1123 label.
+func Label(name string) string {
+	return fit(name, MaxLength)
+}
+
+// Namespace returns the namespace the functions of a user run in. The
+// functions of a group run in the namespace of the account owning them,
+// whose name is not a valid label, so it is sanitized.
+func Namespace(userName string) string {
+	return fit(userName, MaxLength-len(NamespaceSuffix)) + NamespaceSuffix
+}
+
+// LegacyNamespace returns the namespace the functions of a user ran in
+// when namespaces were named after the user name with '_' replaced by
+// '-', if it is not the namespace they run in now. It returns "" when
+// there is no such namespace.
+func LegacyNamespace(userName string) string {
+	legacy := strings.Replace(userName, "_", "-", -1) + NamespaceSuffix
+	if len(legacy) > MaxLength || !labelRegexp.MatchString(legacy) || legacy == Namespace(userName) {
+		return ""
+	}
+	return legacy
+}
+
+// JobName returns the name of the job of a function execution.
+func JobName(functionName, executionID string) string {
+	return fit(functionName, MaxLength-uuidLength-1) + "-" + executionID
+}
+
+// Image returns the image of a function of a user in a registry,
+// without tag. Names that are valid components of a repository path,
+// which may have '.' and '_', are kept as they are.
+func Image(registry, userName, functionName string) string {
+	return registry + "/" + repoPath(userName) + "/" + repoPath(functionName)
+}
+
+// ValidateFunctionName checks a function name given by a user.
+func ValidateFunctionName(name string) error {
+	if len(name) > MaxLength || !functionNameRegexp.MatchString(name) {
+		return fmt.Errorf("Invalid function name %q: it must start with a letter, "+
+			"contain only letters, digits and underscores, and have at most %d characters",
+			name, MaxLength)
+	}
+	return nil
+}
+
+// ValidateGroupName checks the name of a group, given by an admin or
+// read from LDAP.
+func ValidateGroupName(name string) error {
+	if len(name) > MaxLength || !groupNameRegexp.MatchString(name) {
+		return fmt.Errorf("Invalid group name %q: it must start with a letter or a digit, "+
+			"contain only letters, digits, '_', '.' and '-', and have at most %d characters",
+			name, MaxLength)
+	}
+	return nil
+}
+
+// repoPath maps a name to a component of a repository path.
+func repoPath(name string) string {
+	if len(name) <= MaxLength && repoPathRegexp.MatchString(name) && !hashedRegexp.MatchString(name) {
+		return name
+	}
+	return Label(name)
+}
+
+// fit maps a name to a DNS-1123 label of at most max characters. Valid
+// names that fit are kept as they are, unless they look like a sanitized
+// name. Other names are lower cased, runs of invalid characters are
+// replaced by '-', and a hash of the original name is appended.
+func fit(name string, max int) string {
+	if len(name) <= max && labelRegexp.MatchString(name) && !hashedRegexp.MatchString(name) {
+		return name
+	}
+
+	sanitized := invalidRegexp.ReplaceAllString(strings.ToLower(name), "-")
+	if len(sanitized) > max-hashLength {
+		sanitized = sanitized[:max-hashLength]
+	}
+	sanitized = strings.Trim(sanitized, "-")
+
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	hash := fmt.Sprintf("%08x", h.Sum32())
+
+	if sanitized == "" {
+		return hash
+	}
+	return sanitized + "-" + hash
+}
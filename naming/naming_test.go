@@ -0,0 +1,156 @@
+package naming
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLabel(t *testing.T) {
+	valid := []string{"gorilla", "foo-bar", "a1"}
+	for _, name := range valid {
+		if got := Label(name); got != name {
+			t.Errorf("Label(%q) = %q, want it unchanged", name, got)
+		}
+	}
+
+	sanitized := []string{
+		"default_function",
+		"John.Doe",
+		"UPPER",
+		"-dash-",
+		"__",
+		strings.Repeat("x", 100),
+	}
+	seen := make(map[string]string)
+	for _, name := range sanitized {
+		got := Label(name)
+		if len(got) > MaxLength || !labelRegexp.MatchString(got) {
+			t.Errorf("Label(%q) = %q, not a DNS-1123 label", name, got)
+		}
+		if got != Label(name) {
+			t.Errorf("Label(%q) is not deterministic", name)
+		}
+		if other, ok := seen[got]; ok {
+			t.Errorf("Label(%q) and Label(%q) are both %q", name, other, got)
+		}
+		seen[got] = name
+	}
+
+	// Names which would sanitize the same way must not collide
+	if Label("foo_bar") == Label("foo.bar") || Label("foo_bar") == Label("foo-bar") {
+		t.Errorf("Labels of foo_bar, foo.bar and foo-bar collide")
+	}
+
+	// Valid names which look sanitized are sanitized too, so they do not
+	// collide with the names they look like
+	for _, name := range []string{"deadbeef", Label("Bob")} {
+		if got := Label(name); got == name {
+			t.Errorf("Label(%q) is unchanged", name)
+		}
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	if got := Namespace("xuant"); got != "xuant-serverless" {
+		t.Errorf("Namespace(xuant) = %q", got)
+	}
+
+	// '_' is not replaced by '-' anymore, which made user names collide
+	if Namespace("a_b") == Namespace("a-b") {
+		t.Errorf("Namespaces of a_b and a-b are both %q", Namespace("a-b"))
+	}
+	if got := Namespace("a-b"); got != "a-b-serverless" {
+		t.Errorf("Namespace(a-b) = %q", got)
+	}
+	bob := strings.TrimSuffix(Namespace("Bob"), NamespaceSuffix)
+	if Namespace(bob) == Namespace("Bob") {
+		t.Errorf("Namespaces of %s and Bob are both %q", bob, Namespace("Bob"))
+	}
+
+	got := Namespace(strings.Repeat("John.Doe", 10))
+	if len(got) > MaxLength || !labelRegexp.MatchString(got) || !strings.HasSuffix(got, NamespaceSuffix) {
+		t.Errorf("Namespace of a long user name = %q", got)
+	}
+
+	// Groups run in the namespace of their owner account, which no user
+	// can have, even one named after its sanitized name
+	group := Namespace("group:team")
+	if !labelRegexp.MatchString(group) {
+		t.Errorf("Namespace of group team = %q", group)
+	}
+	sanitized := strings.TrimSuffix(group, NamespaceSuffix)
+	for _, user := range []string{"group-team", sanitized} {
+		if got := Namespace(user); got == group {
+			t.Errorf("Namespace(%q) is the namespace of group team, %q", user, got)
+		}
+	}
+}
+
+func TestLegacyNamespace(t *testing.T) {
+	if got := LegacyNamespace("john_doe"); got != "john-doe-serverless" {
+		t.Errorf("LegacyNamespace(john_doe) = %q", got)
+	}
+
+	for _, name := range []string{"xuant", "John.Doe", "group:team"} {
+		if got := LegacyNamespace(name); got != "" {
+			t.Errorf("LegacyNamespace(%q) = %q", name, got)
+		}
+	}
+}
+
+func TestImage(t *testing.T) {
+	if got := Image("registry:5000", "john_doe", "default_function"); got != "registry:5000/john_doe/default_function" {
+		t.Errorf("Image of a valid repository = %q", got)
+	}
+	if got := Image("registry:5000", "john-doe", "f"); got == Image("registry:5000", "john_doe", "f") {
+		t.Errorf("Images of john-doe and john_doe are both %q", got)
+	}
+
+	got := Image("registry:5000", "John.Doe", "Hello")
+	if !strings.HasPrefix(got, "registry:5000/john-doe-") || strings.Contains(got, "Hello") {
+		t.Errorf("Image of upper case names = %q", got)
+	}
+}
+
+func TestJobName(t *testing.T) {
+	uuid := "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+
+	if got := JobName("gorilla", uuid); got != "gorilla-"+uuid {
+		t.Errorf("JobName(gorilla) = %q", got)
+	}
+
+	for _, name := range []string{"default_function", strings.Repeat("Function", 10)} {
+		got := JobName(name, uuid)
+		if len(got) > MaxLength || !labelRegexp.MatchString(got) || !strings.HasSuffix(got, uuid) {
+			t.Errorf("JobName(%q) = %q", name, got)
+		}
+	}
+}
+
+func TestValidateFunctionName(t *testing.T) {
+	for _, name := range []string{"default_function", "foo", "Foo2"} {
+		if err := ValidateFunctionName(name); err != nil {
+			t.Errorf("ValidateFunctionName(%q) = %v", name, err)
+		}
+	}
+
+	for _, name := range []string{"", "2foo", "foo-bar", "foo.bar", "foo bar", strings.Repeat("x", 64)} {
+		if err := ValidateFunctionName(name); err == nil {
+			t.Errorf("ValidateFunctionName(%q) succeeded", name)
+		}
+	}
+}
+
+func TestValidateGroupName(t *testing.T) {
+	for _, name := range []string{"team", "Team-A", "ops.eu", "42"} {
+		if err := ValidateGroupName(name); err != nil {
+			t.Errorf("ValidateGroupName(%q) = %v", name, err)
+		}
+	}
+
+	for _, name := range []string{"", "-team", "team a", "a/b", "group:team", strings.Repeat("x", 64)} {
+		if err := ValidateGroupName(name); err == nil {
+			t.Errorf("ValidateGroupName(%q) succeeded", name)
+		}
+	}
+}
@@ -80,6 +80,12 @@ var routes = Routes{
 		"/create",
 		CreateFunctionHandler,
 	},
+	Route{
+		"Runtimes",
+		"GET",
+		"/runtimes",
+		ListRuntimesHandler,
+	},
 	Route{
 		"Call",
 		"GET",
@@ -92,4 +98,94 @@ var routes = Routes{
 		"/call/{username}/{function}",
 		CallFunctionHandler,
 	},
+	Route{
+		"CallGroup",
+		"POST",
+		"/call/groups/{group}/{function}",
+		CallFunctionHandler,
+	},
+	Route{
+		"Execution",
+		"GET",
+		"/executions/{id}",
+		GetExecutionHandler,
+	},
+	Route{
+		"ExecutionLogs",
+		"GET",
+		"/executions/{id}/logs",
+		GetExecutionLogsHandler,
+	},
+	Route{
+		"Executions",
+		"GET",
+		"/functions/{function}/executions",
+		ListExecutionsHandler,
+	},
+	Route{
+		"Build",
+		"GET",
+		"/builds/{id}",
+		GetBuildHandler,
+	},
+	Route{
+		"BuildLog",
+		"GET",
+		"/functions/{function}/builds/{id}/log",
+		GetBuildLogHandler,
+	},
+	Route{
+		"DeleteFunction",
+		"DELETE",
+		"/functions/{function}",
+		DeleteFunctionHandler,
+	},
+	Route{
+		"Versions",
+		"GET",
+		"/functions/{function}/versions",
+		ListVersionsHandler,
+	},
+	Route{
+		"Rollback",
+		"POST",
+		"/functions/{function}/rollback",
+		RollbackFunctionHandler,
+	},
+	Route{
+		"Groups",
+		"GET",
+		"/groups",
+		ListGroupsHandler,
+	},
+	Route{
+		"CreateGroup",
+		"POST",
+		"/groups",
+		CreateGroupHandler,
+	},
+	Route{
+		"Group",
+		"GET",
+		"/groups/{group}",
+		GetGroupHandler,
+	},
+	Route{
+		"AddGroupMember",
+		"PUT",
+		"/groups/{group}/members/{user}",
+		AddGroupMemberHandler,
+	},
+	Route{
+		"RemoveGroupMember",
+		"DELETE",
+		"/groups/{group}/members/{user}",
+		RemoveGroupMemberHandler,
+	},
+	Route{
+		"Reaper",
+		"GET",
+		"/reaper",
+		ReaperStatsHandler,
+	},
 }
@@ -0,0 +1,292 @@
+/*
+Copyright 2016 Xuan Tang. All rights reserved.
+Use of this source code is governed by a license
+that can be found in the LICENSE file.
+*/
+
+// Package runtimes loads the runtimes functions can be written for.
+//
+// A runtime is described by a directory holding a descriptor file,
+// runtime.json, and the templates it refers to:
+//
+//	{
+//	  "Name": "python27",
+//	  "DisplayName": "Python 2.7",
+//	  "BaseImage": "python:2.7",
+//	  "EditorMode": "python",
+//	  "FileName": "exec",
+//	  "HandlerFile": "handler.py",
+//	  "Dockerfile": "Dockerfile.tmpl",
+//	  "Wrapper": "wrapper.tmpl",
+//	  "Files": {}
+//	}
+//
+// The wrapper template turns the code of a user into the execution
+// file, FileName. The Dockerfile template, and the templates of the
+// extra Files, are written to the build context next to it. Templates
+// use text/template; see TemplateData for what they are given.
+// Dockerfile templates install the dependencies of a function when its
+// build context holds a manifest:
+//
+//	{{if .Has "requirements.txt"}}RUN pip install -r requirements.txt{{end}}
+//
+// Adding a runtime only takes adding a directory to the runtimes
+// directory and restarting the server.
+package runtimes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/template"
+)
+
+var (
+	// Name of the descriptor file of a runtime
+	DescriptorFile = "runtime.json"
+
+	// Name of the Dockerfile written to build contexts
+	RelDockerfile = "Dockerfile"
+
+	// Environment variables set by kexec on function containers: the
+	// parameters, the file the result is written to, and the context
+	// of the call
+	ParamsEnv      = "SERVERLESS_PARAMS"
+	ResultFileEnv  = "SERVERLESS_RESULT_FILE"
+	ExecutionIDEnv = "SERVERLESS_EXECUTION_ID"
+	FunctionEnv    = "SERVERLESS_FUNCTION"
+
+	// Result file used when ResultFileEnv is not set
+	ResultFile = "/dev/termination-log"
+
+	// Size results are limited to. The result file is the termination
+	// message of the container, which kubernetes cuts at 4096 bytes.
+	// Wrappers replace larger results by a ResultTooLarge error.
+	MaxResultSize = 4096
+)
+
+// Runtime is a runtime loaded from its descriptor.
+type Runtime struct {
+	Name        string
+	DisplayName string
+	BaseImage   string
+
+	// Mode of the code editor of the UI
+	EditorMode string
+
+	// Name of the execution file in the build context
+	FileName string
+
+	// Name of the file holding the handler in function packages
+	HandlerFile string
+
+	// Template file names, relative to the runtime directory
+	Dockerfile string
+	Wrapper    string
+
+	// Extra files of the build context: name in the context to
+	// template file name
+	Files map[string]string
+
+	dockerfile *template.Template
+	wrapper    *template.Template
+	files      map[string]*template.Template
+}
+
+// TemplateData is what runtime templates are rendered with.
+type TemplateData struct {
+	BaseImage      string
+	FileName       string
+	FunctionName   string
+	ParamsEnv      string
+	ResultFileEnv  string
+	ExecutionIDEnv string
+	FunctionEnv    string
+	ResultFile     string
+	MaxResultSize  int
+
+	// Code of the user. Only set for the wrapper template.
+	Code string
+
+	ctxDir string
+}
+
+// Has tells whether the build context holds a file, eg a dependency
+// manifest. It is always false in the wrapper template.
+func (d *TemplateData) Has(name string) bool {
+	if d.ctxDir == "" {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(d.ctxDir, name))
+	return err == nil
+}
+
+// Registry holds the runtimes loaded at startup.
+type Registry struct {
+	runtimes map[string]*Runtime
+}
+
+// Load loads the runtimes described in the subdirectories of `dir`.
+// Subdirectories without a descriptor file are ignored.
+func Load(dir string) (*Registry, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	registry := &Registry{
+		runtimes: make(map[string]*Runtime),
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		runtimeDir := filepath.Join(dir, entry.Name())
+		if _, err := os.Stat(filepath.Join(runtimeDir, DescriptorFile)); os.IsNotExist(err) {
+			continue
+		}
+
+		runtime, err := loadRuntime(runtimeDir)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load runtime %s: %v", runtimeDir, err)
+		}
+		if _, ok := registry.runtimes[runtime.Name]; ok {
+			return nil, fmt.Errorf("Runtime %s defined twice", runtime.Name)
+		}
+		registry.runtimes[runtime.Name] = runtime
+	}
+
+	return registry, nil
+}
+
+func loadRuntime(dir string) (*Runtime, error) {
+	descriptor, err := ioutil.ReadFile(filepath.Join(dir, DescriptorFile))
+	if err != nil {
+		return nil, err
+	}
+
+	runtime := &Runtime{}
+	if err := json.Unmarshal(descriptor, runtime); err != nil {
+		return nil, err
+	}
+
+	if runtime.Name == "" || runtime.FileName == "" || runtime.Dockerfile == "" || runtime.Wrapper == "" {
+		return nil, fmt.Errorf("Name, FileName, Dockerfile and Wrapper are required")
+	}
+	if runtime.DisplayName == "" {
+		runtime.DisplayName = runtime.Name
+	}
+	if runtime.HandlerFile != "" && filepath.Base(runtime.HandlerFile) != runtime.HandlerFile {
+		return nil, fmt.Errorf("Invalid handler file name %q", runtime.HandlerFile)
+	}
+
+	if runtime.dockerfile, err = template.ParseFiles(filepath.Join(dir, runtime.Dockerfile)); err != nil {
+		return nil, err
+	}
+	if runtime.wrapper, err = template.ParseFiles(filepath.Join(dir, runtime.Wrapper)); err != nil {
+		return nil, err
+	}
+
+	runtime.files = make(map[string]*template.Template)
+	for name, file := range runtime.Files {
+		if filepath.Base(name) != name || name == runtime.FileName || name == RelDockerfile {
+			return nil, fmt.Errorf("Invalid extra file name %q", name)
+		}
+		if runtime.files[name], err = template.ParseFiles(filepath.Join(dir, file)); err != nil {
+			return nil, err
+		}
+	}
+
+	return runtime, nil
+}
+
+// Get returns the runtime with the given name.
+func (r *Registry) Get(name string) (*Runtime, bool) {
+	runtime, ok := r.runtimes[name]
+	return runtime, ok
+}
+
+// List returns all the runtimes, sorted by name.
+func (r *Registry) List() []*Runtime {
+	names := make([]string, 0, len(r.runtimes))
+	for name := range r.runtimes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := make([]*Runtime, 0, len(names))
+	for _, name := range names {
+		list = append(list, r.runtimes[name])
+	}
+	return list
+}
+
+func (r *Runtime) data(functionName string) *TemplateData {
+	return &TemplateData{
+		BaseImage:      r.BaseImage,
+		FileName:       r.FileName,
+		FunctionName:   functionName,
+		ParamsEnv:      ParamsEnv,
+		ResultFileEnv:  ResultFileEnv,
+		ExecutionIDEnv: ExecutionIDEnv,
+		FunctionEnv:    FunctionEnv,
+		ResultFile:     ResultFile,
+		MaxResultSize:  MaxResultSize,
+	}
+}
+
+// WrapCode renders the wrapper template around the code of a user. The
+// result is the content of the execution file.
+func (r *Runtime) WrapCode(code, functionName string) (string, error) {
+	data := r.data(functionName)
+	data.Code = code
+
+	var buf bytes.Buffer
+	if err := r.wrapper.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// ReservedFiles returns the names of the files the runtime writes to
+// build contexts. Function packages may not hold them.
+func (r *Runtime) ReservedFiles() []string {
+	names := []string{r.FileName, RelDockerfile}
+	for name := range r.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WriteContext writes the Dockerfile and the extra files of the runtime
+// to a build context directory. The templates see the files already in
+// the directory.
+func (r *Runtime) WriteContext(ctxDir, functionName string) error {
+	data := r.data(functionName)
+	data.ctxDir = ctxDir
+
+	if err := writeTemplate(r.dockerfile, data, filepath.Join(ctxDir, RelDockerfile)); err != nil {
+		return err
+	}
+	for name, tmpl := range r.files {
+		if err := writeTemplate(tmpl, data, filepath.Join(ctxDir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeTemplate(tmpl *template.Template, data *TemplateData, path string) error {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
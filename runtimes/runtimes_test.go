@@ -0,0 +1,143 @@
+package runtimes
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The runtimes shipped with go-kexec live next to this file.
+func TestLoad(t *testing.T) {
+	registry, err := Load(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0)
+	for _, runtime := range registry.List() {
+		names = append(names, runtime.Name)
+	}
+	if got := strings.Join(names, ","); got != "bash,go,node,python27,python3,ruby" {
+		t.Errorf("Loaded runtimes %s", got)
+	}
+
+	if _, ok := registry.Get("cobol"); ok {
+		t.Errorf("Got an unknown runtime")
+	}
+}
+
+func TestWrapCode(t *testing.T) {
+	registry, err := Load(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := "def handler(event, context):\n    return event"
+	for _, runtime := range registry.List() {
+		wrapped, err := runtime.WrapCode(code, "foo")
+		if err != nil {
+			t.Errorf("%s: %v", runtime.Name, err)
+			continue
+		}
+		if strings.Contains(wrapped, "<no value>") {
+			t.Errorf("%s: wrapped code has missing values:\n%s", runtime.Name, wrapped)
+		}
+		if !strings.Contains(wrapped, code) {
+			t.Errorf("%s: wrapped code does not hold the code of the user", runtime.Name)
+		}
+
+		// The go runtime calls the handler from main.go
+		if runtime.Name == "go" {
+			continue
+		}
+		if !strings.Contains(wrapped, "ResultTooLarge") {
+			t.Errorf("%s: wrapped code does not check the size of results", runtime.Name)
+		}
+		for _, env := range []string{ParamsEnv, ResultFileEnv, ExecutionIDEnv, FunctionEnv} {
+			if !strings.Contains(wrapped, env) {
+				t.Errorf("%s: wrapped code does not read %s", runtime.Name, env)
+			}
+		}
+	}
+
+	python27, _ := registry.Get("python27")
+	wrapped, _ := python27.WrapCode(code, "foo")
+	if !strings.Contains(wrapped, `{"result": handler(event, context)}`) {
+		t.Errorf("Wrapped code does not call the handler:\n%s", wrapped)
+	}
+}
+
+func TestWriteContext(t *testing.T) {
+	registry, err := Load(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctxDir, err := ioutil.TempDir("", "runtimes-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(ctxDir)
+
+	golang, _ := registry.Get("go")
+	if err := golang.WriteContext(ctxDir, "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	dockerfile, err := ioutil.ReadFile(filepath.Join(ctxDir, RelDockerfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(dockerfile), "FROM golang:1.7\n") {
+		t.Errorf("Dockerfile:\n%s", dockerfile)
+	}
+
+	main, err := ioutil.ReadFile(filepath.Join(ctxDir, "main.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(main), "handler(event, context)") || strings.Contains(string(main), "<no value>") {
+		t.Errorf("main.go:\n%s", main)
+	}
+}
+
+func TestWriteContextManifest(t *testing.T) {
+	registry, err := Load(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	python27, _ := registry.Get("python27")
+
+	ctxDir, err := ioutil.TempDir("", "runtimes-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(ctxDir)
+
+	install := "RUN pip install --no-cache-dir -r requirements.txt\n"
+
+	if err := python27.WriteContext(ctxDir, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	dockerfile, _ := ioutil.ReadFile(filepath.Join(ctxDir, RelDockerfile))
+	if strings.Contains(string(dockerfile), install) {
+		t.Errorf("Dependencies installed without a manifest:\n%s", dockerfile)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(ctxDir, "requirements.txt"), []byte("requests\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := python27.WriteContext(ctxDir, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	dockerfile, _ = ioutil.ReadFile(filepath.Join(ctxDir, RelDockerfile))
+	if !strings.Contains(string(dockerfile), install) {
+		t.Errorf("Dependencies not installed:\n%s", dockerfile)
+	}
+
+	if got := strings.Join(python27.ReservedFiles(), ","); got != "Dockerfile,exec" {
+		t.Errorf("Reserved files %s", got)
+	}
+}
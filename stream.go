@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// How long a log stream waits for the function to start
+	StreamStartTimeout = 2 * time.Minute
+)
+
+// wantsStream tells whether the client asked for the output of a call
+// to be streamed while the function runs.
+func wantsStream(request *http.Request) bool {
+	return request.URL.Query().Get("stream") == "true" || wantsEventStream(request)
+}
+
+func wantsEventStream(request *http.Request) bool {
+	return strings.Contains(request.Header.Get("Accept"), "text/event-stream")
+}
+
+// streamLog copies a function log to the client line by line, flushing
+// each line as soon as it is read.
+//
+// The format is selected by the Accept header: Server-Sent Events for
+// "text/event-stream", plain text with chunked transfer encoding
+// otherwise. Server-Sent Events carry one line per "data" field and the
+// stream is closed by an "end" event.
+func streamLog(response http.ResponseWriter, request *http.Request, executionID string, r io.ReadCloser) error {
+	defer r.Close()
+
+	sse := wantsEventStream(request)
+	if sse {
+		response.Header().Set("Content-Type", "text/event-stream")
+		response.Header().Set("Cache-Control", "no-cache")
+	} else {
+		response.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	response.Header().Set("X-Execution-Id", executionID)
+	response.Header().Set("X-Content-Type-Options", "nosniff")
+	response.WriteHeader(http.StatusOK)
+
+	flusher, _ := response.(http.Flusher)
+
+	// Stop reading the log when the client goes away
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-request.Context().Done():
+			r.Close()
+		case <-done:
+		}
+	}()
+
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			if sse {
+				_, werr := io.WriteString(response, "data: "+strings.TrimRight(line, "\n")+"\n\n")
+				if werr != nil {
+					return nil
+				}
+			} else if _, werr := io.WriteString(response, line); werr != nil {
+				return nil
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Headers are sent already, so the error can only be
+			// reported in the stream itself.
+			log.Printf("Log stream of execution %s broken: %v", executionID, err)
+			if sse {
+				io.WriteString(response, "event: error\ndata: "+err.Error()+"\n\n")
+			}
+			return nil
+		}
+	}
+
+	if sse {
+		io.WriteString(response, "event: end\ndata: "+executionID+"\n\n")
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	return nil
+}
@@ -4,9 +4,12 @@ import (
 	"net/http"
 
 	"github.com/gorilla/securecookie"
+	"github.com/xuant/go-kexec/builder"
+	"github.com/xuant/go-kexec/bundle"
 	"github.com/xuant/go-kexec/dal"
 	"github.com/xuant/go-kexec/docker"
 	"github.com/xuant/go-kexec/kexec"
+	"github.com/xuant/go-kexec/runtimes"
 )
 
 // Error represents a handler error. It provides methods for a HTTP status
@@ -40,21 +43,110 @@ func (se StatusError) Message() string {
 
 type appConfig struct {
 	FileServerDir  string
+	RuntimesDir    string
 	DockerRegistry string
+
+	// Credentials of DockerRegistry for the docker builder, or a
+	// docker client config file holding them (eg
+	// ~/.docker/config.json). The file wins if set.
+	RegistryAuth     docker.RegistryAuth
+	RegistryAuthFile string
+
+	// Registries the images of registered functions may come from,
+	// besides DockerRegistry. "docker.io" is the default registry.
+	AllowedRegistries []string
+
+	// Registries reached over http when they do not answer https, like
+	// the insecure registries of the docker daemon
+	InsecureRegistries []string
+
+	// Backend and connection settings of the DAL. KEXEC_DB_* env
+	// variables override them.
+	DB dal.DalConfig
+
+	// Users managing the groups and their members
+	Admins []string
+
 	LDAPcfg        ldapConfig
+	Limits         limitsConfig
+	MaxParallelism int
+
+	// Most parameters of a fan-out call, and of one the caller waits for
+	MaxFanOutItems     int
+	MaxSyncFanOutItems int
+
+	// Seconds to wait for an execution to complete
+	ExecutionTimeout int
+
+	Reaper reaperConfig
+
+	// What user namespaces are provisioned with
+	Namespace kexec.NamespaceTemplate
+
+	// Bounds of the function packages users upload
+	Packages bundle.Limits
+
+	// Backend building function images
+	Builder builder.Config
+
+	Builds buildsConfig
+}
+type buildsConfig struct {
+	// Builds running at the same time
+	Workers int
+
+	// Builds waiting for a worker. Functions cannot be created while
+	// the queue is full.
+	QueueSize int
+}
+type reaperConfig struct {
+	// Seconds to keep the jobs of succeeded and failed executions
+	// once their logs are persisted
+	SucceededRetention int
+	FailedRetention    int
+
+	// Seconds between two runs. 0 disables the reaper.
+	Interval int
+
+	// Seconds to keep finished jobs whose logs were never persisted.
+	// 0 keeps them.
+	MaxAge int
+}
+type limitsConfig struct {
+	// Applied to the values a function does not set itself
+	Default kexec.ResourceLimits
+	// Upper bounds for the values of any function. Unset values
+	// are not enforced.
+	Max kexec.ResourceLimits
 }
 type ldapConfig struct {
 	LDAPServer  []string
 	LDAPPort    int
 	LDAPRetries int
 	LDAPBaseDn  string
+
+	// Sync the groups of users from LDAP when they log in: the groups
+	// under LDAPGroupBaseDn matching LDAPGroupFilter, in which %s is
+	// the DN of the user, named by their LDAPGroupAttribute. The
+	// filter and attribute default to DefaultLDAPGroupFilter and
+	// DefaultLDAPGroupAttribute.
+	LDAPSyncGroups     bool
+	LDAPGroupBaseDn    string
+	LDAPGroupFilter    string
+	LDAPGroupAttribute string
 }
 type appContext struct {
-	d             *docker.Docker
 	k             *kexec.Kexec
 	dal           dal.DAL
 	cookieHandler *securecookie.SecureCookie
 	conf          *appConfig
+	executions    *executionStore
+	reaper        *kexec.Reaper
+	runtimes      *runtimes.Registry
+	builder       builder.Builder
+	registryAuth  *docker.RegistryAuth
+	builds        *buildQueue
+	functionLocks *functionLocks
 }
 type appRouteHandler func(*appContext, http.ResponseWriter, *http.Request) error
 type appHandler struct {
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuant/go-kexec/dal"
+	"github.com/xuant/go-kexec/docker"
+	"github.com/xuant/go-kexec/kexec"
+	"github.com/xuant/go-kexec/naming"
+)
+
+// Length of the code hash in image tags
+const versionTagLength = 16
+
+var (
+	errVersionNotFound = errors.New("Function version not found")
+
+	errFunctionExists = errors.New("Function already exists")
+)
+
+// versionInfo is a version of a function as listed by the versions
+// endpoint.
+type versionInfo struct {
+	Version     int       `json:"version"`
+	CodeHash    string    `json:"codeHash"`
+	Image       string    `json:"image"`
+	ImageDigest string    `json:"imageDigest,omitempty"`
+	Created     time.Time `json:"created"`
+}
+
+type versionList struct {
+	Function       string         `json:"function"`
+	DefaultVersion int            `json:"defaultVersion"`
+	Versions       []*versionInfo `json:"versions"`
+}
+
+func versionsFromRecords(records []*dal.Version) []*versionInfo {
+	versions := make([]*versionInfo, 0, len(records))
+	for _, r := range records {
+		versions = append(versions, &versionInfo{
+			Version:     r.Version,
+			CodeHash:    r.CodeHash,
+			Image:       r.Image,
+			ImageDigest: r.ImageDigest,
+			Created:     r.Created,
+		})
+	}
+	return versions
+}
+
+// parseFunctionVersion splits `function@version` into the function name
+// and the version number. Without a version, it returns version 0, ie
+// the default version of the function.
+func parseFunctionVersion(s string) (string, int, error) {
+	i := strings.LastIndex(s, "@")
+	if i < 0 {
+		return s, 0, nil
+	}
+
+	v, err := strconv.Atoi(s[i+1:])
+	if err != nil || v <= 0 {
+		return "", 0, fmt.Errorf("Invalid function version in %q", s)
+	}
+	return s[:i], v, nil
+}
+
+// versionImage returns the image of a version of a function, tagged
+// with the hash of its sources.
+func versionImage(a *appContext, userName, functionName, hash string) string {
+	return naming.Image(a.conf.DockerRegistry, userName, functionName) + ":" + hash[:versionTagLength]
+}
+
+// runImage returns the image a version of a function runs. Version 0
+// is the untagged image of functions created before versions.
+func runImage(a *appContext, userName, functionName string, version *dal.Version) string {
+	if version == nil || version.Version == 0 {
+		return naming.Image(a.conf.DockerRegistry, userName, functionName)
+	}
+	return imageOfVersion(version)
+}
+
+// imageOfVersion returns the image of a version: pinned by digest when
+// the digest is known, by tag otherwise.
+func imageOfVersion(version *dal.Version) string {
+	ref, err := docker.ParseImageReference(version.Image)
+	if err != nil || version.ImageDigest == "" {
+		return version.Image
+	}
+	ref.Digest = version.ImageDigest
+	return ref.String()
+}
+
+// putFunctionVersion records a new version of a function, and makes it
+// the default version of the function. The function is recorded with
+// `limits`. It creates the function, failing with errFunctionExists if
+// the function exists, unless `update` is set, in which case it updates
+// the function. Both are recorded in one transaction, so a conflict
+// leaves no version behind.
+func putFunctionVersion(a *appContext, userName string, version *dal.Version, limits kexec.ResourceLimits, update bool) error {
+	function := &dal.Function{
+		UserID:        -1,
+		Name:          version.FunctionName,
+		Content:       version.Content,
+		CPURequest:    limits.CPURequest,
+		CPULimit:      limits.CPULimit,
+		MemoryRequest: limits.MemoryRequest,
+		MemoryLimit:   limits.MemoryLimit,
+		MaxRuntime:    limits.MaxRuntime,
+		ImageDigest:   version.ImageDigest,
+	}
+	_, rowCnt, err := a.dal.PutFunctionVersion(userName, function, version, update)
+	if err != nil {
+		log.Printf("Failed to put version of function %s into DB: %v", version.FunctionName, err)
+		return err
+	}
+	if rowCnt == 0 {
+		return errFunctionExists
+	}
+	return nil
+}
+
+// getVersion gets a version of a function from DB, failing with
+// errVersionNotFound if the function has no such version.
+func getVersion(a *appContext, userName, functionName string, v int) (*dal.Version, error) {
+	version, err := a.dal.GetVersion(userName, functionName, v)
+	if err == sql.ErrNoRows {
+		return nil, errVersionNotFound
+	}
+	return version, err
+}
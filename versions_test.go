@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestParseFunctionVersion(t *testing.T) {
+	tests := []struct {
+		s            string
+		functionName string
+		version      int
+		invalid      bool
+	}{
+		{s: "hello", functionName: "hello"},
+		{s: "hello@1", functionName: "hello", version: 1},
+		{s: "hello@12", functionName: "hello", version: 12},
+		{s: "a@b@2", functionName: "a@b", version: 2},
+		{s: "hello@", invalid: true},
+		{s: "hello@0", invalid: true},
+		{s: "hello@-1", invalid: true},
+		{s: "hello@latest", invalid: true},
+		{s: "hello@1.5", invalid: true},
+	}
+
+	for _, test := range tests {
+		functionName, version, err := parseFunctionVersion(test.s)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%q: got %s version %d, want an error", test.s, functionName, version)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.s, err)
+			continue
+		}
+		if functionName != test.functionName || version != test.version {
+			t.Errorf("%q: got %s version %d, want %s version %d", test.s, functionName, version, test.functionName, test.version)
+		}
+	}
+}